@@ -3,8 +3,13 @@ package app
 import (
 	"errors"
 	"fmt"
+	"github.com/malinowskip/pal/config"
 	"github.com/malinowskip/pal/documents"
+	"github.com/malinowskip/pal/tokenizer"
+	"os"
+	"path/filepath"
 	"sort"
+	"unicode/utf8"
 
 	"github.com/dustin/go-humanize"
 	"github.com/urfave/cli/v2"
@@ -24,8 +29,8 @@ func Analyze(c *cli.Context) error {
 	}
 
 	// Default config values overridden by any values defined by the user in
-	// `pal.toml`.
-	finalConfig, err := resolveFinalConfig(projectPath)
+	// the config files, environment variables or flags.
+	finalConfig, err := resolveFinalConfig(c)
 	if err != nil {
 		return err
 	}
@@ -38,52 +43,261 @@ func Analyze(c *cli.Context) error {
 		return errors.Join(fmt.Errorf("The config is invalid."), err)
 	}
 
-	// Load all project documents that will be included in the context.
-	documents, err := documents.LoadDocuments(
+	// Load all project documents that will be included in the context. Files
+	// that didn’t change since the previous run are looked up in the cache,
+	// which also keeps their token counts.
+	cache := documents.OpenCache(projectPath)
+
+	// The cache only speeds up analysis, so failing to save it isn’t an error.
+	defer cache.Save()
+
+	loadedDocuments, skipped, err := documents.LoadDocuments(
 		projectPath,
+		documents.Source(finalConfig.Source),
+		finalConfig.Include,
 		finalConfig.Exclude,
+		selectionFromFlags(c),
 		maxFileSize.Int64(),
+		cache,
 	)
 	if err != nil {
 		return err
 	}
 
+	// Go files matching the `outline` patterns are reduced to their outlines.
+	outlined := documents.Outline(loadedDocuments, finalConfig.Outline)
+
+	// Secrets found in the documents are replaced with placeholders.
+	docs, redactions, err := redactDocuments(outlined, &finalConfig)
+	if err != nil {
+		return err
+	}
+
+	p := message.NewPrinter(language.English)
+	w := c.App.Writer
+
+	if c.IsSet("explain") {
+		explanation, err := explainPath(projectPath, c.String("explain"), outlined, loadedDocuments, skipped, &finalConfig)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(w, explanation)
+
+		return nil
+	}
+
 	// Concatenate all documents into a single string that will be passed to the
 	// LLM at the end of the system message.
-	context, err := assembleContextString(&documents)
+	context, err := assembleContextString(&docs)
 	if err != nil {
 		return err
 	}
 
-	p := message.NewPrinter(language.English)
+	// Tokens are counted using the tokenizer of the model selected in the config.
+	tokenizer := tokenizer.ForConfig(&finalConfig)
 
-	p.Println(
+	p.Fprintln(
+		w,
 		"Number of documents that would be included in the context:",
 	)
 
-	p.Printf("  %d\n\n", len(documents))
+	p.Fprintf(w, "  %d\n\n", len(docs))
 
-	p.Println(
+	p.Fprintln(
+		w,
 		"Full context string length:",
 	)
 
-	p.Printf(
-		"  %d characters\n\n",
-		len(context),
+	p.Fprintf(
+		w,
+		"  %d characters\n  %d tokens (%s)\n\n",
+		utf8.RuneCountInString(context),
+		tokenizer.CountTokens(context),
+		tokenizer.Name(),
 	)
 
-	sort.Slice(documents, func(i, j int) bool {
-		return len(documents[i].Content) > len(documents[j].Content)
+	// Show how much outlining saves, compared to including the files in full.
+	if len(finalConfig.Outline) > 0 {
+		fullContext, err := assembleContextString(&loadedDocuments)
+		if err != nil {
+			return err
+		}
+
+		outlinedContext, err := assembleContextString(&outlined)
+		if err != nil {
+			return err
+		}
+
+		outlinedFiles := 0
+		for i := range outlined {
+			if outlined[i].Content != loadedDocuments[i].Content {
+				outlinedFiles++
+			}
+		}
+
+		fullTokens := tokenizer.CountTokens(fullContext)
+		savedTokens := fullTokens - tokenizer.CountTokens(outlinedContext)
+
+		p.Fprintf(w, "Savings from outlining %d Go files:\n", outlinedFiles)
+		p.Fprintf(
+			w,
+			"  %d characters\n  %d tokens (%.0f%%)\n\n",
+			utf8.RuneCountInString(fullContext)-utf8.RuneCountInString(outlinedContext),
+			savedTokens,
+			100*float64(savedTokens)/float64(max(fullTokens, 1)),
+		)
+	}
+
+	// Ollama silently truncates prompts that don’t fit in the model’s context
+	// window, so the user should know in advance if this is likely to happen.
+	if finalConfig.Provider == "ollama" && finalConfig.Ollama.NumCtx > 0 {
+		fullSystemMessage := fmt.Sprintf("%s\n\n%s", finalConfig.SystemMessage, context)
+		estimatedTokens := tokenizer.CountTokens(fullSystemMessage)
+
+		if estimatedTokens > finalConfig.Ollama.NumCtx {
+			p.Fprintf(
+				w,
+				"Warning: the system message and the context (approximately %d tokens) exceed the configured \"ollama.num-ctx\" (%d tokens). Ollama will truncate the prompt.\n\n",
+				estimatedTokens,
+				finalConfig.Ollama.NumCtx,
+			)
+		}
+	}
+
+	// Number of tokens in each document, indexed by path.
+	tokenCounts := make(map[string]int, len(docs))
+	for _, d := range docs {
+		tokenCounts[d.Path] = cache.CountTokens(d.Path, d.Content, tokenizer)
+	}
+
+	sort.Slice(docs, func(i, j int) bool {
+		return tokenCounts[docs[i].Path] > tokenCounts[docs[j].Path]
 	})
 
-	p.Println("Fifteen largest documents:")
+	p.Fprintln(w, "Fifteen largest documents:")
 
-	for i, d := range documents {
+	for i, d := range docs {
 		if i > 14 {
 			break
 		}
-		fmt.Printf("  %s\n", d.Path)
+		p.Fprintf(w, "  %7d tokens  %s\n", tokenCounts[d.Path], d.Path)
+	}
+
+	if len(redactions) > 0 {
+		p.Fprintln(w, "\nSecrets that would be redacted:")
+
+		for _, redaction := range redactions {
+			p.Fprintf(w, "  %s:%d  (%s)\n", redaction.Path, redaction.Line, redaction.Detector)
+		}
+	}
+
+	if len(skipped) > 0 {
+		p.Fprintln(w, "\nExcluded files and directories:")
+
+		for _, entry := range skipped {
+			p.Fprintf(w, "  %s  (%s)\n", displayPath(entry), describeSkippedEntry(entry, &finalConfig))
+		}
 	}
 
 	return nil
 }
+
+// Explains why the file at the given path is included in or excluded from the
+// context. The path may be relative to the project root or absolute.
+func explainPath(
+	projectPath string,
+	path string,
+	docs []documents.Document,
+	loadedDocuments []documents.Document,
+	skipped []documents.SkippedEntry,
+	conf *config.Config,
+) (string, error) {
+	if filepath.IsAbs(path) {
+		absProjectPath, err := filepath.Abs(projectPath)
+		if err != nil {
+			return "", err
+		}
+
+		if path, err = filepath.Rel(absProjectPath, path); err != nil {
+			return "", err
+		}
+	}
+
+	path = filepath.Clean(path)
+
+	if entry := documents.FindSkippedEntry(skipped, path); entry != nil {
+		if entry.Path != path {
+			return fmt.Sprintf(
+				"%s: excluded, because the directory %s is excluded (%s)",
+				path,
+				displayPath(*entry),
+				describeSkippedEntry(*entry, conf),
+			), nil
+		}
+
+		return fmt.Sprintf("%s: excluded (%s)", path, describeSkippedEntry(*entry, conf)), nil
+	}
+
+	for i, doc := range docs {
+		if doc.Path == path {
+			if doc.Content != loadedDocuments[i].Content {
+				return fmt.Sprintf("%s: included as an outline (matches an \"outline\" pattern)", path), nil
+			}
+
+			return fmt.Sprintf("%s: included", path), nil
+		}
+	}
+
+	if info, err := os.Stat(filepath.Join(projectPath, path)); err == nil {
+		if info.IsDir() {
+			return fmt.Sprintf("%s: a directory; its files are included unless excluded individually", path), nil
+		}
+
+		// With the git sources, files missing from the git index (or, with
+		// `git-tracked`, from the last commit) are never visited.
+		switch documents.Source(conf.Source) {
+		case documents.SourceGitTracked:
+			return fmt.Sprintf("%s: excluded (not committed to git, and the \"source\" configuration value is %q)", path, conf.Source), nil
+		case documents.SourceGitStaged:
+			return fmt.Sprintf("%s: excluded (not tracked by git, and the \"source\" configuration value is %q)", path, conf.Source), nil
+		}
+	}
+
+	return "", fmt.Errorf("%s does not exist in the project.", path)
+}
+
+// Describes why an entry was left out of the context.
+func describeSkippedEntry(entry documents.SkippedEntry, conf *config.Config) string {
+	switch entry.Reason {
+	case documents.SkipReasonBuiltIn:
+		return "always excluded"
+	case documents.SkipReasonGitignore:
+		return fmt.Sprintf("matches %q in %s", entry.Pattern, entry.Source)
+	case documents.SkipReasonExclude:
+		return fmt.Sprintf("matches %q in the \"exclude\" configuration value", entry.Pattern)
+	case documents.SkipReasonInclude:
+		return "matches no pattern in the \"include\" configuration value"
+	case documents.SkipReasonSelectionExclude:
+		return fmt.Sprintf("matches %q passed to --exclude", entry.Pattern)
+	case documents.SkipReasonSelectionOnly:
+		return "matches no pattern passed to --only"
+	case documents.SkipReasonMaxFileSize:
+		return fmt.Sprintf("%s exceeds the \"max-file-size\" configuration value of %s", humanize.Bytes(uint64(entry.Size)), conf.MaxFileSize)
+	case documents.SkipReasonNotText:
+		return "not a UTF-8 text file"
+	case documents.SkipReasonUnreadable:
+		return "could not be read"
+	default:
+		return string(entry.Reason)
+	}
+}
+
+// Directories are marked by a trailing slash.
+func displayPath(entry documents.SkippedEntry) string {
+	if entry.IsDir {
+		return entry.Path + string(os.PathSeparator)
+	}
+
+	return entry.Path
+}
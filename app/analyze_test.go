@@ -0,0 +1,234 @@
+package app
+
+import (
+	"github.com/malinowskip/pal/config"
+	"github.com/malinowskip/pal/testutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	projectPath, _ := instantiateEnvironment(t)
+
+	if err := os.WriteFile(path.Join(projectPath, "README.md"), []byte("Hello, world!"), 0755); err != nil {
+		t.Error(err)
+	}
+
+	output, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "analyze"})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !strings.Contains(output, "  126 characters\n  32 tokens") {
+		t.Errorf("The output should contain the number of characters in the context. Output:\n%s", output)
+	}
+
+	if !strings.Contains(output, "        4 tokens  README.md\n") {
+		t.Errorf("The output should list the documents along with their token counts. Output:\n%s", output)
+	}
+
+	if strings.Contains(output, "Warning") {
+		t.Errorf("The output should not contain any warnings. Output:\n%s", output)
+	}
+}
+
+func TestAnalyzeWarnsIfContextExceedsOllamaContextWindow(t *testing.T) {
+	projectPath, _ := instantiateEnvironment(t)
+
+	conf, err := config.ResolveConfig(&config.Config{
+		Provider: "ollama",
+		Ollama:   config.OllamaConfig{NumCtx: 10},
+	})
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if err := saveConfigToFile(projectPath, conf); err != nil {
+		t.Error(err)
+	}
+
+	if err := os.WriteFile(path.Join(projectPath, "README.md"), []byte("Hello, world!"), 0755); err != nil {
+		t.Error(err)
+	}
+
+	output, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "analyze"})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !strings.Contains(output, `exceed the configured "ollama.num-ctx" (10 tokens)`) {
+		t.Errorf("The output should warn about the context window. Output:\n%s", output)
+	}
+}
+
+func TestAnalyzeReportsOutlineSavings(t *testing.T) {
+	projectPath, _ := instantiateEnvironment(t)
+
+	conf, err := config.ResolveConfig(&config.Config{
+		Provider: "testing",
+		Outline:  []string{"*.go"},
+	})
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if err := saveConfigToFile(projectPath, conf); err != nil {
+		t.Error(err)
+	}
+
+	source := "package main\n\n// Greet says hello.\nfunc Greet() {\n\tprintln(\"Hello, world!\")\n}\n"
+
+	if err := os.WriteFile(path.Join(projectPath, "main.go"), []byte(source), 0755); err != nil {
+		t.Error(err)
+	}
+
+	output, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "analyze"})
+	if err != nil {
+		t.Error(err)
+	}
+
+	// The function body, ` {\n\tprintln("Hello, world!")\n}`, is left out.
+	if !strings.Contains(output, "Savings from outlining 1 Go files:\n  30 characters\n") {
+		t.Errorf("The output should contain the savings from outlining. Output:\n%s", output)
+	}
+}
+
+func TestAnalyzeExplainsExcludedFiles(t *testing.T) {
+	projectPath, _ := instantiateEnvironment(t)
+
+	files := map[string]string{
+		".gitignore":       "build/\n",
+		"README.md":        "Hello, world!",
+		"build/output.txt": "output",
+	}
+
+	testutil.WriteTestFiles(t, projectPath, files)
+
+	t.Run("Lists excluded files", func(t *testing.T) {
+		output, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "analyze"})
+		if err != nil {
+			t.Error(err)
+		}
+
+		expectedLines := []string{
+			"  .pal/  (always excluded)\n",
+			"  build/  (matches \"build/\" in .gitignore)\n",
+			"  pal.toml  (matches \"pal.toml\" in the \"exclude\" configuration value)\n",
+		}
+
+		for _, line := range expectedLines {
+			if !strings.Contains(output, line) {
+				t.Errorf("The output should contain %q. Output:\n%s", line, output)
+			}
+		}
+	})
+
+	explanations := map[string]string{
+		"README.md":                        "README.md: included\n",
+		"./build/output.txt":               "build/output.txt: excluded, because the directory build/ is excluded (matches \"build/\" in .gitignore)\n",
+		path.Join(projectPath, "pal.toml"): "pal.toml: excluded (matches \"pal.toml\" in the \"exclude\" configuration value)\n",
+	}
+
+	for input, expectedOutput := range explanations {
+		t.Run("Explains "+input, func(t *testing.T) {
+			output, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "analyze", "--explain", input})
+			if err != nil {
+				t.Error(err)
+			}
+
+			testutil.AssertDeepEquals(t, output, expectedOutput)
+		})
+	}
+
+	t.Run("Returns an error for paths that don’t exist", func(t *testing.T) {
+		if _, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "analyze", "--explain", "missing.md"}); err == nil {
+			t.Error("Expected an error.")
+		}
+	})
+
+	t.Run("Explains files that aren’t included or selected", func(t *testing.T) {
+		t.Setenv("PAL_INCLUDE", "/docs")
+
+		output, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "analyze", "--explain", "README.md"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertDeepEquals(t, output, "README.md: excluded (matches no pattern in the \"include\" configuration value)\n")
+
+		output, err = runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "--include", "README.md", "--only", "*.go", "analyze", "--explain", "README.md"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertDeepEquals(t, output, "README.md: excluded (matches no pattern passed to --only)\n")
+	})
+}
+
+func TestAnalyzeExplainsUntrackedFiles(t *testing.T) {
+	projectPath, _ := instantiateEnvironment(t)
+
+	initRepository(t, projectPath, map[string]string{"main.go": "package main\n"})
+
+	if err := os.WriteFile(path.Join(projectPath, "scratch.txt"), []byte("Notes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	explanations := map[string]string{
+		"git-tracked": "scratch.txt: excluded (not committed to git, and the \"source\" configuration value is \"git-tracked\")\n",
+		"git-staged":  "scratch.txt: excluded (not tracked by git, and the \"source\" configuration value is \"git-staged\")\n",
+	}
+
+	for source, expectedOutput := range explanations {
+		t.Run(source, func(t *testing.T) {
+			t.Setenv("PAL_SOURCE", source)
+
+			output, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "analyze", "--explain", "scratch.txt"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			testutil.AssertDeepEquals(t, output, expectedOutput)
+		})
+	}
+
+	t.Run("Returns an error for paths that don’t exist", func(t *testing.T) {
+		t.Setenv("PAL_SOURCE", "git-tracked")
+
+		if _, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "analyze", "--explain", "missing.md"}); err == nil {
+			t.Error("Expected an error.")
+		}
+	})
+}
+
+func TestAnalyzeListsRedactedSecrets(t *testing.T) {
+	projectPath, _ := instantiateEnvironment(t)
+
+	if err := os.WriteFile(path.Join(projectPath, ".env"), []byte("DEBUG=true\nSTRIPE_SECRET_KEY=sk_live_123\n"), 0755); err != nil {
+		t.Error(err)
+	}
+
+	output, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "analyze"})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !strings.Contains(output, "Secrets that would be redacted:\n  .env:2  (key-assignment)\n") {
+		t.Errorf("The output should list the redacted secrets. Output:\n%s", output)
+	}
+
+	t.Run("Redaction can be disabled", func(t *testing.T) {
+		t.Setenv("PAL_REDACT_DISABLE", "all")
+
+		output, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "analyze"})
+		if err != nil {
+			t.Error(err)
+		}
+
+		if strings.Contains(output, "Secrets") {
+			t.Errorf("No secrets should be redacted. Output:\n%s", output)
+		}
+	})
+}
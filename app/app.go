@@ -3,12 +3,14 @@ package app
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"github.com/malinowskip/pal/config"
 	"github.com/malinowskip/pal/constants"
 	"github.com/malinowskip/pal/documents"
 	"github.com/malinowskip/pal/util"
 	"path"
+	"slices"
 	"strings"
 
 	"github.com/urfave/cli/v2"
@@ -37,6 +39,59 @@ var app = &cli.App{
 			Value:   false,
 			Aliases: []string{"c"},
 		},
+		&cli.Int64Flag{
+			Name:    "conversation",
+			Usage:   "Continue the conversation with the given id (see `pal history list`)",
+			Aliases: []string{"resume"},
+		},
+		&cli.StringFlag{
+			Name:  "profile",
+			Usage: "Applies the named profile defined in the config (overrides \"default-profile\")",
+		},
+		&cli.StringSliceFlag{
+			Name:  "include",
+			Usage: "Include files matching the pattern even if they are excluded, may be repeated",
+		},
+		&cli.StringSliceFlag{
+			Name:  "only",
+			Usage: "Only include files matching the pattern, may be repeated",
+		},
+		&cli.StringSliceFlag{
+			Name:  "exclude",
+			Usage: "Exclude files matching the pattern, may be repeated (in addition to the config)",
+		},
+		&cli.BoolFlag{
+			Name:  "diff",
+			Usage: "Add the uncommitted changes tracked by git to the context",
+		},
+		&cli.StringFlag{
+			Name:  "diff-base",
+			Usage: "Add the changes made since HEAD diverged from the given revision (`base`...HEAD) to the context instead",
+		},
+		&cli.BoolFlag{
+			Name:  "touched-only",
+			Usage: "With --diff or --diff-base, include only the changed files instead of the whole project",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Print the request that would be sent to the provider as JSON, without sending it",
+		},
+		&cli.IntFlag{
+			Name:  "max-tokens",
+			Usage: "Maximum number of tokens in the reply (overrides the config)",
+		},
+		&cli.Float64Flag{
+			Name:  "temperature",
+			Usage: "Sampling temperature (overrides the config)",
+		},
+		&cli.Float64Flag{
+			Name:  "top-p",
+			Usage: "Nucleus sampling probability (overrides the config)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "stop",
+			Usage: "Sequence that ends the reply, may be repeated (overrides the config)",
+		},
 	},
 	Action: StartOrContinueConversation,
 	Commands: []*cli.Command{
@@ -46,15 +101,85 @@ var app = &cli.App{
 			Action: InitProject,
 		},
 		{
-			Name:   "config",
-			Usage:  "Resolves and prints final configuration",
+			Name:      "review",
+			Usage:     "Asks the LLM to review the uncommitted changes or, given a base revision, the changes made since HEAD diverged from it",
+			ArgsUsage: "[base]",
+			Action:    Review,
+		},
+		{
+			Name:   "chat",
+			Usage:  "Starts an interactive conversation",
+			Action: Chat,
+		},
+		{
+			Name:  "config",
+			Usage: "Resolves and prints final configuration",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "sources",
+					Usage: "Shows which layer (defaults, config file, environment or flags) each value came from",
+				},
+			},
 			Action: PrintConfig,
 		},
 		{
-			Name:   "analyze",
-			Usage:  "Prints useful information on context length",
+			Name:  "analyze",
+			Usage: "Prints useful information on context length",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "explain",
+					Usage: "Explains why the file at the given path (relative to the project root) is included in or excluded from the context",
+				},
+			},
 			Action: Analyze,
 		},
+		{
+			Name:  "usage",
+			Usage: "Summarizes token usage and estimated cost of stored conversations",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "by",
+					Usage: "Groups usage by \"model\", \"day\" or \"conversation\" only (default: all three)",
+				},
+			},
+			Action: PrintUsage,
+		},
+		{
+			Name:  "history",
+			Usage: "Lists, shows and deletes stored conversations",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "list",
+					Usage: "Lists stored conversations, most recent first",
+					Flags: []cli.Flag{
+						&cli.IntFlag{
+							Name:    "limit",
+							Usage:   "Number of conversations per page",
+							Value:   20,
+							Aliases: []string{"n"},
+						},
+						&cli.IntFlag{
+							Name:  "page",
+							Usage: "Page of results to display, starting at 1",
+							Value: 1,
+						},
+					},
+					Action: ListHistory,
+				},
+				{
+					Name:      "show",
+					Usage:     "Prints the full transcript of a conversation",
+					ArgsUsage: "<id>",
+					Action:    ShowHistory,
+				},
+				{
+					Name:      "rm",
+					Usage:     "Deletes one or more conversations",
+					ArgsUsage: "<id> [<id>...]",
+					Action:    RemoveHistory,
+				},
+			},
+		},
 	},
 }
 
@@ -69,31 +194,61 @@ func assembleContextString(docs *[]documents.Document) (string, error) {
 	output.WriteString("<documents>\n")
 
 	for _, doc := range *docs {
-		output.WriteString("<document>\n")
-		output.WriteString(fmt.Sprintf("<source>%s</source>\n", doc.Path))
-		output.WriteString("<document_content>\n")
-		output.WriteString(doc.Content)
-		output.WriteString("</document_content>\n")
-		output.WriteString("</document>\n")
+		output.WriteString(formatDocument(doc))
 	}
 
 	output.WriteString("</documents>")
 	return output.String(), nil
 }
 
-// Loads and parses the `pal.toml` configuration file from the given project
-// path. Returns the parsed Config and any errors encountered while reading or
-// parsing the file.
-func fetchUserConfig(projectPath string) (config.Config, error) {
-	path := path.Join(projectPath, "pal.toml")
+// Formats a single document as it appears in the context string.
+func formatDocument(doc documents.Document) string {
+	var output strings.Builder
+
+	output.WriteString("<document>\n")
+	output.WriteString(fmt.Sprintf("<source>%s</source>\n", doc.Path))
+	output.WriteString("<document_content>\n")
+	output.WriteString(doc.Content)
+	output.WriteString("</document_content>\n")
+	output.WriteString("</document>\n")
+
+	return output.String()
+}
+
+// Formats the paths of the documents as a file tree, to be used as the context
+// with the `tools` context strategy, where the LLM reads the files it needs
+// through tool calls.
+func assembleFileTreeString(docs []documents.Document) string {
+	return fmt.Sprintf("<file_tree>\n%s</file_tree>", documents.FileTree(docs))
+}
+
+// Replaces the secrets found in the documents with placeholders, using the
+// detectors selected in the config. Returns the redacted documents along with
+// each redaction.
+func redactDocuments(docs []documents.Document, conf *config.Config) ([]documents.Document, []documents.Redaction, error) {
+	detectors, err := documents.Detectors(conf.Redact.Disable, conf.Redact.Patterns)
+	if err != nil {
+		return nil, nil, errors.Join(fmt.Errorf("The config is invalid."), err)
+	}
+
+	redacted, redactions := documents.Redact(docs, detectors)
+
+	return redacted, redactions, nil
+}
 
-	configFile, err := os.Open(path)
+// Loads and parses a configuration file in TOML format (such as the project’s
+// `pal.toml`). Returns the parsed Config and any errors encountered while
+// reading or parsing the file.
+func fetchConfigFile(filePath string) (config.Config, error) {
+	configFile, err := os.Open(filePath)
 
 	if err != nil {
 		return config.Config{}, fmt.Errorf("Failed to open config file.")
 	}
 
-	configToml, err := os.ReadFile(configFile.Name())
+	defer configFile.Close()
+
+	configToml, err := io.ReadAll(configFile)
 
 	if err != nil {
 		return config.Config{}, fmt.Errorf("Failed to read config file.")
@@ -102,12 +257,128 @@ func fetchUserConfig(projectPath string) (config.Config, error) {
 	parsedConfig, err := config.ConfigFromToml(string(configToml))
 
 	if err != nil {
-		return config.Config{}, fmt.Errorf("Invalid config file.")
+		return config.Config{}, errors.Join(fmt.Errorf("Invalid config file: %s.", filePath), err)
 	}
 
 	return parsedConfig, nil
 }
 
+// Returns the path of the user’s global config file, which applies to all
+// projects: `$XDG_CONFIG_HOME/pal/config.toml`, or `~/.config/pal/config.toml`
+// if the variable isn’t set.
+func globalConfigPath() (string, error) {
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+
+	if configHome == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+
+		configHome = path.Join(homeDir, ".config")
+	}
+
+	return path.Join(configHome, constants.AppName, "config.toml"), nil
+}
+
+// Collects the configuration layers, in order of precedence: the global config
+// file, the project’s `pal.toml`, the selected profile, `PAL_*` environment
+// variables and the command-line flags. Both config files are optional. The
+// built-in defaults aren’t included, because they are the base of every
+// resolved config.
+func configLayers(c *cli.Context) ([]config.Layer, error) {
+	var layers []config.Layer
+
+	globalPath, err := globalConfigPath()
+	if err != nil {
+		return nil, err
+	}
+
+	projectPath := path.Join(c.Path("project-path"), "pal.toml")
+
+	for _, filePath := range []string{globalPath, projectPath} {
+		if !util.FileExists(filePath) {
+			continue
+		}
+
+		fileConfig, err := fetchConfigFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+
+		layers = append(layers, config.Layer{Name: filePath, Config: fileConfig})
+	}
+
+	envConfig, err := config.ConfigFromEnv(os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	envLayer := config.Layer{Name: "environment", Config: envConfig}
+
+	// The profile is applied between the config files and the environment. It is
+	// selected using the `--profile` flag or the `default-profile` value, which
+	// may also be set in the environment.
+	resolved, _, _ := config.ResolveLayers(append(slices.Clone(layers), envLayer))
+
+	profileName, profile, err := resolved.SelectProfile(c.String("profile"))
+	if err != nil {
+		return nil, err
+	}
+
+	if profileName != "" {
+		layers = append(layers, config.Layer{Name: fmt.Sprintf("profile %s", profileName), Config: profile})
+	}
+
+	layers = append(layers, envLayer)
+
+	// The flags depend on the provider selected by the other layers. Any errors
+	// in the layers are reported once all of them are resolved.
+	resolved, _, _ = config.ResolveLayers(layers)
+	layers = append(layers, config.Layer{Name: "flags", Config: flagsConfig(c, resolved.Provider)})
+
+	return layers, nil
+}
+
+// Returns the generation parameters set using command-line flags, which take
+// precedence over the config for a single run. Since the parameters in the
+// section of the selected provider override the shared ones, the flags are set
+// in the former.
+func flagsConfig(c *cli.Context, provider string) config.Config {
+	var flags config.GenerationConfig
+
+	if c.IsSet("max-tokens") {
+		flags.MaxTokens = c.Int("max-tokens")
+	}
+
+	if c.IsSet("temperature") {
+		temperature := c.Float64("temperature")
+		flags.Temperature = &temperature
+	}
+
+	if c.IsSet("top-p") {
+		topP := c.Float64("top-p")
+		flags.TopP = &topP
+	}
+
+	if c.IsSet("stop") {
+		flags.Stop = c.StringSlice("stop")
+	}
+
+	conf := config.Config{Provider: provider}
+
+	if providerGeneration := conf.ProviderGeneration(); providerGeneration != nil {
+		*providerGeneration = flags
+	} else {
+		conf.Generation = flags
+	}
+
+	// The provider was only needed to select the section.
+	conf.Provider = ""
+
+	return conf
+}
+
 // Initializes a config file with a minimal set of options.
 func initConfigFile(projectPath string, requestedProvider string) (*os.File, error) {
 	filePath := path.Join(projectPath, "pal.toml")
@@ -130,10 +401,13 @@ func initConfigFile(projectPath string, requestedProvider string) (*os.File, err
 		MaxContextLength: defaultConfig.MaxContextLength,
 		Openai:           defaultConfig.Openai,
 		Anthropic:        defaultConfig.Anthropic,
+		Ollama:           defaultConfig.Ollama,
 	}
 
 	if requestedProvider == "anthropic" {
 		conf.Provider = "anthropic"
+	} else if requestedProvider == "ollama" {
+		conf.Provider = "ollama"
 	} else if requestedProvider == "openai" {
 		conf.Provider = "openai"
 	} else {
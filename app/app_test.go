@@ -4,6 +4,7 @@ import (
 	"os"
 	"github.com/malinowskip/pal/config"
 	"github.com/malinowskip/pal/documents"
+	"github.com/malinowskip/pal/testutil"
 	"path"
 	"testing"
 )
@@ -24,14 +25,14 @@ func TestAssembleContextString(t *testing.T) {
 	}
 }
 
-func TestFetchUserConfig(t *testing.T) {
+func TestFetchConfigFile(t *testing.T) {
 	projectPath := t.TempDir()
 	configFile, _ := os.Create(path.Join(projectPath, "pal.toml"))
 	conf := config.DefaultConfig()
 	conf.SystemMessage = "yes"
 	toml, _ := conf.ToToml()
 	configFile.WriteString(toml)
-	resolvedConf, _ := fetchUserConfig(projectPath)
+	resolvedConf, _ := fetchConfigFile(path.Join(projectPath, "pal.toml"))
 
 	if resolvedConf.SystemMessage != "yes" {
 		t.Error("Failed to fetch config from the filesystem.")
@@ -58,6 +59,10 @@ func TestInitConfigFile(t *testing.T) {
 				ApiKeyEnv: "ANTHROPIC_API_KEY",
 				Model:     "claude-3-5-haiku-latest",
 			},
+			Ollama: config.OllamaConfig{
+				Host:  "http://localhost:11434",
+				Model: "llama3.2",
+			},
 		}
 
 		expected, _ := expectedConfig.ToToml()
@@ -67,6 +72,18 @@ func TestInitConfigFile(t *testing.T) {
 		}
 	})
 
+	t.Run("Selects the requested provider.", func(t *testing.T) {
+		projectPath := t.TempDir()
+		initConfigFile(projectPath, "ollama")
+
+		conf, err := fetchConfigFile(path.Join(projectPath, "pal.toml"))
+		if err != nil {
+			t.Error(err)
+		}
+
+		testutil.AssertDeepEquals(t, conf.Provider, "ollama")
+	})
+
 	t.Run("Fails if config file already exists.", func(t *testing.T) {
 		projectPath := t.TempDir()
 		initConfigFile(projectPath, "")
@@ -0,0 +1,144 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Delimiter of multi-line blocks in the interactive chat. Everything between
+// two lines consisting of the delimiter is sent as a single message.
+const multiLineDelimiter = `"""`
+
+// This command opens an interactive session, in which the user can have a
+// conversation with the LLM without re-running the app for each message. The
+// context is loaded only once, at the start of the session, and every
+// exchange is recorded in the same conversation in the database.
+func Chat(c *cli.Context) error {
+	if c.Bool("dry-run") {
+		return fmt.Errorf("The --dry-run flag cannot be used in an interactive chat.")
+	}
+
+	// Load the config, the context and the LLM provider, and connect to the
+	// database. The session may continue an existing conversation if the
+	// --continue or --conversation flag is set.
+	session, conversation, err := startSession(c)
+	if err != nil {
+		return err
+	}
+
+	output := c.App.Writer
+	input := bufio.NewReader(c.App.Reader)
+
+	fmt.Fprintf(
+		output,
+		"Press Enter to send a message. End a line with \\ or wrap lines in %s to write multiple lines.\nType /exit or press Ctrl-D to quit.\n",
+		multiLineDelimiter,
+	)
+
+	if conversation.Id != 0 {
+		fmt.Fprintf(output, "Continuing conversation %d.\n", conversation.Id)
+	}
+
+	for {
+		fmt.Fprint(output, "\n> ")
+
+		userMessage, err := readChatMessage(input, output)
+		if err == io.EOF {
+			fmt.Fprintln(output)
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if userMessage == "" {
+			continue
+		}
+
+		if userMessage == "/exit" || userMessage == "/quit" {
+			break
+		}
+
+		fmt.Fprintln(output)
+
+		// While the reply is being streamed, pressing Ctrl-C interrupts the reply,
+		// but not the session. Terminating the process ends the session, but only
+		// after the reply is recorded as interrupted.
+		terminateCtx, stopTerminate := signal.NotifyContext(c.Context, syscall.SIGTERM)
+		ctx, stopInterrupt := signal.NotifyContext(terminateCtx, os.Interrupt)
+		err = session.sendMessage(ctx, &conversation, userMessage)
+		terminated := terminateCtx.Err() != nil
+		stopInterrupt()
+		stopTerminate()
+
+		if terminated {
+			return err
+		}
+
+		// A failed request shouldn’t end the session; the user may simply try
+		// again.
+		if err != nil {
+			fmt.Fprintf(c.App.ErrWriter, "\nError: %v\n", err)
+			continue
+		}
+
+		fmt.Fprintln(output)
+	}
+
+	return session.pruneHistory()
+}
+
+// Reads a single message from the input. Usually, a message is a single line,
+// but the user may continue a message on the next line by ending the current
+// line with a backslash, or send multiple lines at once by placing them between
+// two lines consisting of `"""`.
+//
+// Returns io.EOF if the input has been exhausted before any text was read.
+func readChatMessage(input *bufio.Reader, output io.Writer) (string, error) {
+	var lines []string
+	insideBlock := false
+
+	for {
+		line, err := input.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+
+		atEOF := err == io.EOF
+		if atEOF && line == "" && len(lines) == 0 && !insideBlock {
+			return "", io.EOF
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case strings.TrimSpace(line) == multiLineDelimiter:
+			if insideBlock {
+				return strings.TrimSpace(strings.Join(lines, "\n")), nil
+			}
+			insideBlock = true
+		case insideBlock:
+			lines = append(lines, line)
+		case strings.HasSuffix(line, `\`):
+			lines = append(lines, strings.TrimSuffix(line, `\`))
+		default:
+			lines = append(lines, line)
+			return strings.TrimSpace(strings.Join(lines, "\n")), nil
+		}
+
+		// Send whatever has been collected if the input ends in the middle of a
+		// multi-line message.
+		if atEOF {
+			return strings.TrimSpace(strings.Join(lines, "\n")), nil
+		}
+
+		fmt.Fprint(output, "… ")
+	}
+}
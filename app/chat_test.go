@@ -0,0 +1,96 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"github.com/malinowskip/pal/llm_provider"
+	"github.com/malinowskip/pal/persistence"
+	"github.com/malinowskip/pal/testutil"
+	"strings"
+	"testing"
+)
+
+func TestChat(t *testing.T) {
+	projectPath, db := instantiateEnvironment(t)
+
+	input := strings.Join([]string{
+		"Hello",
+		"",
+		`Multiple \`,
+		"lines",
+		`"""`,
+		"A block",
+		"",
+		"of text",
+		`"""`,
+		"/exit",
+		"This should not be sent",
+	}, "\n")
+
+	var output bytes.Buffer
+	redirectAppIO(t, strings.NewReader(input), &output)
+
+	if err := Run([]string{"pal", "--path", projectPath, "chat"}); err != nil {
+		t.Error(err)
+	}
+
+	convo, err := db.FetchRecentConversation()
+	if err != nil {
+		t.Error(err)
+	}
+
+	expectedConvo := persistence.Conversation{Id: 1, Messages: []persistence.Message{
+		{Id: 1, Role: "user", Content: "Hello"},
+		testProviderReply(2),
+		{Id: 3, Role: "user", Content: "Multiple \nlines"},
+		testProviderReply(4),
+		{Id: 5, Role: "user", Content: "A block\n\nof text"},
+		testProviderReply(6),
+	}}
+
+	testutil.AssertDeepEquals(t, convo, expectedConvo)
+
+	if strings.Count(output.String(), llm_provider.TestProviderExpectedMessage) != 3 {
+		t.Errorf("Each reply should be written to the output. Output:\n%s", output.String())
+	}
+}
+
+func TestChatContinuesConversation(t *testing.T) {
+	projectPath, db := instantiateEnvironment(t)
+
+	if err := Run([]string{"pal", "--path", projectPath, "Hello"}); err != nil {
+		t.Error(err)
+	}
+
+	redirectAppIO(t, strings.NewReader("Hello again"), io.Discard)
+
+	if err := Run([]string{"pal", "--path", projectPath, "--continue", "chat"}); err != nil {
+		t.Error(err)
+	}
+
+	convo, err := db.FetchRecentConversation()
+	if err != nil {
+		t.Error(err)
+	}
+
+	testutil.AssertDeepEquals(t, convo.Id, int64(1))
+	testutil.AssertLength(t, convo.Messages, 4)
+	testutil.AssertDeepEquals(t, convo.Messages[2].Content, "Hello again")
+}
+
+func TestReadChatMessage(t *testing.T) {
+	input := bufio.NewReader(strings.NewReader("first\nsecond \\\nline\n\"\"\"\nunterminated"))
+
+	for _, expected := range []string{"first", "second \nline", "unterminated"} {
+		message, err := readChatMessage(input, io.Discard)
+		if err != nil {
+			t.Error(err)
+		}
+		testutil.AssertDeepEquals(t, message, expected)
+	}
+
+	if _, err := readChatMessage(input, io.Discard); err != io.EOF {
+		t.Error("An exhausted input should result in io.EOF.")
+	}
+}
@@ -0,0 +1,177 @@
+package app
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/malinowskip/pal/persistence"
+
+	"github.com/urfave/cli/v2"
+)
+
+// This command lists stored conversations, most recent first, one page at a
+// time.
+func ListHistory(c *cli.Context) error {
+	projectPath := c.Path("project-path")
+	if projectPath == "" {
+		return fmt.Errorf("The project path may not be empty.")
+	}
+
+	limit := c.Int("limit")
+	if limit < 1 {
+		return fmt.Errorf("The limit must be a positive number.")
+	}
+
+	page := c.Int("page")
+	if page < 1 {
+		return fmt.Errorf("The page must be a positive number.")
+	}
+
+	db, err := persistence.StartClient(projectPath)
+	if err != nil {
+		return err
+	}
+
+	summaries, err := db.ListConversations(limit, (page-1)*limit)
+	if err != nil {
+		return err
+	}
+
+	if len(summaries) == 0 {
+		fmt.Fprintln(c.App.Writer, "No conversations found.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(c.App.Writer, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "ID\tCREATED\tMESSAGES\tFIRST MESSAGE")
+
+	for _, s := range summaries {
+		fmt.Fprintf(
+			w,
+			"%d\t%s\t%d\t%s\n",
+			s.Id,
+			s.CreatedAt.Local().Format("2006-01-02 15:04"),
+			s.MessageCount,
+			truncateForDisplay(s.FirstUserMessage, 60),
+		)
+	}
+
+	return w.Flush()
+}
+
+// This command prints the full transcript of a single stored conversation.
+func ShowHistory(c *cli.Context) error {
+	projectPath := c.Path("project-path")
+	if projectPath == "" {
+		return fmt.Errorf("The project path may not be empty.")
+	}
+
+	conversationId, err := parseConversationId(c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	db, err := persistence.StartClient(projectPath)
+	if err != nil {
+		return err
+	}
+
+	convo, err := db.FetchConversation(conversationId)
+	if err != nil {
+		return err
+	}
+
+	for i, m := range convo.Messages {
+		if i > 0 {
+			fmt.Fprintln(c.App.Writer)
+		}
+		if m.Interrupted {
+			fmt.Fprintf(c.App.Writer, "[%s (interrupted)]\n", m.Role)
+		} else if m.StopReason == "max_tokens" {
+			fmt.Fprintf(c.App.Writer, "[%s (truncated)]\n", m.Role)
+		} else {
+			fmt.Fprintf(c.App.Writer, "[%s]\n", m.Role)
+		}
+
+		if content := strings.TrimRight(m.Content, "\n"); content != "" || len(m.ToolCalls) == 0 {
+			fmt.Fprintln(c.App.Writer, content)
+		}
+
+		// Tool calls made by the LLM, e.g. `→ read_file {"path":"main.go"}`.
+		for _, call := range m.ToolCalls {
+			fmt.Fprintf(c.App.Writer, "→ %s %s\n", call.Name, call.Arguments)
+		}
+	}
+
+	return nil
+}
+
+// This command deletes one or more stored conversations.
+func RemoveHistory(c *cli.Context) error {
+	projectPath := c.Path("project-path")
+	if projectPath == "" {
+		return fmt.Errorf("The project path may not be empty.")
+	}
+
+	if c.NArg() == 0 {
+		return fmt.Errorf("Please provide the id of at least one conversation.")
+	}
+
+	var conversationIds []int64
+
+	for _, arg := range c.Args().Slice() {
+		id, err := parseConversationId(arg)
+		if err != nil {
+			return err
+		}
+		conversationIds = append(conversationIds, id)
+	}
+
+	db, err := persistence.StartClient(projectPath)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := db.DeleteConversations(conversationIds)
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return fmt.Errorf("No conversations matched the provided ids.")
+	}
+
+	fmt.Fprintf(c.App.Writer, "Deleted %d conversation(s).\n", deleted)
+
+	return nil
+}
+
+// Parses a conversation id provided by the user as a command-line argument.
+func parseConversationId(input string) (int64, error) {
+	if input == "" {
+		return 0, fmt.Errorf("Missing conversation id.")
+	}
+
+	id, err := strconv.ParseInt(input, 10, 64)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("Invalid conversation id: %q.", input)
+	}
+
+	return id, nil
+}
+
+// Collapses the input into a single line and shortens it to at most maxLength
+// characters, so that it fits in a table cell.
+func truncateForDisplay(input string, maxLength int) string {
+	singleLine := strings.Join(strings.Fields(input), " ")
+	runes := []rune(singleLine)
+
+	if len(runes) <= maxLength {
+		return singleLine
+	}
+
+	return string(runes[:maxLength-1]) + "…"
+}
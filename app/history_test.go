@@ -0,0 +1,130 @@
+package app
+
+import (
+	"bytes"
+	"github.com/malinowskip/pal/llm_provider"
+	"github.com/malinowskip/pal/testutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Runs the CLI app with the given arguments and returns everything written to
+// the app’s output.
+func runAndCaptureOutput(t *testing.T, args []string) (string, error) {
+	t.Helper()
+
+	var output bytes.Buffer
+	redirectAppIO(t, os.Stdin, &output)
+
+	err := Run(args)
+
+	return output.String(), err
+}
+
+func TestHistory(t *testing.T) {
+	projectPath, db := instantiateEnvironment(t)
+
+	if err := Run([]string{"pal", "--path", projectPath, "First question"}); err != nil {
+		t.Error(err)
+	}
+
+	if err := Run([]string{"pal", "--path", projectPath, "Second\nquestion"}); err != nil {
+		t.Error(err)
+	}
+
+	t.Run("Lists conversations", func(t *testing.T) {
+		output, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "history", "list"})
+		if err != nil {
+			t.Error(err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(output), "\n")
+		testutil.AssertLength(t, lines, 3)
+
+		// Columns: id, creation date, creation time, message count and the first
+		// message (with line breaks collapsed).
+		fields := strings.Fields(lines[1])
+		testutil.AssertDeepEquals(t, fields[0], "2")
+		testutil.AssertDeepEquals(t, fields[3], "2")
+		testutil.AssertDeepEquals(t, strings.Join(fields[4:], " "), "Second question")
+
+		if !strings.HasPrefix(lines[2], "1 ") || !strings.HasSuffix(lines[2], "First question") {
+			t.Errorf("Unexpected listing of the first conversation: %q", lines[2])
+		}
+	})
+
+	t.Run("Pages through conversations", func(t *testing.T) {
+		output, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "history", "list", "--limit", "1", "--page", "2"})
+		if err != nil {
+			t.Error(err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(output), "\n")
+		testutil.AssertLength(t, lines, 2)
+
+		if !strings.HasPrefix(lines[1], "1 ") {
+			t.Errorf("The second page should contain the first conversation: %q", lines[1])
+		}
+	})
+
+	t.Run("Shows a conversation", func(t *testing.T) {
+		output, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "history", "show", "1"})
+		if err != nil {
+			t.Error(err)
+		}
+
+		expected := "[user]\nFirst question\n\n[assistant]\n" + llm_provider.TestProviderExpectedMessage + "\n"
+		testutil.AssertDeepEquals(t, output, expected)
+	})
+
+	t.Run("Fails to show a non-existent conversation", func(t *testing.T) {
+		_, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "history", "show", "42"})
+		if err == nil {
+			t.Error("Showing a non-existent conversation should fail.")
+		}
+	})
+
+	t.Run("Removes a conversation", func(t *testing.T) {
+		output, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "history", "rm", "1"})
+		if err != nil {
+			t.Error(err)
+		}
+
+		testutil.AssertDeepEquals(t, output, "Deleted 1 conversation(s).\n")
+
+		if _, err = db.FetchConversation(1); err == nil {
+			t.Error("The conversation should have been deleted.")
+		}
+
+		if _, err = db.FetchConversation(2); err != nil {
+			t.Error("Other conversations should be left intact.")
+		}
+	})
+
+	t.Run("Fails to remove non-existent conversations", func(t *testing.T) {
+		_, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "history", "rm", "42"})
+		if err == nil {
+			t.Error("Removing a non-existent conversation should fail.")
+		}
+	})
+}
+
+func TestParseConversationId(t *testing.T) {
+	id, err := parseConversationId("12")
+	if err != nil {
+		t.Error(err)
+	}
+	testutil.AssertDeepEquals(t, id, int64(12))
+
+	for _, input := range []string{"", "abc", "0", "-1"} {
+		if _, err := parseConversationId(input); err == nil {
+			t.Errorf("%q should not be accepted as a conversation id.", input)
+		}
+	}
+}
+
+func TestTruncateForDisplay(t *testing.T) {
+	testutil.AssertDeepEquals(t, truncateForDisplay("Hello,\n  world!", 20), "Hello, world!")
+	testutil.AssertDeepEquals(t, truncateForDisplay("Hello, world!", 6), "Hello…")
+}
@@ -3,34 +3,67 @@ package app
 import (
 	"fmt"
 	"github.com/malinowskip/pal/config"
+	"strings"
 
+	toml "github.com/pelletier/go-toml/v2"
 	"github.com/urfave/cli/v2"
 )
 
 // This command resolves the final configuration (defaults + user’s overrides)
-// and prints them to the console.
+// and prints them to the console. With `--sources`, each value is printed on
+// its own line, followed by the layer that it came from.
+//
+// If the resolved configuration is invalid, it is printed anyway, followed by
+// the validation errors, so that the user can see where the invalid values
+// came from.
 func PrintConfig(c *cli.Context) error {
-	projectPath := c.Path("project-path")
+	finalConfig, sources, validationErr := resolveConfigSources(c)
 
-	userConfig, err := fetchUserConfig(projectPath)
+	// Without sources, the config couldn’t be resolved at all (e.g. a config file
+	// couldn’t be parsed).
+	if sources == nil {
+		return validationErr
+	}
 
-	if err != nil {
-		return fmt.Errorf("Failed to read the contents of the config file.")
+	if c.Bool("sources") {
+		if err := printConfigSources(c, finalConfig, sources); err != nil {
+			return err
+		}
+
+		return validationErr
 	}
 
-	finalConfig, err := config.ResolveConfig(&userConfig)
+	tomlString, err := finalConfig.ToToml()
 
 	if err != nil {
-		return err
+		return fmt.Errorf("Failed to encode config as TOML.")
 	}
 
-	tomlString, err := finalConfig.ToToml()
+	fmt.Fprintln(c.App.Writer, tomlString)
+
+	return validationErr
+}
+
+// Prints the values keyed by their full TOML keys, in alphabetical order, with
+// the source of each value in a comment.
+func printConfigSources(c *cli.Context, finalConfig config.Config, sources config.Sources) error {
+	values, err := config.Flatten(finalConfig)
 
 	if err != nil {
 		return fmt.Errorf("Failed to encode config as TOML.")
 	}
 
-	fmt.Println(tomlString)
+	for _, key := range config.SortedKeys(values) {
+		encoded, err := toml.Marshal(map[string]any{"value": values[key]})
+
+		if err != nil {
+			return fmt.Errorf("Failed to encode config as TOML.")
+		}
+
+		value := strings.TrimSpace(strings.TrimPrefix(string(encoded), "value = "))
+
+		fmt.Fprintf(c.App.Writer, "%s = %s  # %s\n", key, value, sources[key])
+	}
 
 	return nil
 }
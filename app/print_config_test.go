@@ -0,0 +1,155 @@
+package app
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestPrintConfigSources(t *testing.T) {
+	projectPath, _ := instantiateEnvironment(t)
+
+	globalConfigPath := path.Join(os.Getenv("XDG_CONFIG_HOME"), "pal", "config.toml")
+	if err := os.MkdirAll(path.Dir(globalConfigPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	configFiles := map[string]string{
+		globalConfigPath:                   "provider = \"ollama\"\nmax-file-size = \"50KB\"\n",
+		path.Join(projectPath, "pal.toml"): "provider = \"testing\"\n",
+	}
+
+	for filePath, contents := range configFiles {
+		if err := os.WriteFile(filePath, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Setenv("PAL_OLLAMA_MODEL", "qwen2.5-coder")
+
+	output, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "--max-tokens", "500", "config", "--sources"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedLines := []string{
+		`provider = 'testing'  # ` + path.Join(projectPath, "pal.toml"),
+		`max-file-size = '50KB'  # ` + globalConfigPath,
+		`ollama.model = 'qwen2.5-coder'  # environment`,
+		`generation.max-tokens = 500  # flags`,
+		`ollama.host = 'http://localhost:11434'  # default`,
+	}
+
+	lines := strings.Split(output, "\n")
+
+	for _, expected := range expectedLines {
+		found := false
+		for _, line := range lines {
+			if strings.Join(strings.Fields(line), " ") == strings.Join(strings.Fields(expected), " ") {
+				found = true
+			}
+		}
+
+		if !found {
+			t.Errorf("The output should include %q: %s", expected, output)
+		}
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	projectPath, _ := instantiateEnvironment(t)
+
+	configToml := `
+provider = "testing"
+default-profile = "quick"
+
+[profiles.quick]
+max-file-size = "10KB"
+
+[profiles.deep]
+max-file-size = "100KB"
+system-message = "Review the code."
+`
+
+	if err := os.WriteFile(path.Join(projectPath, "pal.toml"), []byte(configToml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Default profile", func(t *testing.T) {
+		output, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "config", "--sources"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(output, "max-file-size = '10KB'  # profile quick\n") {
+			t.Errorf("The default profile should be applied: %s", output)
+		}
+	})
+
+	t.Run("Profile selected using the flag", func(t *testing.T) {
+		output, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "--profile", "deep", "config", "--sources"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(output, "max-file-size = '100KB'  # profile deep\n") ||
+			!strings.Contains(output, "system-message = 'Review the code.'  # profile deep\n") {
+			t.Errorf("The selected profile should be applied: %s", output)
+		}
+	})
+
+	t.Run("The environment overrides the profile", func(t *testing.T) {
+		t.Setenv("PAL_MAX_FILE_SIZE", "1MB")
+
+		output, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "config", "--sources"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(output, "max-file-size = '1MB'  # environment\n") {
+			t.Errorf("The environment should take precedence over the profile: %s", output)
+		}
+	})
+
+	t.Run("Undefined profile", func(t *testing.T) {
+		err := Run([]string{"pal", "--path", projectPath, "--profile", "missing", "config"})
+
+		if err == nil || !strings.Contains(err.Error(), "missing is not a profile") {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+}
+
+func TestInvalidConfig(t *testing.T) {
+	projectPath, _ := instantiateEnvironment(t)
+	configPath := path.Join(projectPath, "pal.toml")
+
+	t.Run("Unknown keys", func(t *testing.T) {
+		if err := os.WriteFile(configPath, []byte("provider = \"testing\"\nmax-file-sise = \"10KB\"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		err := Run([]string{"pal", "--path", projectPath, "Hello"})
+
+		if err == nil || !strings.Contains(err.Error(), configPath) || !strings.Contains(err.Error(), `Unknown key "max-file-sise" at line 2, column 1.`) {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Invalid values are printed along with the errors", func(t *testing.T) {
+		if err := os.WriteFile(configPath, []byte("provider = \"anthropic\"\nmax-file-size = \"lots\"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		output, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "config", "--sources"})
+
+		if err == nil || !strings.Contains(err.Error(), `"max-file-size"`) {
+			t.Errorf("Unexpected error: %v", err)
+		}
+
+		if !strings.Contains(output, "provider = 'anthropic'  # "+configPath) {
+			t.Errorf("The config should be printed: %s", output)
+		}
+	})
+}
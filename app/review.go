@@ -0,0 +1,46 @@
+package app
+
+import (
+	"fmt"
+	"github.com/malinowskip/pal/util"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Message sent to the LLM by the review command, followed by any additional
+// instructions provided through stdin.
+const reviewPrompt = "Review the changes in the diff. Point out bugs, risky or unclear changes and missing tests, referring to the affected files and lines. Be concise and skip changes that look fine."
+
+// This command asks the LLM to review the changes tracked by git: the
+// uncommitted changes or, if a base revision is given, the changes made since
+// HEAD diverged from it. Otherwise, it works like StartOrContinueConversation,
+// so the review can be continued with `pal -c`.
+func Review(c *cli.Context) error {
+	if c.NArg() > 1 {
+		return fmt.Errorf("The review command accepts at most one argument: the base revision.")
+	}
+
+	// The diff is added to the context as if the flags were set by the user.
+	if err := c.Set("diff", "true"); err != nil {
+		return err
+	}
+
+	if base := c.Args().First(); base != "" {
+		if err := c.Set("diff-base", base); err != nil {
+			return err
+		}
+	}
+
+	userMessage := reviewPrompt
+
+	stdinText, err := util.ReadStdin()
+	if err != nil {
+		return err
+	}
+
+	if stdinText != "" {
+		userMessage += "\n\n" + stdinText
+	}
+
+	return converse(c, userMessage)
+}
@@ -0,0 +1,115 @@
+package app
+
+import (
+	"encoding/json"
+	"github.com/malinowskip/pal/llm_provider"
+	"github.com/malinowskip/pal/testutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// Initializes a git repository in the project directory with the files
+// committed.
+func initRepository(t *testing.T, projectPath string, files map[string]string) {
+	t.Helper()
+
+	repo, err := git.PlainInit(projectPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.WriteTestFiles(t, projectPath, files)
+	for name := range files {
+		if _, err := worktree.Add(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = worktree.Commit("Initial commit", &git.CommitOptions{
+		Author: &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReview(t *testing.T) {
+	projectPath, _ := instantiateEnvironment(t)
+
+	initRepository(t, projectPath, map[string]string{
+		"a.go": "package a\n",
+		"b.go": "package b\n",
+	})
+
+	// Prints the system message and the messages of the request.
+	dryRun := func(args ...string) (string, []llm_provider.Message) {
+		output, err := runAndCaptureOutput(t, append([]string{"pal", "--path", projectPath, "--dry-run"}, args...))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var request struct {
+			System   string                 `json:"system"`
+			Messages []llm_provider.Message `json:"messages"`
+		}
+		if err = json.Unmarshal([]byte(output), &request); err != nil {
+			t.Fatal(err)
+		}
+
+		return request.System, request.Messages
+	}
+
+	t.Run("Fails without changes", func(t *testing.T) {
+		_, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "--dry-run", "review"})
+		testutil.AssertDeepEquals(t, err.Error(), "There are no changes to add to the context.")
+	})
+
+	if err := os.WriteFile(path.Join(projectPath, "a.go"), []byte("package a\n\nfunc A() {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Reviews uncommitted changes", func(t *testing.T) {
+		system, messages := dryRun("review")
+
+		if !strings.Contains(system, "<diff>\ndiff --git a/a.go b/a.go\n") || !strings.Contains(system, "+func A() {}\n") {
+			t.Errorf("The system message should include the diff: %q", system)
+		}
+
+		// The whole project is included as well.
+		if !strings.Contains(system, "package b") {
+			t.Errorf("The system message should include the project: %q", system)
+		}
+
+		testutil.AssertDeepEquals(t, messages, []llm_provider.Message{{Role: "user", Content: reviewPrompt}})
+	})
+
+	t.Run("Includes only the changed files", func(t *testing.T) {
+		system, _ := dryRun("--diff", "--touched-only", "What changed?")
+
+		if !strings.Contains(system, "<source>a.go</source>") || strings.Contains(system, "<source>b.go</source>") {
+			t.Errorf("Only the changed file should be included: %q", system)
+		}
+	})
+
+	t.Run("Fails for an invalid base revision", func(t *testing.T) {
+		_, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "--dry-run", "review", "no-such-branch"})
+		testutil.AssertDeepEquals(t, err.Error(), "no-such-branch is not a valid git revision.")
+	})
+
+	t.Run("Requires --diff for --touched-only", func(t *testing.T) {
+		if _, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "--touched-only", "Hello"}); err == nil {
+			t.Error("Expected an error.")
+		}
+	})
+}
@@ -0,0 +1,713 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/malinowskip/pal/config"
+	"github.com/malinowskip/pal/documents"
+	"github.com/malinowskip/pal/llm_provider"
+	"github.com/malinowskip/pal/persistence"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/urfave/cli/v2"
+)
+
+// Sent to the LLM, following its partial reply, when a reply that was cut off
+// is continued.
+const continuationPrompt = "Your reply was cut off. Continue exactly where you left off, without repeating anything."
+
+// A session bundles everything that is needed to talk to the LLM about a
+// project: the resolved configuration, the LLM provider, the project’s
+// documents, the system message (which includes the context) and the database
+// client used for recording conversations. The documents are loaded once, when
+// the session is started, so the session can be reused for multiple
+// exchanges.
+type session struct {
+	config   config.Config
+	provider llm_provider.LLMProvider
+	// Name of the model, recorded along with the usage of each reply.
+	model string
+	db    persistence.DatabaseClient
+	// All documents loaded from the project.
+	documents []documents.Document
+	// System message followed by the context string. With the `relevant` context
+	// strategy, it remains empty until the first message is sent, because the
+	// documents are selected based on that message.
+	fullSystemMessage string
+	// Paths of the documents selected with the `relevant` context strategy.
+	selectedPaths []string
+	// Patterns given by the --include, --only and --exclude flags, or recorded
+	// in the continued conversation.
+	selection documents.Selection
+	// Whether the selection was given by flags and should be recorded in the
+	// conversation.
+	recordSelection bool
+	// Changes tracked by git in the unified diff format, included in the context
+	// after the documents. Empty unless requested by the user.
+	diff string
+	// Tools available to the LLM with the `tools` context strategy.
+	tools []llm_provider.Tool
+	// Tokens streamed by the LLM are written here.
+	output io.Writer
+	// Warnings are written here.
+	errOutput io.Writer
+}
+
+// Resolves the configuration, connects to the database, selects the
+// conversation to be continued (see selectConversation) and loads the
+// project’s documents.
+func startSession(c *cli.Context) (*session, persistence.Conversation, error) {
+	var conversation persistence.Conversation
+
+	// Root path of the project. If not set by the user, it will be set to the
+	// current directory, i.e. ".".
+	projectPath := c.Path("project-path")
+	if projectPath == "" {
+		return nil, conversation, fmt.Errorf("The project path may not be empty.")
+	}
+
+	// Default config values overridden by any values defined by the user in
+	// the config files, environment variables or flags.
+	finalConfig, err := resolveFinalConfig(c)
+	if err != nil {
+		return nil, conversation, err
+	}
+
+	// After initialization, the LLM provider should be ready to generate
+	// completions. However, the initialization itself doesn’t send any external
+	// requests yet, so potential errors might be returned later on, when we
+	// request a chat completion (e.g. if the user provides an invalid API key).
+	provider, err := llm_provider.ResolveFromConfig(&finalConfig)
+	if err != nil {
+		return nil, conversation, err
+	}
+
+	// A dry run only prints the request, so it doesn’t need an API key.
+	if !c.Bool("dry-run") {
+		if err = llm_provider.CheckApiKey(&finalConfig); err != nil {
+			return nil, conversation, err
+		}
+	}
+
+	// Maximum size of documents to be included in the context. In the config, this
+	// value is specified using SI notation, e.g. “10K”, so it needs to be
+	// converted to bytes.
+	maxFileSize, err := humanize.ParseBigBytes(finalConfig.MaxFileSize)
+	if err != nil {
+		return nil, conversation, errors.Join(fmt.Errorf("The config is invalid."), err)
+	}
+
+	// Initialize database connection for saving and retrieving conversations from
+	// the local database. A dry run doesn’t record anything, so it only needs the
+	// database to read the conversation being continued.
+	var db persistence.DatabaseClient
+	if !c.Bool("dry-run") || c.Bool("continue") || c.IsSet("conversation") {
+		db, err = persistence.StartClient(projectPath)
+		if err != nil {
+			return nil, conversation, err
+		}
+	}
+
+	s := &session{
+		config:    finalConfig,
+		provider:  provider,
+		model:     llm_provider.ResolveModel(&finalConfig),
+		db:        db,
+		output:    c.App.Writer,
+		errOutput: c.App.ErrWriter,
+	}
+
+	// If the --continue or --conversation flag is set, the session continues an
+	// existing conversation. It’s selected before the documents are loaded,
+	// because it determines which documents are loaded.
+	conversation, err = s.selectConversation(c)
+	if err != nil {
+		return nil, conversation, err
+	}
+
+	// The --include, --only and --exclude flags adjust the documents loaded for
+	// the conversation. Without them, a continued conversation uses the same
+	// flags as before.
+	if hasSelectionFlags(c) {
+		s.selection = selectionFromFlags(c)
+		s.recordSelection = true
+	} else if conversation.DocumentSelection != nil {
+		s.selection = documents.Selection(*conversation.DocumentSelection)
+	}
+
+	// Load all project documents that will be included in the context. Files
+	// that didn’t change since the previous run are looked up in the cache.
+	cache := documents.OpenCache(projectPath)
+
+	loadedDocuments, _, err := documents.LoadDocuments(
+		projectPath,
+		documents.Source(finalConfig.Source),
+		finalConfig.Include,
+		finalConfig.Exclude,
+		s.selection,
+		maxFileSize.Int64(),
+		cache,
+	)
+	if err != nil {
+		return nil, conversation, err
+	}
+
+	// The cache only speeds up loading, so failing to save it isn’t an error.
+	_ = cache.Save()
+
+	// With the --diff or --diff-base flag (or in a review), the changes tracked
+	// by git are added to the context, optionally along with the changed files
+	// only.
+	if c.Bool("diff") || c.IsSet("diff-base") {
+		if loadedDocuments, err = s.loadDiff(projectPath, c.String("diff-base"), c.Bool("touched-only"), loadedDocuments); err != nil {
+			return nil, conversation, err
+		}
+	} else if c.Bool("touched-only") {
+		return nil, conversation, fmt.Errorf("The --touched-only flag can only be used along with --diff or --diff-base.")
+	}
+
+	// Go files matching the `outline` patterns are reduced to their outlines.
+	outlined := documents.Outline(loadedDocuments, finalConfig.Outline)
+
+	// Secrets found in the documents are replaced with placeholders, so that
+	// they aren’t sent to the LLM.
+	s.documents, _, err = redactDocuments(outlined, &finalConfig)
+	if err != nil {
+		return nil, conversation, err
+	}
+
+	// With the default strategy, the context consists of all documents, so it can
+	// be prepared (and checked against the limit) right away. With the `tools`
+	// strategy, it consists of the file tree only. Otherwise, it will be prepared
+	// once the user’s message is known.
+	switch finalConfig.ContextStrategy {
+	case "all":
+		err = s.setContext(s.documents)
+	case "tools":
+		if _, ok := provider.(llm_provider.ToolCallingLLMProvider); !ok {
+			return nil, conversation, fmt.Errorf(`The %s provider does not support the "tools" context strategy.`, finalConfig.Provider)
+		}
+		s.tools = projectTools(s.documents)
+		err = s.setSystemMessage(assembleFileTreeString(s.documents))
+	}
+
+	if err != nil {
+		return nil, conversation, err
+	}
+
+	return s, conversation, nil
+}
+
+// Loads the diff of the project (see documents.LoadDiff), redacting any
+// secrets. If touchedOnly is true, only the changed documents are returned;
+// otherwise, the documents are returned as they are.
+func (s *session) loadDiff(projectPath string, base string, touchedOnly bool, docs []documents.Document) ([]documents.Document, error) {
+	diff, err := documents.LoadDiff(projectPath, base)
+	if err != nil {
+		return nil, err
+	}
+
+	if diff.Patch == "" {
+		return nil, fmt.Errorf("There are no changes to add to the context.")
+	}
+
+	redacted, _, err := redactDocuments([]documents.Document{{Path: "diff", Content: diff.Patch}}, &s.config)
+	if err != nil {
+		return nil, err
+	}
+	s.diff = redacted[0].Content
+
+	if !touchedOnly {
+		return docs, nil
+	}
+
+	var touched []documents.Document
+	for _, doc := range docs {
+		if slices.Contains(diff.Paths, doc.Path) {
+			touched = append(touched, doc)
+		}
+	}
+
+	return touched, nil
+}
+
+// Reports whether any of the --include, --only and --exclude flags is set.
+func hasSelectionFlags(c *cli.Context) bool {
+	return c.IsSet("include") || c.IsSet("only") || c.IsSet("exclude")
+}
+
+// Returns the selection of documents given by the --include, --only and
+// --exclude flags.
+func selectionFromFlags(c *cli.Context) documents.Selection {
+	return documents.Selection{
+		Include: c.StringSlice("include"),
+		Only:    c.StringSlice("only"),
+		Exclude: c.StringSlice("exclude"),
+	}
+}
+
+// Prepares the system message, including the given documents as the context.
+// Returns an error if the context is too long.
+func (s *session) setContext(docs []documents.Document) error {
+	// Concatenate all documents into a single string that will be passed to the
+	// LLM at the end of the system message.
+	context, err := assembleContextString(&docs)
+	if err != nil {
+		return err
+	}
+
+	return s.setSystemMessage(context)
+}
+
+// Appends the context to the system message. Returns an error if the context
+// is too long.
+func (s *session) setSystemMessage(context string) error {
+	// The diff, if any, follows the documents.
+	if s.diff != "" {
+		context = fmt.Sprintf("%s\n\n<diff>\n%s</diff>", context, s.diff)
+	}
+
+	// Exit if the context is too long.
+	if err := checkContextLength(context, &s.config); err != nil {
+		return err
+	}
+
+	// System message followed by the context string.
+	s.fullSystemMessage = fmt.Sprintf("%s\n\n%s", s.config.SystemMessage, context)
+
+	return nil
+}
+
+// Selects the documents for the context of the conversation using the
+// `relevant` strategy.
+//
+// If documents have already been selected for the conversation, the same
+// documents are used again. Otherwise, the documents most relevant to the
+// user’s messages in the conversation (including the new message) are
+// selected, until the context limit is reached.
+func (s *session) selectRelevantDocuments(conversation *persistence.Conversation, userMessage string) error {
+	var selected []documents.Document
+
+	if len(conversation.DocumentPaths) > 0 {
+		// Documents that no longer exist (or are now excluded) are skipped.
+		for _, doc := range s.documents {
+			if slices.Contains(conversation.DocumentPaths, doc.Path) {
+				selected = append(selected, doc)
+			}
+		}
+	} else {
+		var query []string
+		for _, m := range conversation.Messages {
+			if m.Role == "user" {
+				query = append(query, m.Content)
+			}
+		}
+		query = append(query, userMessage)
+
+		budget, measure := contextBudget(&s.config)
+
+		// The wrapping tags are always part of the context.
+		emptyContext, _ := assembleContextString(&[]documents.Document{})
+		budget -= measure(emptyContext)
+
+		selected = documents.SelectRelevant(
+			s.documents,
+			strings.Join(query, "\n"),
+			budget,
+			func(doc documents.Document) int {
+				return measure(formatDocument(doc))
+			},
+		)
+	}
+
+	s.selectedPaths = []string{}
+	for _, doc := range selected {
+		s.selectedPaths = append(s.selectedPaths, doc.Path)
+	}
+
+	return s.setContext(selected)
+}
+
+// Selects the conversation to be continued based on the --continue and
+// --conversation flags. An empty conversation (whose id is 0) is returned if a
+// new conversation should be started instead.
+func (s *session) selectConversation(c *cli.Context) (persistence.Conversation, error) {
+	// The two flags select different conversations to continue, so at most one of
+	// them may be set.
+	if c.Bool("continue") && c.IsSet("conversation") {
+		return persistence.Conversation{}, fmt.Errorf("The --continue and --conversation flags cannot be used together.")
+	}
+
+	// A specific conversation was requested, so it’s an error if it doesn’t
+	// exist; we should not silently start a new one.
+	if c.IsSet("conversation") {
+		return s.db.FetchConversation(c.Int64("conversation"))
+	}
+
+	// Attempt to retrieve the most recent converastion from the database or just
+	// start a new conversation if there are none.
+	if c.Bool("continue") {
+		if recentConversation, err := s.db.FetchRecentConversation(); err == nil {
+			return recentConversation, nil
+		}
+	}
+
+	return persistence.Conversation{}, nil
+}
+
+// Sends the user’s message to the LLM, preceded by the existing messages in the
+// conversation, and streams the reply to the output.
+//
+// All messages are recorded in the database, including any tool calls made by
+// the LLM and their results. If the conversation hasn’t been stored yet (i.e.
+// its id is 0), it will be created. The conversation is updated in place, so it
+// can be passed to subsequent calls.
+//
+// The request is aborted if the context is canceled or the `request-timeout`
+// expires, in which case the partially streamed reply is marked as
+// interrupted.
+func (s *session) sendMessage(ctx context.Context, conversation *persistence.Conversation, userMessage string) error {
+	// The context hasn’t been prepared yet if it depends on the user’s message.
+	if s.fullSystemMessage == "" {
+		if err := s.selectRelevantDocuments(conversation, userMessage); err != nil {
+			return err
+		}
+	}
+
+	// Messages to be sent to the LLM. This will include existing messages in the
+	// conversation, followed by the current message.
+	messages := s.historyMessages(conversation)
+	messages = append(messages, llm_provider.Message{Role: "user", Content: userMessage})
+
+	// Nothing is recorded in the database until the LLM starts replying.
+	userMessageRecorded := false
+
+	recordUserMessage := func() error {
+		if userMessageRecorded {
+			return nil
+		}
+
+		if conversation.Id == 0 {
+			dbConversation, err := s.db.InitializeConversation()
+			if err != nil {
+				return err
+			}
+			conversation.Id = dbConversation.Id
+		}
+
+		// Record the documents selected for the context, so that the conversation
+		// can be continued with the same documents.
+		if s.selectedPaths != nil && conversation.DocumentPaths == nil {
+			if err := s.db.RecordConversationDocuments(conversation.Id, s.selectedPaths); err != nil {
+				return err
+			}
+			conversation.DocumentPaths = s.selectedPaths
+		}
+
+		// Record the selection given by flags, so that the conversation can be
+		// continued with the same documents.
+		if s.recordSelection {
+			selection := persistence.DocumentSelection(s.selection)
+			if err := s.db.RecordDocumentSelection(conversation.Id, selection); err != nil {
+				return err
+			}
+			conversation.DocumentSelection = &selection
+			s.recordSelection = false
+		}
+
+		userMsg, err := s.db.InsertMessageIntoConversation(
+			conversation.Id,
+			"user",
+			userMessage,
+		)
+		if err != nil {
+			return err
+		}
+
+		conversation.Messages = append(conversation.Messages, userMsg)
+		userMessageRecorded = true
+
+		return nil
+	}
+
+	// Nil pointer to the assistant’s message in the database. It will be
+	// initiated only after the first batch of tokens is received.
+	var dbAssistantReply *persistence.Message
+
+	var reply strings.Builder
+
+	handleTokens := func(tokens string) error {
+		if err := recordUserMessage(); err != nil {
+			return err
+		}
+
+		if dbAssistantReply == nil {
+			assistantMsg, err := s.db.InsertMessageIntoConversation(
+				conversation.Id,
+				"assistant",
+				"",
+			)
+			if err != nil {
+				return err
+			}
+			dbAssistantReply = &assistantMsg
+		}
+
+		fmt.Fprint(s.output, tokens)
+		reply.WriteString(tokens)
+
+		return s.db.WriteToMessage(dbAssistantReply.Id, tokens)
+	}
+
+	// Records an assistant message containing tool calls (whose text, if any,
+	// has already been streamed) or the result of a tool call.
+	handleMessage := func(m llm_provider.Message) error {
+		if err := recordUserMessage(); err != nil {
+			return err
+		}
+
+		if m.Role == "tool" {
+			toolResult, err := s.db.InsertToolResult(conversation.Id, m.ToolCallId, m.Content, m.IsError)
+			if err != nil {
+				return err
+			}
+
+			conversation.Messages = append(conversation.Messages, toolResult)
+
+			return nil
+		}
+
+		if dbAssistantReply == nil {
+			assistantMsg, err := s.db.InsertMessageIntoConversation(
+				conversation.Id,
+				"assistant",
+				m.Content,
+			)
+			if err != nil {
+				return err
+			}
+			dbAssistantReply = &assistantMsg
+		}
+
+		var toolCalls []persistence.ToolCall
+		for _, call := range m.ToolCalls {
+			toolCalls = append(toolCalls, persistence.ToolCall{
+				Id:        call.Id,
+				Name:      call.Name,
+				Arguments: call.Arguments,
+			})
+		}
+
+		if err := s.db.RecordToolCalls(dbAssistantReply.Id, toolCalls); err != nil {
+			return err
+		}
+
+		if err := s.db.RecordUsage(dbAssistantReply.Id, s.model, persistence.Usage(m.Usage)); err != nil {
+			return err
+		}
+
+		if err := s.db.RecordStopReason(dbAssistantReply.Id, string(llm_provider.StopReasonToolUse)); err != nil {
+			return err
+		}
+
+		dbAssistantReply.Content = m.Content
+		dbAssistantReply.ToolCalls = toolCalls
+		dbAssistantReply.Model = s.model
+		dbAssistantReply.Usage = persistence.Usage(m.Usage)
+		dbAssistantReply.StopReason = string(llm_provider.StopReasonToolUse)
+		conversation.Messages = append(conversation.Messages, *dbAssistantReply)
+
+		// Any text that follows will be recorded as a new message.
+		dbAssistantReply = nil
+		reply.Reset()
+
+		return nil
+	}
+
+	// The timeout has already been validated along with the rest of the config.
+	if timeout, err := time.ParseDuration(s.config.RequestTimeout); err == nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var usage llm_provider.Usage
+	var stopReason llm_provider.StopReason
+	var err error
+
+	// A reply that was cut off because it reached the maximum number of tokens
+	// is continued by follow-up requests, up to the configured number of times.
+	// The continuations are appended to the same message.
+	for continuations := 0; ; continuations++ {
+		var completion llm_provider.Completion
+		completion, err = s.requestCompletion(ctx, messages, handleTokens, handleMessage)
+
+		usage = usage.Add(completion.Usage)
+		stopReason = completion.StopReason
+
+		if err != nil || stopReason != llm_provider.StopReasonMaxTokens || continuations >= s.config.AutoContinue || reply.Len() == 0 {
+			break
+		}
+
+		// The partial reply is passed back to the LLM, which is asked to continue
+		// it. The request to continue isn’t recorded in the conversation.
+		messages = append(
+			s.historyMessages(conversation),
+			llm_provider.Message{Role: "assistant", Content: reply.String()},
+			llm_provider.Message{Role: "user", Content: continuationPrompt},
+		)
+	}
+
+	// Keep the in-memory conversation in sync with the database, even if the
+	// stream was interrupted after some tokens had been recorded. In that case,
+	// the reply is marked as interrupted, so that it doesn’t look complete.
+	// The usage is recorded either way, although the API may not have reported
+	// it if the reply was interrupted.
+	if dbAssistantReply != nil {
+		dbAssistantReply.Content = reply.String()
+		dbAssistantReply.Model = s.model
+		dbAssistantReply.Usage = persistence.Usage(usage)
+
+		if usageErr := s.db.RecordUsage(dbAssistantReply.Id, s.model, dbAssistantReply.Usage); usageErr != nil {
+			err = errors.Join(err, usageErr)
+		}
+
+		if stopReason != "" {
+			dbAssistantReply.StopReason = string(stopReason)
+
+			if stopReasonErr := s.db.RecordStopReason(dbAssistantReply.Id, dbAssistantReply.StopReason); stopReasonErr != nil {
+				err = errors.Join(err, stopReasonErr)
+			}
+		}
+
+		if err != nil {
+			if markErr := s.db.MarkMessageInterrupted(dbAssistantReply.Id); markErr != nil {
+				err = errors.Join(err, markErr)
+			}
+			dbAssistantReply.Interrupted = true
+		}
+
+		conversation.Messages = append(conversation.Messages, *dbAssistantReply)
+	}
+
+	if err == nil && stopReason == llm_provider.StopReasonMaxTokens {
+		fmt.Fprintln(
+			s.errOutput,
+			`
+The reply was cut off, because it reached the maximum number of tokens. Consider increasing the "generation.max-tokens" or "auto-continue" configuration values.`,
+		)
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf(
+			`The LLM did not reply within %s, configurable by setting the "request-timeout" configuration setting.`,
+			s.config.RequestTimeout,
+		)
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return fmt.Errorf("The reply was interrupted.")
+	}
+
+	return err
+}
+
+// Prints the request that sendMessage would send to the LLM, encoded as JSON,
+// without sending it or recording anything in the database.
+func (s *session) printRequest(conversation *persistence.Conversation, userMessage string) error {
+	if s.fullSystemMessage == "" {
+		if err := s.selectRelevantDocuments(conversation, userMessage); err != nil {
+			return err
+		}
+	}
+
+	messages := s.historyMessages(conversation)
+	messages = append(messages, llm_provider.Message{Role: "user", Content: userMessage})
+
+	var request any
+	if builder, ok := s.provider.(llm_provider.RequestBuilder); ok {
+		request = builder.BuildRequest(s.fullSystemMessage, messages, s.tools)
+	}
+
+	if request == nil {
+		return fmt.Errorf("The %s provider does not support dry runs.", s.config.Provider)
+	}
+
+	encoded, err := json.MarshalIndent(request, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(s.output, string(encoded))
+
+	return err
+}
+
+// Requests a completion of the messages from the LLM, with tools if they are
+// available.
+func (s *session) requestCompletion(
+	ctx context.Context,
+	messages []llm_provider.Message,
+	handleTokens func(tokens string) error,
+	handleMessage func(message llm_provider.Message) error,
+) (llm_provider.Completion, error) {
+	if s.tools != nil {
+		return s.provider.(llm_provider.ToolCallingLLMProvider).GetCompletionWithTools(
+			ctx,
+			s.fullSystemMessage,
+			messages,
+			s.tools,
+			handleTokens,
+			handleMessage,
+		)
+	}
+
+	return s.provider.GetCompletion(ctx, s.fullSystemMessage, messages, handleTokens)
+}
+
+// Converts the messages recorded in the conversation into messages for the
+// LLM. Tool calls and their results are replayed only if tools are available;
+// otherwise, they are left out, along with assistant messages that consist of
+// tool calls only.
+func (s *session) historyMessages(conversation *persistence.Conversation) []llm_provider.Message {
+	var messages []llm_provider.Message
+
+	for _, m := range conversation.Messages {
+		message := llm_provider.Message{Role: m.Role, Content: m.Content}
+
+		if s.tools == nil {
+			if m.Role == "tool" || (len(m.ToolCalls) > 0 && m.Content == "") {
+				continue
+			}
+		} else {
+			message.ToolCallId = m.ToolCallId
+			message.IsError = m.IsError
+			for _, call := range m.ToolCalls {
+				message.ToolCalls = append(message.ToolCalls, llm_provider.ToolCall{
+					Id:        call.Id,
+					Name:      call.Name,
+					Arguments: call.Arguments,
+				})
+			}
+		}
+
+		messages = append(messages, message)
+	}
+
+	return messages
+}
+
+// Prunes older conversations from the database, as configured by the user.
+func (s *session) pruneHistory() error {
+	if s.config.MaxConversationHistory > -1 {
+		return s.db.PruneOldConversations(s.config.MaxConversationHistory)
+	}
+
+	return nil
+}
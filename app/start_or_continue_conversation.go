@@ -4,13 +4,14 @@ import (
 	"errors"
 	"fmt"
 	"github.com/malinowskip/pal/config"
-	"github.com/malinowskip/pal/documents"
-	"github.com/malinowskip/pal/llm_provider"
-	"github.com/malinowskip/pal/persistence"
+	"github.com/malinowskip/pal/tokenizer"
 	"github.com/malinowskip/pal/util"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"unicode/utf8"
 
-	"github.com/dustin/go-humanize"
 	"github.com/urfave/cli/v2"
 )
 
@@ -24,196 +25,36 @@ func StartOrContinueConversation(c *cli.Context) error {
 		return err
 	}
 
-	// Root path of the project. If not set by the user, it will be set to the
-	// current directory, i.e. ".".
-	projectPath := c.Path("project-path")
-	if projectPath == "" {
-		return fmt.Errorf("The project path may not be empty.")
-	}
-
-	// Default config values overridden by any values defined by the user in
-	// `pal.toml`.
-	finalConfig, err := resolveFinalConfig(projectPath)
-	if err != nil {
-		return err
-	}
-
-	// After initialization, the LLM provider should be ready to generate
-	// completions. However, the initialization itself doesn’t send any external
-	// requests yet, so potential errors might be returned later on, when we
-	// request a chat completion (e.g. if the user provides an invalid API key).
-	provider, err := llm_provider.ResolveFromConfig(&finalConfig)
-	if err != nil {
-		return err
-	}
-
-	// Maximum size of documents to be included in the context. In the config, this
-	// value is specified using SI notation, e.g. “10K”, so it needs to be
-	// converted to bytes.
-	maxFileSize, err := humanize.ParseBigBytes(finalConfig.MaxFileSize)
-	if err != nil {
-		return errors.Join(fmt.Errorf("The config is invalid."), err)
-	}
-
-	// Load all project documents that will be included in the context.
-	documents, err := documents.LoadDocuments(
-		projectPath,
-		finalConfig.Exclude,
-		maxFileSize.Int64(),
-	)
-	if err != nil {
-		return err
-	}
-
-	// Concatenate all documents into a single string that will be passed to the
-	// LLM at the end of the system message.
-	context, err := assembleContextString(&documents)
-	if err != nil {
-		return err
-	}
-
-	// Exit if the context is too long.
-	if err = checkContextLength(context, finalConfig.MaxContextLength); err != nil {
-		return err
-	}
-
-	// System message followed by the context string.
-	fullSystemMessage := fmt.Sprintf("%s\n\n%s", finalConfig.SystemMessage, context)
+	return converse(c, userMessage)
+}
 
-	// Initialize database connection for saving and retrieving conversations from
-	// the local database.
-	db, err := persistence.StartClient(projectPath)
+// Sends the message to the LLM and streams the reply, or prints the request
+// with the --dry-run flag.
+func converse(c *cli.Context, userMessage string) error {
+	// Load the config, the context and the LLM provider, and connect to the
+	// database. If the --continue or --conversation flag is set, the message will
+	// be added to an existing conversation. Otherwise, we will start a new
+	// conversation and store its contents in the database.
+	session, conversation, err := startSession(c)
 	if err != nil {
 		return err
 	}
 
-	// PATH 1: start a new conversation
-	//
-	// If the --continue flag is not set or if there are no conversations in the
-	// database, we will start a new conversation and store its contents in the
-	// database.
-	startNewConversation := func() error {
-		// Since this is a new conversation, only one message will be passed to the
-		// LLM.
-		messages := []llm_provider.Message{
-			{Role: "user", Content: userMessage},
-		}
-
-		// Nil pointer to the assistant’s upcoming reply in the database. It will be
-		// initiated only after the first batch of tokens is received.
-		var dbAssistantReply *persistence.Message
-
-		err = provider.GetCompletion(fullSystemMessage, messages, func(tokens string) error {
-			if dbAssistantReply == nil {
-				dbConversation, err := db.InitializeConversation()
-				if err != nil {
-					return err
-				}
-				_, err = db.InsertMessageIntoConversation(
-					dbConversation.Id,
-					"user",
-					userMessage,
-				)
-				if err != nil {
-					return err
-				}
-				assistantMsg, err := db.InsertMessageIntoConversation(
-					dbConversation.Id,
-					"assistant",
-					"",
-				)
-				if err != nil {
-					return err
-				}
-				dbAssistantReply = &assistantMsg
-			}
-
-			fmt.Print(tokens)
-			if err = db.WriteToMessage(dbAssistantReply.Id, tokens); err != nil {
-				return err
-			}
-
-			return nil
-		})
-
-		return err
-	}
-
-	// PATH 2: continue an existing conversation.
-	//
-	// If the --continue flag is set, attempt to fetch the most recent conversation
-	// from the database and continue it. Otherwise, fall back to
-	// `startNewConversation`.
-	continueLastConversation := func() error {
-		// Attempt to retrieve the most recent converastion from the database or just
-		// start a new conversation on error.
-		recentConversation, err := db.FetchRecentConversation()
-		if err != nil {
-			return startNewConversation()
-		}
-
-		// Messages to be sent to the LLM. This will include existing messages in the
-		// conversation, followed by the current message.
-		var messages []llm_provider.Message
-
-		// Include messages retrieved from the database.
-		for _, m := range recentConversation.Messages {
-			messages = append(messages, llm_provider.Message{Role: m.Role, Content: m.Content})
-		}
-
-		// Include the current message.
-		messages = append(messages, llm_provider.Message{Role: "user", Content: userMessage})
-
-		// Nil pointer to the assistant’s upcoming reply in the database. It will be
-		// initiated only after the first batch of tokens is received.
-		var dbAssistantReply *persistence.Message
-
-		err = provider.GetCompletion(fullSystemMessage, messages, func(tokens string) error {
-			if dbAssistantReply == nil {
-				_, err = db.InsertMessageIntoConversation(recentConversation.Id, "user", userMessage)
-				if err != nil {
-					return err
-				}
-
-				assistantMsg, err := db.InsertMessageIntoConversation(
-					recentConversation.Id,
-					"assistant",
-					"",
-				)
-				if err != nil {
-					return err
-				}
-				dbAssistantReply = &assistantMsg
-			}
-
-			fmt.Print(tokens)
-			if err = db.WriteToMessage(dbAssistantReply.Id, tokens); err != nil {
-				return err
-			}
-
-			return nil
-		})
-
-		return err
+	// With the --dry-run flag, the request is printed instead of being sent.
+	if c.Bool("dry-run") {
+		return session.printRequest(&conversation, userMessage)
 	}
 
-	if c.Bool("continue") == true {
-		err = continueLastConversation()
-	} else {
-		err = startNewConversation()
-	}
+	// Abort the request if the user presses Ctrl-C or the process is terminated,
+	// so that the partially streamed reply is recorded as interrupted.
+	ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	if err != nil {
+	if err = session.sendMessage(ctx, &conversation, userMessage); err != nil {
 		return err
 	}
 
-	if finalConfig.MaxConversationHistory > -1 {
-		if err = db.PruneOldConversations(finalConfig.MaxConversationHistory); err != nil {
-			return err
-		}
-	}
-
-	return err
+	return session.pruneHistory()
 }
 
 // Fetch the message provided by the user. The message might come from up to two
@@ -248,28 +89,69 @@ func fetchUserMessage(c *cli.Context) (string, error) {
 	return finalMessage, nil
 }
 
-func resolveFinalConfig(projectPath string) (config.Config, error) {
-	userConfig, err := fetchUserConfig(projectPath)
+// Resolves the final config by merging its layers (see configLayers) on top of
+// the defaults.
+func resolveFinalConfig(c *cli.Context) (config.Config, error) {
+	finalConfig, _, err := resolveConfigSources(c)
+
+	return finalConfig, err
+}
 
+// Resolves the final config along with the layer that each value came from.
+func resolveConfigSources(c *cli.Context) (config.Config, config.Sources, error) {
+	layers, err := configLayers(c)
 	if err != nil {
-		return userConfig, err
+		return config.Config{}, nil, err
 	}
 
-	finalConfig, err := config.ResolveConfig(&userConfig)
+	finalConfig, sources, err := config.ResolveLayers(layers)
 
 	if err != nil {
-		return finalConfig, errors.Join(fmt.Errorf("The config is invalid."), err)
+		return finalConfig, sources, errors.Join(fmt.Errorf("The config is invalid."), err)
 	}
 
-	return finalConfig, nil
+	return finalConfig, sources, nil
 }
 
-func checkContextLength(input string, maxLength int) error {
-	if len(input) > maxLength {
+// Returns the context limit set in the config, along with a function that
+// measures text in the same unit as the limit: tokens if `max-context-tokens`
+// is set, characters otherwise.
+func contextBudget(conf *config.Config) (int, func(text string) int) {
+	if conf.MaxContextTokens > 0 {
+		return conf.MaxContextTokens, tokenizer.ForConfig(conf).CountTokens
+	}
+
+	return conf.MaxContextLength, utf8.RuneCountInString
+}
+
+// Checks the length of the context against the limits set in the config. If
+// `max-context-tokens` is set, the context’s tokens are counted using the
+// tokenizer of the selected model. Otherwise, its characters are counted and
+// compared against `max-context-length`.
+func checkContextLength(input string, conf *config.Config) error {
+	if conf.MaxContextTokens > 0 {
+		tokenizer := tokenizer.ForConfig(conf)
+		tokenCount := tokenizer.CountTokens(input)
+
+		if tokenCount > conf.MaxContextTokens {
+			return fmt.Errorf(
+				`Context length (%d tokens, %s) exceeds the maximum permitted context (%d tokens), configurable by setting the "max-context-tokens" configuration setting.`,
+				tokenCount,
+				tokenizer.Name(),
+				conf.MaxContextTokens,
+			)
+		}
+
+		return nil
+	}
+
+	length := utf8.RuneCountInString(input)
+
+	if length > conf.MaxContextLength {
 		return fmt.Errorf(
 			`Context length (%d) exceeds the maximum permitted context (%d), configurable by setting the "max-context-length" configuration setting (counted in characters).`,
-			len(input),
-			maxLength,
+			length,
+			conf.MaxContextLength,
 		)
 	}
 
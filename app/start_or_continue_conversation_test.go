@@ -1,12 +1,20 @@
 package app
 
 import (
-	"os"
+	"context"
+	"encoding/json"
+	"fmt"
 	"github.com/malinowskip/pal/config"
+	"github.com/malinowskip/pal/constants"
 	"github.com/malinowskip/pal/llm_provider"
 	"github.com/malinowskip/pal/persistence"
 	"github.com/malinowskip/pal/testutil"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"path"
+	"strings"
 	"testing"
 )
 
@@ -28,7 +36,7 @@ func TestHappyPath(t *testing.T) {
 
 	expectedConvo := persistence.Conversation{Id: 1, Messages: []persistence.Message{
 		{Id: 1, Role: "user", Content: "Hello"},
-		{Id: 2, Role: "assistant", Content: llm_provider.TestProviderExpectedMessage},
+		testProviderReply(2),
 	}}
 
 	testutil.AssertDeepEquals(t, convo, expectedConvo)
@@ -50,24 +58,38 @@ func TestHappyPath(t *testing.T) {
 		Content: "Hello again",
 	})
 
-	expectedConvo.Messages = append(expectedConvo.Messages, persistence.Message{
-		Id:      4,
-		Role:    "assistant",
-		Content: llm_provider.TestProviderExpectedMessage,
-	})
+	expectedConvo.Messages = append(expectedConvo.Messages, testProviderReply(4))
 
 	testutil.AssertDeepEquals(t, convo, expectedConvo)
 }
 
-func TestExitsEarlyIfNoConfigFileInProject(t *testing.T) {
-	projectPath, _ := instantiateEnvironment(t)
+func TestRunsWithoutConfigFileInProject(t *testing.T) {
+	projectPath, db := instantiateEnvironment(t)
 	configPath := path.Join(projectPath, "pal.toml")
 	if err := os.Remove(configPath); err != nil {
 		t.Error(err)
 	}
 
-	err := Run([]string{"pal", "--path", projectPath, "hello"})
-	testutil.AssertDeepEquals(t, err.Error(), "Failed to open config file.")
+	// Without a project config, the provider is selected in the global config.
+	globalConfigPath := path.Join(os.Getenv("XDG_CONFIG_HOME"), "pal", "config.toml")
+	if err := os.MkdirAll(path.Dir(globalConfigPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(globalConfigPath, []byte(`provider = "testing"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Run([]string{"pal", "--path", projectPath, "hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	convo, err := db.FetchRecentConversation()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.AssertDeepEquals(t, convo.Messages[1], testProviderReply(2))
 }
 
 func TestExitsEarlyIfMessageEmpty(t *testing.T) {
@@ -137,6 +159,9 @@ func TestPrunesOldConversations(t *testing.T) {
 func instantiateEnvironment(t *testing.T) (string, persistence.DatabaseClient) {
 	projectPath := t.TempDir()
 
+	// The user’s own global config shouldn’t affect the tests.
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
 	conf, err := config.ResolveConfig(&config.Config{Provider: "testing"})
 	if err != nil {
 		t.Error(err)
@@ -154,6 +179,17 @@ func instantiateEnvironment(t *testing.T) (string, persistence.DatabaseClient) {
 	return projectPath, client
 }
 
+// Redirects the app’s input and output for the duration of the test.
+func redirectAppIO(t *testing.T, reader io.Reader, writer io.Writer) {
+	t.Helper()
+
+	app.Reader, app.Writer = reader, writer
+
+	t.Cleanup(func() {
+		app.Reader, app.Writer = os.Stdin, os.Stdout
+	})
+}
+
 func saveConfigToFile(projectPath string, conf config.Config) error {
 	toml, err := conf.ToToml()
 	if err != nil {
@@ -168,3 +204,666 @@ func saveConfigToFile(projectPath string, conf config.Config) error {
 
 	return nil
 }
+
+// The reply of the test provider, as recorded in the database.
+func testProviderReply(id int64) persistence.Message {
+	return persistence.Message{
+		Id:         id,
+		Role:       "assistant",
+		Content:    llm_provider.TestProviderExpectedMessage,
+		Model:      "testing",
+		Usage:      persistence.Usage(llm_provider.TestProviderUsage),
+		StopReason: string(llm_provider.StopReasonEndTurn),
+	}
+}
+
+func TestContinuesConversationById(t *testing.T) {
+	projectPath, db := instantiateEnvironment(t)
+
+	if err := Run([]string{"pal", "--path", projectPath, "First"}); err != nil {
+		t.Error(err)
+	}
+
+	if err := Run([]string{"pal", "--path", projectPath, "Second"}); err != nil {
+		t.Error(err)
+	}
+
+	if err := Run([]string{"pal", "--path", projectPath, "--conversation", "1", "First again"}); err != nil {
+		t.Error(err)
+	}
+
+	convo, err := db.FetchConversation(1)
+	if err != nil {
+		t.Error(err)
+	}
+
+	expectedConvo := persistence.Conversation{Id: 1, Messages: []persistence.Message{
+		{Id: 1, Role: "user", Content: "First"},
+		testProviderReply(2),
+		{Id: 5, Role: "user", Content: "First again"},
+		testProviderReply(6),
+	}}
+
+	testutil.AssertDeepEquals(t, convo, expectedConvo)
+
+	// The most recent conversation should be left intact.
+	recentConvo, err := db.FetchRecentConversation()
+	if err != nil {
+		t.Error(err)
+	}
+
+	testutil.AssertDeepEquals(t, recentConvo.Id, int64(2))
+	testutil.AssertLength(t, recentConvo.Messages, 2)
+
+	t.Run("Fails if the conversation does not exist", func(t *testing.T) {
+		err := Run([]string{"pal", "--path", projectPath, "--resume", "42", "Hello"})
+		testutil.AssertDeepEquals(t, err.Error(), "Conversation 42 does not exist.")
+
+		summaries, _ := db.ListConversations(10, 0)
+		testutil.AssertLength(t, summaries, 2)
+	})
+
+	t.Run("Cannot be combined with --continue", func(t *testing.T) {
+		err := Run([]string{"pal", "--path", projectPath, "--continue", "--conversation", "1", "Hello"})
+		if err == nil {
+			t.Error("The --continue and --conversation flags should be mutually exclusive.")
+		}
+	})
+}
+
+func TestExitsEarlyIfContextExceedsTokenLimit(t *testing.T) {
+	projectPath, _ := instantiateEnvironment(t)
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	conf, err := config.ResolveConfig(&config.Config{
+		Provider:         "openai",
+		MaxContextTokens: 10,
+	})
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if err := saveConfigToFile(projectPath, conf); err != nil {
+		t.Error(err)
+	}
+
+	testFilePath := path.Join(projectPath, "README.md")
+	if err = os.WriteFile(testFilePath, []byte("Hello, world!"), 0755); err != nil {
+		t.Error(err)
+	}
+
+	err = Run([]string{"pal", "--path", projectPath, "hello"})
+
+	if err == nil || !strings.Contains(err.Error(), "(31 tokens, o200k_base)") {
+		t.Errorf("Should throw an error if the context has too many tokens (error: %v).", err)
+	}
+}
+
+func TestCheckContextLength(t *testing.T) {
+	conf := config.DefaultConfig()
+	conf.MaxContextLength = 4
+
+	t.Run("Counts characters rather than bytes", func(t *testing.T) {
+		if err := checkContextLength("żółć", &conf); err != nil {
+			t.Error(err)
+		}
+
+		if err := checkContextLength("żółćx", &conf); err == nil {
+			t.Error("The context exceeds the limit.")
+		}
+	})
+
+	t.Run("Token limit takes precedence", func(t *testing.T) {
+		conf := conf
+		conf.MaxContextTokens = 4
+
+		if err := checkContextLength("Hello, world!", &conf); err != nil {
+			t.Error(err)
+		}
+
+		if err := checkContextLength("Hello, world! Hello!", &conf); err == nil {
+			t.Error("The context exceeds the token limit.")
+		}
+	})
+}
+
+func TestSelectsRelevantDocuments(t *testing.T) {
+	projectPath, db := instantiateEnvironment(t)
+	conf, err := config.ResolveConfig(&config.Config{
+		Provider:         "testing",
+		ContextStrategy:  "relevant",
+		MaxContextLength: 450,
+	})
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if err := saveConfigToFile(projectPath, conf); err != nil {
+		t.Error(err)
+	}
+
+	files := map[string]string{
+		"bananas.md":  "Bananas are yellow. " + strings.Repeat("Bananas. ", 10),
+		"apples.md":   "Apples are red. " + strings.Repeat("Apples. ", 10),
+		"cherries.md": "Cherries are red. " + strings.Repeat("Cherries. ", 10),
+	}
+
+	testutil.WriteTestFiles(t, projectPath, files)
+
+	if err = Run([]string{"pal", "--path", projectPath, "What color are bananas?"}); err != nil {
+		t.Error(err)
+	}
+
+	convo, err := db.FetchRecentConversation()
+	if err != nil {
+		t.Error(err)
+	}
+
+	// Only two documents fit within the limit, and the most relevant one is
+	// always selected.
+	testutil.AssertLength(t, convo.DocumentPaths, 2)
+	testutil.AssertContains(t, convo.DocumentPaths, func(p string) bool { return p == "bananas.md" })
+
+	t.Run("Continuing the conversation reuses the documents", func(t *testing.T) {
+		if err = Run([]string{"pal", "--path", projectPath, "--continue", "What about cherries?"}); err != nil {
+			t.Error(err)
+		}
+
+		continuedConvo, err := db.FetchRecentConversation()
+		if err != nil {
+			t.Error(err)
+		}
+
+		testutil.AssertDeepEquals(t, continuedConvo.Id, convo.Id)
+		testutil.AssertLength(t, continuedConvo.Messages, 4)
+		testutil.AssertDeepEquals(t, continuedConvo.DocumentPaths, convo.DocumentPaths)
+	})
+}
+
+func TestUsesToolsToReadDocuments(t *testing.T) {
+	projectPath, db := instantiateEnvironment(t)
+	conf, err := config.ResolveConfig(&config.Config{
+		Provider:        "testing",
+		ContextStrategy: "tools",
+	})
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if err := saveConfigToFile(projectPath, conf); err != nil {
+		t.Error(err)
+	}
+
+	if err = os.WriteFile(path.Join(projectPath, "notes.md"), []byte("Notes"), 0755); err != nil {
+		t.Error(err)
+	}
+
+	if err = Run([]string{"pal", "--path", projectPath, "What files are there?"}); err != nil {
+		t.Error(err)
+	}
+
+	convo, err := db.FetchRecentConversation()
+	if err != nil {
+		t.Error(err)
+	}
+
+	// The test provider lists the project’s root directory before replying.
+	testutil.AssertDeepEquals(t, convo.Messages, []persistence.Message{
+		{Id: 1, Role: "user", Content: "What files are there?"},
+		{
+			Id:   2,
+			Role: "assistant",
+			ToolCalls: []persistence.ToolCall{
+				{Id: "call_1", Name: "list_dir", Arguments: llm_provider.TestProviderToolArguments},
+			},
+			Model:      "testing",
+			Usage:      persistence.Usage(llm_provider.TestProviderUsage),
+			StopReason: string(llm_provider.StopReasonToolUse),
+		},
+		{Id: 3, Role: "tool", Content: "notes.md", ToolCallId: "call_1"},
+		testProviderReply(4),
+	})
+
+	t.Run("Continuing the conversation replays the tool calls", func(t *testing.T) {
+		if err = Run([]string{"pal", "--path", projectPath, "--continue", "Thanks!"}); err != nil {
+			t.Error(err)
+		}
+
+		continuedConvo, err := db.FetchRecentConversation()
+		if err != nil {
+			t.Error(err)
+		}
+
+		testutil.AssertLength(t, continuedConvo.Messages, 8)
+	})
+
+	t.Run("Tool calls are shown in the history", func(t *testing.T) {
+		output, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "history", "show", "1"})
+		if err != nil {
+			t.Error(err)
+		}
+
+		if !strings.Contains(output, "[assistant]\n→ list_dir {}\n\n[tool]\nnotes.md\n") {
+			t.Errorf("Unexpected output: %q", output)
+		}
+	})
+
+	t.Run("Tool messages are left out with other strategies", func(t *testing.T) {
+		s := &session{}
+		messages := s.historyMessages(&convo)
+
+		testutil.AssertLength(t, messages, 2)
+		testutil.AssertDeepEquals(t, messages[1].Content, llm_provider.TestProviderExpectedMessage)
+	})
+}
+
+// A provider that streams the beginning of a reply and then waits until the
+// request is aborted. If `abort` is set, it is called after the first tokens
+// are streamed.
+type stallingProvider struct {
+	abort func()
+}
+
+func (p *stallingProvider) GetCompletion(
+	ctx context.Context,
+	fullSystemMessage string,
+	messages []llm_provider.Message,
+	handleTokens func(tokens string) error,
+) (llm_provider.Completion, error) {
+	if err := handleTokens("Hel"); err != nil {
+		return llm_provider.Completion{}, err
+	}
+
+	if p.abort != nil {
+		p.abort()
+	}
+
+	<-ctx.Done()
+
+	return llm_provider.Completion{}, ctx.Err()
+}
+
+func TestMarksInterruptedReplies(t *testing.T) {
+	_, db := instantiateEnvironment(t)
+
+	newSession := func(conf config.Config, provider llm_provider.LLMProvider) *session {
+		return &session{
+			config:            conf,
+			provider:          provider,
+			db:                db,
+			fullSystemMessage: "System",
+			output:            io.Discard,
+			errOutput:         io.Discard,
+		}
+	}
+
+	t.Run("Canceled by the user", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		s := newSession(config.DefaultConfig(), &stallingProvider{abort: cancel})
+
+		var convo persistence.Conversation
+		err := s.sendMessage(ctx, &convo, "Hi")
+
+		if err == nil || err.Error() != "The reply was interrupted." {
+			t.Errorf("Unexpected error: %v", err)
+		}
+
+		storedConvo, err := db.FetchConversation(convo.Id)
+		if err != nil {
+			t.Error(err)
+		}
+
+		testutil.AssertDeepEquals(t, storedConvo.Messages[1], persistence.Message{
+			Id:          storedConvo.Messages[1].Id,
+			Role:        "assistant",
+			Content:     "Hel",
+			Interrupted: true,
+		})
+		testutil.AssertDeepEquals(t, convo.Messages, storedConvo.Messages)
+	})
+
+	t.Run("Timed out", func(t *testing.T) {
+		conf := config.DefaultConfig()
+		conf.RequestTimeout = "10ms"
+
+		s := newSession(conf, &stallingProvider{})
+
+		var convo persistence.Conversation
+		err := s.sendMessage(context.Background(), &convo, "Hi")
+
+		if err == nil || !strings.Contains(err.Error(), `did not reply within 10ms`) {
+			t.Errorf("Unexpected error: %v", err)
+		}
+
+		testutil.AssertDeepEquals(t, convo.Messages[1].Interrupted, true)
+	})
+}
+
+func TestGenerationFlagsOverrideConfig(t *testing.T) {
+	projectPath, _ := instantiateEnvironment(t)
+
+	var receivedOptions map[string]any
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Options map[string]any `json:"options"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		receivedOptions = body.Options
+
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":"Hello"},"done":true}`)
+	}))
+	t.Cleanup(server.Close)
+
+	temperature := 0.5
+	conf, err := config.ResolveConfig(&config.Config{
+		Provider:   "ollama",
+		Generation: config.GenerationConfig{MaxTokens: 100, Temperature: &temperature},
+		Ollama: config.OllamaConfig{
+			Host:       server.URL,
+			Generation: config.GenerationConfig{Temperature: &temperature, Stop: []string{"STOP"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = saveConfigToFile(projectPath, conf); err != nil {
+		t.Fatal(err)
+	}
+
+	redirectAppIO(t, os.Stdin, io.Discard)
+
+	err = Run([]string{"pal", "--path", projectPath, "--temperature", "0.1", "--stop", "END", "Hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.AssertDeepEquals(t, receivedOptions, map[string]any{
+		"num_predict": float64(100),
+		"temperature": 0.1,
+		"stop":        []any{"END"},
+	})
+
+	t.Run("Invalid flags", func(t *testing.T) {
+		err := Run([]string{"pal", "--path", projectPath, "--top-p", "1.5", "Hello"})
+
+		if err == nil || !strings.Contains(err.Error(), `"ollama.generation.top-p"`) {
+			t.Errorf("An invalid top-p should result in an error (error: %v).", err)
+		}
+	})
+}
+
+// A provider whose replies are cut off by the token limit a given number of
+// times before the reply is finished. Each request is recorded.
+type truncatingProvider struct {
+	truncations int
+	requests    [][]llm_provider.Message
+}
+
+func (p *truncatingProvider) GetCompletion(
+	ctx context.Context,
+	fullSystemMessage string,
+	messages []llm_provider.Message,
+	handleTokens func(tokens string) error,
+) (llm_provider.Completion, error) {
+	p.requests = append(p.requests, messages)
+
+	completion := llm_provider.Completion{
+		Usage:      llm_provider.Usage{InputTokens: 10, OutputTokens: 5},
+		StopReason: llm_provider.StopReasonEndTurn,
+	}
+
+	tokens := "end."
+	if len(p.requests) <= p.truncations {
+		tokens = fmt.Sprintf("part %d, ", len(p.requests))
+		completion.StopReason = llm_provider.StopReasonMaxTokens
+	}
+
+	return completion, handleTokens(tokens)
+}
+
+func TestAutoContinuesTruncatedReplies(t *testing.T) {
+	projectPath, db := instantiateEnvironment(t)
+
+	newSession := func(autoContinue int, provider llm_provider.LLMProvider, errOutput io.Writer) *session {
+		conf := config.DefaultConfig()
+		conf.AutoContinue = autoContinue
+
+		return &session{
+			config:            conf,
+			provider:          provider,
+			db:                db,
+			fullSystemMessage: "System",
+			output:            io.Discard,
+			errOutput:         errOutput,
+		}
+	}
+
+	t.Run("Continues until the reply is finished", func(t *testing.T) {
+		provider := &truncatingProvider{truncations: 2}
+		var errOutput strings.Builder
+		s := newSession(3, provider, &errOutput)
+
+		var convo persistence.Conversation
+		if err := s.sendMessage(context.Background(), &convo, "Hi"); err != nil {
+			t.Fatal(err)
+		}
+
+		storedConvo, err := db.FetchConversation(convo.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The continuations are appended to a single message, which includes the
+		// usage of all requests.
+		testutil.AssertLength(t, storedConvo.Messages, 2)
+		testutil.AssertDeepEquals(t, storedConvo.Messages[1].Content, "part 1, part 2, end.")
+		testutil.AssertDeepEquals(t, storedConvo.Messages[1].StopReason, "end_turn")
+		testutil.AssertDeepEquals(t, storedConvo.Messages[1].Usage, persistence.Usage{InputTokens: 30, OutputTokens: 15})
+
+		// The partial reply is passed back to the LLM, followed by the request to
+		// continue it.
+		testutil.AssertLength(t, provider.requests, 3)
+		testutil.AssertDeepEquals(t, provider.requests[2], []llm_provider.Message{
+			{Role: "user", Content: "Hi"},
+			{Role: "assistant", Content: "part 1, part 2, "},
+			{Role: "user", Content: continuationPrompt},
+		})
+
+		testutil.AssertDeepEquals(t, errOutput.String(), "")
+	})
+
+	t.Run("Gives up after the configured number of continuations", func(t *testing.T) {
+		provider := &truncatingProvider{truncations: 5}
+		var errOutput strings.Builder
+		s := newSession(1, provider, &errOutput)
+
+		var convo persistence.Conversation
+		if err := s.sendMessage(context.Background(), &convo, "Hi"); err != nil {
+			t.Fatal(err)
+		}
+
+		testutil.AssertLength(t, provider.requests, 2)
+		testutil.AssertDeepEquals(t, convo.Messages[1].Content, "part 1, part 2, ")
+		testutil.AssertDeepEquals(t, convo.Messages[1].StopReason, "max_tokens")
+
+		if !strings.Contains(errOutput.String(), "The reply was cut off") {
+			t.Errorf("A truncated reply should result in a warning: %q", errOutput.String())
+		}
+	})
+
+	t.Run("Truncated replies are marked in the history", func(t *testing.T) {
+		s := newSession(0, &truncatingProvider{truncations: 1}, io.Discard)
+
+		var convo persistence.Conversation
+		if err := s.sendMessage(context.Background(), &convo, "Hi"); err != nil {
+			t.Fatal(err)
+		}
+
+		output, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "history", "show", fmt.Sprint(convo.Id)})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(output, "[assistant (truncated)]\npart 1, \n") {
+			t.Errorf("Unexpected output: %q", output)
+		}
+	})
+}
+
+func TestDryRunPrintsRequest(t *testing.T) {
+	// The request built by the test provider.
+	type request struct {
+		System   string                 `json:"system"`
+		Messages []llm_provider.Message `json:"messages"`
+	}
+
+	t.Run("New conversation", func(t *testing.T) {
+		projectPath := t.TempDir()
+		t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+		if err := saveConfigToFile(projectPath, config.Config{Provider: "testing"}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path.Join(projectPath, "main.go"), []byte("package main"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		output, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "--dry-run", "Hello"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var printed request
+		if err = json.Unmarshal([]byte(output), &printed); err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(printed.System, "package main") {
+			t.Errorf("The system message should include the context: %q", printed.System)
+		}
+		testutil.AssertDeepEquals(t, printed.Messages, []llm_provider.Message{{Role: "user", Content: "Hello"}})
+
+		// The database is not even created.
+		if _, err = os.Stat(path.Join(projectPath, constants.AppDir, "db.sqlite")); !os.IsNotExist(err) {
+			t.Error("A dry run should not create the database.")
+		}
+	})
+
+	t.Run("Continued conversation", func(t *testing.T) {
+		projectPath, db := instantiateEnvironment(t)
+
+		if err := Run([]string{"pal", "--path", projectPath, "Hello"}); err != nil {
+			t.Fatal(err)
+		}
+
+		output, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "--continue", "--dry-run", "Hello again"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var printed request
+		if err = json.Unmarshal([]byte(output), &printed); err != nil {
+			t.Fatal(err)
+		}
+
+		testutil.AssertDeepEquals(t, printed.Messages, []llm_provider.Message{
+			{Role: "user", Content: "Hello"},
+			{Role: "assistant", Content: llm_provider.TestProviderExpectedMessage},
+			{Role: "user", Content: "Hello again"},
+		})
+
+		// Nothing is recorded.
+		convo, err := db.FetchRecentConversation()
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertLength(t, convo.Messages, 2)
+	})
+
+	t.Run("Without an API key", func(t *testing.T) {
+		projectPath := t.TempDir()
+		t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+		t.Setenv("ANTHROPIC_API_KEY", "")
+
+		if err := saveConfigToFile(projectPath, config.Config{Provider: "anthropic"}); err != nil {
+			t.Fatal(err)
+		}
+
+		output, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "--dry-run", "Hello"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var printed struct {
+			Messages []struct {
+				Role string `json:"role"`
+			} `json:"messages"`
+		}
+		if err = json.Unmarshal([]byte(output), &printed); err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertLength(t, printed.Messages, 1)
+
+		// Sending the request requires the key.
+		_, err = runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "Hello"})
+		if err == nil || !strings.Contains(err.Error(), "ANTHROPIC_API_KEY") {
+			t.Errorf("A missing API key should result in an error (error: %v).", err)
+		}
+	})
+}
+
+func TestSelectionFlagsArePersistedWithConversation(t *testing.T) {
+	projectPath, db := instantiateEnvironment(t)
+
+	testutil.WriteTestFiles(t, projectPath, map[string]string{"a.go": "package a", "b.go": "package b", "notes.txt": "Notes"})
+
+	if err := Run([]string{"pal", "--path", projectPath, "--only", "*.go", "--exclude", "b.go", "Hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	convo, err := db.FetchRecentConversation()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.AssertDeepEquals(t, convo.DocumentSelection, &persistence.DocumentSelection{
+		Only:    []string{"*.go"},
+		Exclude: []string{"b.go"},
+	})
+
+	// The system message of a continued conversation includes the same
+	// documents.
+	systemMessage := func(args ...string) string {
+		output, err := runAndCaptureOutput(t, append([]string{"pal", "--path", projectPath, "--dry-run"}, args...))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var request struct {
+			System string `json:"system"`
+		}
+		if err = json.Unmarshal([]byte(output), &request); err != nil {
+			t.Fatal(err)
+		}
+
+		return request.System
+	}
+
+	system := systemMessage("--continue", "Hello again")
+	if !strings.Contains(system, "package a") || strings.Contains(system, "package b") || strings.Contains(system, "Notes") {
+		t.Errorf("Unexpected context: %q", system)
+	}
+
+	// New flags replace the recorded ones.
+	system = systemMessage("--continue", "--only", "*.txt", "Hello again")
+	if strings.Contains(system, "package a") || !strings.Contains(system, "Notes") {
+		t.Errorf("Unexpected context: %q", system)
+	}
+}
@@ -0,0 +1,110 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/malinowskip/pal/documents"
+	"github.com/malinowskip/pal/llm_provider"
+	"strings"
+)
+
+// Tools that let the LLM explore the project’s documents, used with the
+// `tools` context strategy.
+func projectTools(docs []documents.Document) []llm_provider.Tool {
+	return []llm_provider.Tool{
+		{
+			Name:        "list_dir",
+			Description: "Lists the files and subdirectories (marked by a trailing slash) of a directory in the project. Use \".\" for the project root.",
+			Parameters:  pathParameters("Path of the directory, relative to the project root."),
+			Run: func(arguments string) (string, error) {
+				var args pathArguments
+				if err := parseToolArguments(arguments, &args); err != nil {
+					return "", err
+				}
+
+				if args.Path == "" {
+					args.Path = "."
+				}
+
+				entries, err := documents.ListDir(docs, args.Path)
+				if err != nil {
+					return "", err
+				}
+
+				return strings.Join(entries, "\n"), nil
+			},
+		},
+		{
+			Name:        "read_file",
+			Description: "Reads the contents of a file in the project.",
+			Parameters:  pathParameters("Path of the file, relative to the project root."),
+			Run: func(arguments string) (string, error) {
+				var args pathArguments
+				if err := parseToolArguments(arguments, &args); err != nil {
+					return "", err
+				}
+
+				return documents.ReadFile(docs, args.Path)
+			},
+		},
+		{
+			Name:        "grep",
+			Description: "Searches all files in the project for lines matching a regular expression (RE2 syntax). Returns the matching lines prefixed with the file path and line number.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"pattern": map[string]any{
+						"type":        "string",
+						"description": "The regular expression to search for.",
+					},
+				},
+				"required": []string{"pattern"},
+			},
+			Run: func(arguments string) (string, error) {
+				var args struct {
+					Pattern string `json:"pattern"`
+				}
+				if err := parseToolArguments(arguments, &args); err != nil {
+					return "", err
+				}
+
+				matches, err := documents.Grep(docs, args.Pattern)
+				if err != nil {
+					return "", err
+				}
+
+				if len(matches) == 0 {
+					return "No matches.", nil
+				}
+
+				return strings.Join(matches, "\n"), nil
+			},
+		},
+	}
+}
+
+type pathArguments struct {
+	Path string `json:"path"`
+}
+
+// JSON schema of the arguments of a tool that accepts a single path.
+func pathParameters(description string) map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"path": map[string]any{
+				"type":        "string",
+				"description": description,
+			},
+		},
+		"required": []string{"path"},
+	}
+}
+
+func parseToolArguments(arguments string, target any) error {
+	if err := json.Unmarshal([]byte(arguments), target); err != nil {
+		return fmt.Errorf("Invalid arguments: %v", err)
+	}
+
+	return nil
+}
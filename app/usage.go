@@ -0,0 +1,147 @@
+package app
+
+import (
+	"fmt"
+	"github.com/malinowskip/pal/config"
+	"github.com/malinowskip/pal/persistence"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+)
+
+// This command summarizes the tokens used by the stored conversations and
+// estimates their cost, based on the price table in the config.
+func PrintUsage(c *cli.Context) error {
+	projectPath := c.Path("project-path")
+	if projectPath == "" {
+		return fmt.Errorf("The project path may not be empty.")
+	}
+
+	groupings := []persistence.UsageGrouping{
+		persistence.UsageByModel,
+		persistence.UsageByDay,
+		persistence.UsageByConversation,
+	}
+
+	if c.IsSet("by") {
+		grouping := persistence.UsageGrouping(c.String("by"))
+		if !slices.Contains(groupings, grouping) {
+			return fmt.Errorf(`Usage can be grouped by "model", "day" or "conversation".`)
+		}
+		groupings = []persistence.UsageGrouping{grouping}
+	}
+
+	finalConfig, err := resolveFinalConfig(c)
+	if err != nil {
+		return err
+	}
+
+	db, err := persistence.StartClient(projectPath)
+	if err != nil {
+		return err
+	}
+
+	// Models used in the conversations, but missing from the price table.
+	var unpricedModels []string
+
+	for i, grouping := range groupings {
+		summaries, err := db.SummarizeUsage(grouping)
+		if err != nil {
+			return err
+		}
+
+		if len(summaries) == 0 {
+			fmt.Fprintln(c.App.Writer, "No usage has been recorded yet.")
+			return nil
+		}
+
+		if i > 0 {
+			fmt.Fprintln(c.App.Writer)
+		}
+
+		if err = printUsageTable(c.App.Writer, grouping, summaries, finalConfig.Prices); err != nil {
+			return err
+		}
+
+		for _, summary := range summaries {
+			if _, ok := finalConfig.Prices[summary.Model]; !ok && !slices.Contains(unpricedModels, summary.Model) {
+				unpricedModels = append(unpricedModels, summary.Model)
+			}
+		}
+	}
+
+	if len(unpricedModels) > 0 {
+		fmt.Fprintf(
+			c.App.Writer,
+			"\nThe estimated cost doesn’t include models missing from the \"prices\" configuration value: %s.\n",
+			strings.Join(unpricedModels, ", "),
+		)
+	}
+
+	return nil
+}
+
+// Prints the summaries as a table with a row for each group. Groups that span
+// multiple models (e.g. a day on which different models were used) are
+// combined into a single row.
+func printUsageTable(
+	output io.Writer,
+	grouping persistence.UsageGrouping,
+	summaries []persistence.UsageSummary,
+	prices map[string]config.ModelPrice,
+) error {
+	w := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(w, "%s\tMESSAGES\tINPUT\tOUTPUT\tCACHE READ\tCACHE WRITE\tCOST\n", strings.ToUpper(string(grouping)))
+
+	// The summaries are sorted by group, so the rows of the same group are
+	// adjacent.
+	for start := 0; start < len(summaries); {
+		end := start + 1
+		for end < len(summaries) && summaries[end].Group == summaries[start].Group {
+			end++
+		}
+
+		var total persistence.UsageSummary
+		var cost float64
+
+		for _, summary := range summaries[start:end] {
+			total.MessageCount += summary.MessageCount
+			total.Usage.InputTokens += summary.Usage.InputTokens
+			total.Usage.OutputTokens += summary.Usage.OutputTokens
+			total.Usage.CacheReadTokens += summary.Usage.CacheReadTokens
+			total.Usage.CacheWriteTokens += summary.Usage.CacheWriteTokens
+			cost += estimateCost(summary.Usage, prices[summary.Model])
+		}
+
+		fmt.Fprintf(
+			w,
+			"%s\t%d\t%d\t%d\t%d\t%d\t$%.4f\n",
+			summaries[start].Group,
+			total.MessageCount,
+			total.Usage.InputTokens,
+			total.Usage.OutputTokens,
+			total.Usage.CacheReadTokens,
+			total.Usage.CacheWriteTokens,
+			cost,
+		)
+
+		start = end
+	}
+
+	return w.Flush()
+}
+
+// Estimates the cost of the usage in US dollars. The prices are specified per
+// million tokens.
+func estimateCost(usage persistence.Usage, price config.ModelPrice) float64 {
+	cost := float64(usage.InputTokens)*price.Input +
+		float64(usage.OutputTokens)*price.Output +
+		float64(usage.CacheReadTokens)*price.CacheRead +
+		float64(usage.CacheWriteTokens)*price.CacheWrite
+
+	return cost / 1_000_000
+}
@@ -0,0 +1,84 @@
+package app
+
+import (
+	"github.com/malinowskip/pal/config"
+	"github.com/malinowskip/pal/testutil"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUsage(t *testing.T) {
+	projectPath, _ := instantiateEnvironment(t)
+
+	output, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "usage"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.AssertDeepEquals(t, output, "No usage has been recorded yet.\n")
+
+	for _, message := range []string{"First question", "Second question"} {
+		if err := Run([]string{"pal", "--path", projectPath, message}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("Models without a price", func(t *testing.T) {
+		output, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "usage", "--by", "model"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Each reply of the test provider uses 1000 input tokens, 100 output tokens
+		// and 500 tokens read from the cache.
+		lines := strings.Split(strings.TrimSpace(output), "\n")
+		testutil.AssertDeepEquals(t, strings.Fields(lines[1]), []string{"testing", "2", "2000", "200", "1000", "0", "$0.0000"})
+
+		if !strings.Contains(output, `missing from the "prices" configuration value: testing.`) {
+			t.Errorf("The output should list the models without a price: %q", output)
+		}
+	})
+
+	conf, err := fetchConfigFile(path.Join(projectPath, "pal.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf.Prices["testing"] = config.ModelPrice{Input: 1, Output: 10, CacheRead: 0.1}
+	if err = saveConfigToFile(projectPath, conf); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Estimates the cost", func(t *testing.T) {
+		output, err := runAndCaptureOutput(t, []string{"pal", "--path", projectPath, "usage"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sections := strings.Split(strings.TrimSpace(output), "\n\n")
+		testutil.AssertLength(t, sections, 3)
+
+		// 2 × (1000 × $1 + 100 × $10 + 500 × $0.1) per million tokens.
+		byModel := strings.Split(sections[0], "\n")
+		testutil.AssertDeepEquals(t, strings.Fields(byModel[1]), []string{"testing", "2", "2000", "200", "1000", "0", "$0.0041"})
+
+		byDay := strings.Split(sections[1], "\n")
+		testutil.AssertDeepEquals(t, strings.Fields(byDay[1])[0], time.Now().Format("2006-01-02"))
+
+		byConversation := strings.Split(sections[2], "\n")
+		testutil.AssertLength(t, byConversation, 3)
+		testutil.AssertDeepEquals(t, strings.Fields(byConversation[1]), []string{"2", "1", "1000", "100", "500", "0", "$0.0021"})
+
+		if strings.Contains(output, "missing") {
+			t.Errorf("All models have a price: %q", output)
+		}
+	})
+
+	t.Run("Invalid grouping", func(t *testing.T) {
+		if err := Run([]string{"pal", "--path", projectPath, "usage", "--by", "week"}); err == nil {
+			t.Error("Grouping usage by week should result in an error.")
+		}
+	})
+}
@@ -1,6 +1,6 @@
-// Configuration options available to the user. They should be defined in a
-// config file in TOML format in the root directory of a project. The config
-// file is required, but all options are optional.
+// Configuration options available to the user. They can be defined in a config
+// file in TOML format in the root directory of a project, in the user’s global
+// config file, or in environment variables. All options are optional.
 
 package config
 
@@ -8,48 +8,281 @@ import (
 	_ "embed"
 	"errors"
 	"fmt"
+	"net/url"
+	"regexp"
 	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/dustin/go-humanize"
 	toml "github.com/pelletier/go-toml/v2"
 )
 
 type Config struct {
-	// LLM provider. Either `openai`, `anthropic` or `testing`.
+	// LLM provider. Either `openai`, `anthropic`, `ollama` or `testing`.
 	Provider string `toml:"provider,omitempty"`
 	// The system message is dynamically added to each request in a conversation,
 	// followed by the context string.
 	SystemMessage string `toml:"system-message,multiline,omitempty"`
 	// Additional .gitignore patterns for files that should be excluded from the
 	// context sent to the LLM.
-	Exclude []string `toml:"exclude"`
+	Exclude []string `toml:"exclude,omitempty"`
+	// .gitignore patterns of the files to be included in the context. If set,
+	// only files matching at least one of the patterns (or whose parent
+	// directory does) are loaded, after the exclusions are applied.
+	Include []string `toml:"include,omitempty"`
+	// Where the files of the project are listed. Either `filesystem` (every file
+	// in the project directory), `git-tracked` (only files committed to the git
+	// repository containing the project) or `git-staged` (committed files and
+	// files staged for the next commit).
+	Source string `toml:"source,omitempty"`
+	// .gitignore glob patterns for Go files that should be included in the
+	// context as outlines (declarations and doc comments without function
+	// bodies) rather than in full, e.g. `internal/**/*.go`.
+	Outline []string `toml:"outline,omitempty"`
+	// Redaction of secrets (such as API keys) from the documents before they are
+	// sent to the LLM.
+	Redact RedactConfig `toml:"redact,omitempty"`
+	// How documents are selected for the context. Either `all` (every document
+	// that isn’t excluded), `relevant` (the documents most relevant to the
+	// user’s first message that fit within the context limit) or `tools` (only
+	// the file tree; the LLM reads the documents it needs using tool calls).
+	ContextStrategy string `toml:"context-strategy,omitempty"`
 	// Maximum permitted length of the full context string (including XML tags).
 	MaxContextLength int `toml:"max-context-length,omitempty"`
+	// Maximum permitted number of tokens in the full context string, counted
+	// using the tokenizer of the selected model. If set, it takes precedence
+	// over `MaxContextLength`.
+	MaxContextTokens int `toml:"max-context-tokens,omitempty"`
 	// Files exceeding this limit will be ignored. This value should be defined
 	// using SI notation, e.g. 20KB.
 	MaxFileSize string `toml:"max-file-size,omitempty"`
+	// Maximum time to wait for the LLM to reply to a message, as a duration (e.g.
+	// `2m`). Empty for no limit.
+	RequestTimeout string `toml:"request-timeout,omitempty"`
 	// Older conversations will be pruned from the database. -1 can be set to
 	// ignore this option.
 	MaxConversationHistory int `toml:"max-conversation-history,omitempty"`
+	// Retries of requests that fail because of rate limits or transient errors.
+	Retry RetryConfig `toml:"retry,omitempty"`
+	// Number of follow-up requests made to continue a reply that was cut off
+	// because it reached the maximum number of tokens. The continuations are
+	// appended to the same message. 0 disables continuing.
+	AutoContinue int `toml:"auto-continue,omitempty"`
+	// Parameters of the generated replies, shared by all providers. Each
+	// provider’s section may override them.
+	Generation GenerationConfig `toml:"generation,omitempty"`
+	// Prices of models, keyed by model name, used to estimate the cost of
+	// conversations. Entries defined by the user are added to (or replace) the
+	// default entries.
+	Prices map[string]ModelPrice `toml:"prices,omitempty"`
 	// Configuration for the `openai` LLM provider.
 	Openai OpenaiConfig `toml:"openai,omitempty"`
 	// Configuration for the `anthropic` LLM provider.
 	Anthropic AnthropicConfig `toml:"anthropic,omitempty"`
+	// Configuration for the `ollama` LLM provider.
+	Ollama OllamaConfig `toml:"ollama,omitempty"`
+	// Named sets of overrides of any of the above values, e.g. a different model
+	// and system message for reviews. A profile is selected using the
+	// `--profile` flag or `DefaultProfile`.
+	Profiles map[string]Config `toml:"profiles,omitempty"`
+	// Profile applied when no profile is selected using the `--profile` flag.
+	DefaultProfile string `toml:"default-profile,omitempty"`
+}
+
+type RedactConfig struct {
+	// Names of the built-in detectors that shouldn’t be used, or `all`.
+	Disable []string `toml:"disable,omitempty"`
+	// Additional regular expressions matching secrets, keyed by name. If a
+	// pattern contains a capture group, only the first group is redacted.
+	Patterns map[string]string `toml:"patterns,omitempty"`
+}
+
+type RetryConfig struct {
+	// Maximum number of attempts for each request, including the first one. 1
+	// disables retries.
+	MaxAttempts int `toml:"max-attempts,omitempty"`
+	// Delay before the first retry, as a duration (e.g. `1s`). It doubles with
+	// each subsequent retry, unless the API asks for a specific delay.
+	BaseDelay string `toml:"base-delay,omitempty"`
+}
+
+// Parameters of the replies generated by the LLM. Unset parameters are left to
+// the provider’s defaults.
+type GenerationConfig struct {
+	// Maximum number of tokens in a reply. 0 to use the default.
+	MaxTokens int `toml:"max-tokens,omitempty"`
+	// Sampling temperature, between 0 and 2 (although Anthropic accepts values up
+	// to 1 only). A pointer, because 0 is a valid value.
+	Temperature *float64 `toml:"temperature,omitempty"`
+	// Nucleus sampling probability, between 0 and 1.
+	TopP *float64 `toml:"top-p,omitempty"`
+	// Sequences that end the reply when generated.
+	Stop []string `toml:"stop,omitempty"`
+}
+
+// Returns a copy of the parameters with any parameters set in the overrides
+// taking precedence.
+func (g GenerationConfig) Merge(overrides GenerationConfig) GenerationConfig {
+	if overrides.MaxTokens != 0 {
+		g.MaxTokens = overrides.MaxTokens
+	}
+
+	if overrides.Temperature != nil {
+		g.Temperature = overrides.Temperature
+	}
+
+	if overrides.TopP != nil {
+		g.TopP = overrides.TopP
+	}
+
+	if overrides.Stop != nil {
+		g.Stop = overrides.Stop
+	}
+
+	return g
+}
+
+// Validates the parameters. The name of the configuration section is used in
+// error messages.
+func (g GenerationConfig) validate(section string) error {
+	var errorBag error
+
+	if g.MaxTokens < 0 {
+		errorBag = errors.Join(errorBag, fmt.Errorf(`The "%s.max-tokens" configuration value may not be negative.`, section))
+	}
+
+	if g.Temperature != nil && (*g.Temperature < 0 || *g.Temperature > 2) {
+		errorBag = errors.Join(errorBag, fmt.Errorf(`The "%s.temperature" configuration value must be between 0 and 2.`, section))
+	}
+
+	if g.TopP != nil && (*g.TopP < 0 || *g.TopP > 1) {
+		errorBag = errors.Join(errorBag, fmt.Errorf(`The "%s.top-p" configuration value must be between 0 and 1.`, section))
+	}
+
+	return errorBag
+}
+
+// Returns the generation parameters for the selected provider: the shared
+// parameters, overridden by the provider’s own.
+func (c *Config) ResolveGeneration() GenerationConfig {
+	if providerGeneration := c.ProviderGeneration(); providerGeneration != nil {
+		return c.Generation.Merge(*providerGeneration)
+	}
+
+	return c.Generation
+}
+
+// Returns the generation parameters defined in the section of the selected
+// provider, or nil if the provider has no section.
+func (c *Config) ProviderGeneration() *GenerationConfig {
+	switch c.Provider {
+	case "openai":
+		return &c.Openai.Generation
+	case "anthropic":
+		return &c.Anthropic.Generation
+	case "ollama":
+		return &c.Ollama.Generation
+	default:
+		return nil
+	}
+}
+
+// Price of a model in US dollars per million tokens.
+type ModelPrice struct {
+	Input  float64 `toml:"input"`
+	Output float64 `toml:"output"`
+	// Input tokens read from the prompt cache.
+	CacheRead float64 `toml:"cache-read,omitempty"`
+	// Input tokens written to the prompt cache.
+	CacheWrite float64 `toml:"cache-write,omitempty"`
 }
 
 type OpenaiConfig struct {
-	ApiKeyEnv string `toml:"api-key-env"`
-	Model     string `toml:"model"`
+	ApiKeyEnv string `toml:"api-key-env,omitempty"`
+	Model     string `toml:"model,omitempty"`
+	// Base URL of an OpenAI-compatible API, e.g. `http://localhost:8080/v1` for
+	// a local model server. Defaults to the official OpenAI API.
+	BaseUrl string `toml:"base-url,omitempty"`
+	// Additional HTTP headers sent with each request.
+	Headers map[string]string `toml:"headers,omitempty"`
+	// Overrides of the shared generation parameters.
+	Generation GenerationConfig `toml:"generation,omitempty"`
 }
 
 type AnthropicConfig struct {
-	ApiKeyEnv string `toml:"api-key-env"`
-	Model     string `toml:"model"`
+	ApiKeyEnv string `toml:"api-key-env,omitempty"`
+	Model     string `toml:"model,omitempty"`
+	// Overrides of the shared generation parameters.
+	Generation GenerationConfig `toml:"generation,omitempty"`
+}
+
+type OllamaConfig struct {
+	// Address of the Ollama server.
+	Host  string `toml:"host,omitempty"`
+	Model string `toml:"model,omitempty"`
+	// How long the model should stay loaded after a request, either as a
+	// duration (e.g. `10m`) or as a number of seconds (`-1` keeps the model loaded
+	// indefinitely). Defaults to the server’s setting.
+	KeepAlive string `toml:"keep-alive,omitempty"`
+	// Size of the context window in tokens. Defaults to the model’s setting.
+	NumCtx int `toml:"num-ctx,omitempty"`
+	// Overrides of the shared generation parameters.
+	Generation GenerationConfig `toml:"generation,omitempty"`
+}
+
+// Short-hand model names supported for OpenAI, mapped to the full names.
+var OpenaiModelAliases = map[string]string{
+	"4o-mini": "gpt-4o-mini",
+	"4o":      "gpt-4o",
+}
+
+// Short-hand model names supported for Anthropic, mapped to the full names.
+var AnthropicModelAliases = map[string]string{
+	"opus":   "claude-3-opus-latest",
+	"sonnet": "claude-3-5-sonnet-latest",
+	"haiku":  "claude-3-5-haiku-latest",
+}
+
+// Prefixes of the names of models available through the official OpenAI API.
+// The names of reasoning models are matched by openaiReasoningModel instead.
+var openaiModelPrefixes = []string{"gpt-", "chatgpt-", "codex-", "ft:"}
+
+// Matches the names of OpenAI’s reasoning models, e.g. o1 or o4-mini.
+var openaiReasoningModel = regexp.MustCompile(`^o[0-9]`)
+
+// Prefixes of the names of Anthropic models.
+var anthropicModelPrefixes = []string{"claude-"}
+
+// Returns true if the model is one of the aliases or its name starts with one
+// of the prefixes.
+func isKnownModel(model string, aliases map[string]string, prefixes []string) bool {
+	if _, ok := aliases[model]; ok {
+		return true
+	}
+
+	return slices.ContainsFunc(prefixes, func(prefix string) bool {
+		return strings.HasPrefix(model, prefix)
+	})
+}
+
+// Lists the aliases in alphabetical order, for error messages.
+func listAliases(aliases map[string]string) string {
+	names := make([]string, 0, len(aliases))
+	for name := range aliases {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	return strings.Join(names, ", ")
 }
 
-// Provides basic validation.
+// Provides basic validation. Besides the values themselves, it checks that the
+// selected provider’s model is known.
 func (c *Config) Validate() error {
-	supportedProviders := []string{"openai", "anthropic", "testing"}
+	supportedProviders := []string{"openai", "anthropic", "ollama", "testing"}
 
 	var errorBag error
 
@@ -57,6 +290,18 @@ func (c *Config) Validate() error {
 		errorBag = errors.Join(errorBag, fmt.Errorf(`%s is not a supported value for the "%s" configuration value.`, c.Provider, "provider"))
 	}
 
+	supportedSources := []string{"filesystem", "git-tracked", "git-staged"}
+
+	if !slices.Contains(supportedSources, c.Source) {
+		errorBag = errors.Join(errorBag, fmt.Errorf(`%s is not a supported value for the "%s" configuration value.`, c.Source, "source"))
+	}
+
+	supportedContextStrategies := []string{"all", "relevant", "tools"}
+
+	if !slices.Contains(supportedContextStrategies, c.ContextStrategy) {
+		errorBag = errors.Join(errorBag, fmt.Errorf(`%s is not a supported value for the "%s" configuration value.`, c.ContextStrategy, "context-strategy"))
+	}
+
 	if c.SystemMessage == "" {
 		errorBag = errors.Join(errorBag, fmt.Errorf(`Missing  "system-message" configuration value.`))
 	}
@@ -65,13 +310,161 @@ func (c *Config) Validate() error {
 		errorBag = errors.Join(errorBag, fmt.Errorf(`Incorrect string representation of bytes for "%s" configuration value.`, "max-file-size"))
 	}
 
+	if c.Openai.BaseUrl != "" {
+		if u, err := url.Parse(c.Openai.BaseUrl); err != nil || u.Scheme == "" || u.Host == "" {
+			errorBag = errors.Join(errorBag, fmt.Errorf(`%s is not a valid URL for the "%s" configuration value.`, c.Openai.BaseUrl, "openai.base-url"))
+		}
+	}
+
+	if u, err := url.Parse(c.Ollama.Host); err != nil || u.Scheme == "" || u.Host == "" {
+		errorBag = errors.Join(errorBag, fmt.Errorf(`%s is not a valid URL for the "%s" configuration value.`, c.Ollama.Host, "ollama.host"))
+	}
+
+	if c.Ollama.KeepAlive != "" {
+		_, durationErr := time.ParseDuration(c.Ollama.KeepAlive)
+		_, numberErr := strconv.Atoi(c.Ollama.KeepAlive)
+		if durationErr != nil && numberErr != nil {
+			errorBag = errors.Join(errorBag, fmt.Errorf(`%s is not a valid duration for the "%s" configuration value.`, c.Ollama.KeepAlive, "ollama.keep-alive"))
+		}
+	}
+
+	if c.RequestTimeout != "" {
+		if timeout, err := time.ParseDuration(c.RequestTimeout); err != nil || timeout <= 0 {
+			errorBag = errors.Join(errorBag, fmt.Errorf(`%s is not a valid duration for the "%s" configuration value.`, c.RequestTimeout, "request-timeout"))
+		}
+	}
+
+	if c.Retry.MaxAttempts < 1 {
+		errorBag = errors.Join(errorBag, fmt.Errorf(`The "%s" configuration value must be at least 1.`, "retry.max-attempts"))
+	}
+
+	if delay, err := time.ParseDuration(c.Retry.BaseDelay); err != nil || delay <= 0 {
+		errorBag = errors.Join(errorBag, fmt.Errorf(`%s is not a valid duration for the "%s" configuration value.`, c.Retry.BaseDelay, "retry.base-delay"))
+	}
+
+	errorBag = errors.Join(
+		errorBag,
+		c.Generation.validate("generation"),
+		c.Openai.Generation.validate("openai.generation"),
+		c.Anthropic.Generation.validate("anthropic.generation"),
+		c.Ollama.Generation.validate("ollama.generation"),
+	)
+
+	if temperature := c.ResolveGeneration().Temperature; c.Provider == "anthropic" && temperature != nil && *temperature > 1 {
+		errorBag = errors.Join(errorBag, fmt.Errorf(`The anthropic provider accepts temperatures between 0 and 1 only.`))
+	}
+
+	for model, price := range c.Prices {
+		if price.Input < 0 || price.Output < 0 || price.CacheRead < 0 || price.CacheWrite < 0 {
+			errorBag = errors.Join(errorBag, fmt.Errorf(`The prices of the %s model in the "%s" configuration value may not be negative.`, model, "prices"))
+		}
+	}
+
+	if c.AutoContinue < 0 {
+		errorBag = errors.Join(errorBag, fmt.Errorf(`The "%s" configuration value may not be negative.`, "auto-continue"))
+	}
+
+	if c.MaxContextLength < 0 {
+		errorBag = errors.Join(errorBag, fmt.Errorf(`The "%s" configuration value may not be negative.`, "max-context-length"))
+	}
+
+	if c.MaxConversationHistory < -1 {
+		errorBag = errors.Join(errorBag, fmt.Errorf(`The "%s" configuration value must be -1 (no pruning) or greater.`, "max-conversation-history"))
+	}
+
+	if c.MaxContextTokens < 0 {
+		errorBag = errors.Join(errorBag, fmt.Errorf(`The "%s" configuration value may not be negative.`, "max-context-tokens"))
+	}
+
+	if c.Ollama.NumCtx < 0 {
+		errorBag = errors.Join(errorBag, fmt.Errorf(`The "%s" configuration value may not be negative.`, "ollama.num-ctx"))
+	}
+
+	errorBag = errors.Join(errorBag, c.validateProvider())
+
+	if _, ok := c.Profiles[c.DefaultProfile]; c.DefaultProfile != "" && !ok {
+		errorBag = errors.Join(errorBag, fmt.Errorf(`%s is not a profile defined in the "%s" configuration value.`, c.DefaultProfile, "profiles"))
+	}
+
+	for name, profile := range c.Profiles {
+		if len(profile.Profiles) > 0 || profile.DefaultProfile != "" {
+			errorBag = errors.Join(errorBag, fmt.Errorf(`The %s profile may not define other profiles.`, name))
+		}
+	}
+
+	return errorBag
+}
+
+// Checks the model of the selected provider. The settings of the other
+// providers aren’t used, so they aren’t checked. API keys are checked only
+// when a request is sent (see llm_provider.CheckApiKey), so that
+// commands that don’t call the API work without them.
+func (c *Config) validateProvider() error {
+	var errorBag error
+
+	switch c.Provider {
+	case "openai":
+		// OpenAI-compatible servers may serve models with any name.
+		if c.Openai.BaseUrl != "" {
+			break
+		}
+
+		if !isKnownModel(c.Openai.Model, OpenaiModelAliases, openaiModelPrefixes) && !openaiReasoningModel.MatchString(c.Openai.Model) {
+			errorBag = errors.Join(errorBag, fmt.Errorf(
+				`%s is not a known OpenAI model for the "%s" configuration value. Use a full model name (e.g. gpt-4o) or one of the aliases: %s.`,
+				c.Openai.Model,
+				"openai.model",
+				listAliases(OpenaiModelAliases),
+			))
+		}
+	case "anthropic":
+		if !isKnownModel(c.Anthropic.Model, AnthropicModelAliases, anthropicModelPrefixes) {
+			errorBag = errors.Join(errorBag, fmt.Errorf(
+				`%s is not a known Anthropic model for the "%s" configuration value. Use a full model name (e.g. claude-3-5-sonnet-latest) or one of the aliases: %s.`,
+				c.Anthropic.Model,
+				"anthropic.model",
+				listAliases(AnthropicModelAliases),
+			))
+		}
+	}
+
 	return errorBag
 }
 
+// Parses the config from a TOML string. Unknown keys (e.g. typos) result in
+// an error, as do invalid values. The errors include the location in the input
+// and the full key.
 func ConfigFromToml(input string) (Config, error) {
 	var cfg Config
 
-	err := toml.Unmarshal([]byte(input), &cfg)
+	decoder := toml.NewDecoder(strings.NewReader(input))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(&cfg)
+
+	var strictErr *toml.StrictMissingError
+	if errors.As(err, &strictErr) {
+		var errorBag error
+
+		for _, unknownErr := range strictErr.Errors {
+			row, column := unknownErr.Position()
+			errorBag = errors.Join(errorBag, fmt.Errorf(`Unknown key "%s" at line %d, column %d.`, strings.Join(unknownErr.Key(), "."), row, column))
+		}
+
+		return cfg, errorBag
+	}
+
+	var decodeErr *toml.DecodeError
+	if errors.As(err, &decodeErr) {
+		row, column := decodeErr.Position()
+		message := strings.TrimPrefix(decodeErr.Error(), "toml: ")
+
+		if key := keyAtLine(input, row); key != "" {
+			return cfg, fmt.Errorf(`Failed to parse "%s" at line %d, column %d: %s.`, key, row, column, message)
+		}
+
+		return cfg, fmt.Errorf(`Failed to parse TOML at line %d, column %d: %s.`, row, column, message)
+	}
 
 	if err != nil {
 		return cfg, errors.New("Failed to parse TOML config string.")
@@ -80,6 +473,39 @@ func ConfigFromToml(input string) (Config, error) {
 	return cfg, nil
 }
 
+// Returns the full key (including the table) defined on the given line
+// (starting at 1) of the TOML input, or an empty string if the line doesn’t
+// define a key. Inline tables and values spanning multiple lines aren’t taken
+// into account.
+func keyAtLine(input string, row int) string {
+	lines := strings.Split(input, "\n")
+	if row < 1 || row > len(lines) {
+		return ""
+	}
+
+	var table string
+
+	for _, line := range lines[:row-1] {
+		line, _, _ = strings.Cut(line, "#")
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			table = strings.TrimSpace(strings.Trim(line, "[]"))
+		}
+	}
+
+	key, _, found := strings.Cut(lines[row-1], "=")
+	key = strings.TrimSpace(key)
+	if !found || key == "" || strings.HasPrefix(key, "[") {
+		return ""
+	}
+
+	if table != "" {
+		key = table + "." + key
+	}
+
+	return key
+}
+
 func (c *Config) ToToml() (string, error) {
 	tomlString, err := toml.Marshal(c)
 
@@ -98,9 +524,22 @@ func DefaultConfig() Config {
 		Provider:               "openai",
 		SystemMessage:          defaultSystemMessage,
 		Exclude:                []string{"pal.toml"},
+		Source:                 "filesystem",
+		ContextStrategy:        "all",
 		MaxContextLength:       100_000,
 		MaxFileSize:            "20KB",
 		MaxConversationHistory: 100,
+		Retry: RetryConfig{
+			MaxAttempts: 3,
+			BaseDelay:   "1s",
+		},
+		Prices: map[string]ModelPrice{
+			"gpt-4o-mini":              {Input: 0.15, Output: 0.60, CacheRead: 0.075},
+			"gpt-4o":                   {Input: 2.50, Output: 10.00, CacheRead: 1.25},
+			"claude-3-5-haiku-latest":  {Input: 0.80, Output: 4.00, CacheRead: 0.08, CacheWrite: 1.00},
+			"claude-3-5-sonnet-latest": {Input: 3.00, Output: 15.00, CacheRead: 0.30, CacheWrite: 3.75},
+			"claude-3-opus-latest":     {Input: 15.00, Output: 75.00, CacheRead: 1.50, CacheWrite: 18.75},
+		},
 		Openai: OpenaiConfig{
 			ApiKeyEnv: "OPENAI_API_KEY",
 			Model:     "gpt-4o-mini",
@@ -109,6 +548,10 @@ func DefaultConfig() Config {
 			ApiKeyEnv: "ANTHROPIC_API_KEY",
 			Model:     "claude-3-5-haiku-latest",
 		},
+		Ollama: OllamaConfig{
+			Host:  "http://localhost:11434",
+			Model: "llama3.2",
+		},
 	}
 }
 
@@ -126,48 +569,172 @@ func DefaultConfig() Config {
 // checks fail.
 func ResolveConfig(overrides *Config) (Config, error) {
 	conf := DefaultConfig()
+	conf.Merge(overrides)
+
+	err := conf.Validate()
 
+	return conf, err
+}
+
+// Applies any non-zero values from the overrides to the configuration.
+func (c *Config) Merge(overrides *Config) {
 	if overrides.SystemMessage != "" {
-		conf.SystemMessage = overrides.SystemMessage
+		c.SystemMessage = overrides.SystemMessage
 	}
 
 	if overrides.Exclude != nil {
-		conf.Exclude = overrides.Exclude
+		c.Exclude = overrides.Exclude
+	}
+
+	if overrides.Include != nil {
+		c.Include = overrides.Include
+	}
+
+	if overrides.Source != "" {
+		c.Source = overrides.Source
+	}
+
+	if overrides.Outline != nil {
+		c.Outline = overrides.Outline
 	}
 
 	if overrides.Provider != "" {
-		conf.Provider = overrides.Provider
+		c.Provider = overrides.Provider
+	}
+
+	if overrides.Redact.Disable != nil {
+		c.Redact.Disable = overrides.Redact.Disable
 	}
 
-	if overrides.MaxContextLength > 0 {
-		conf.MaxContextLength = overrides.MaxContextLength
+	// Patterns are merged, so that both the global and the project config can
+	// define them.
+	for name, pattern := range overrides.Redact.Patterns {
+		if c.Redact.Patterns == nil {
+			c.Redact.Patterns = map[string]string{}
+		}
+		c.Redact.Patterns[name] = pattern
+	}
+
+	if overrides.ContextStrategy != "" {
+		c.ContextStrategy = overrides.ContextStrategy
+	}
+
+	if overrides.MaxContextLength != 0 {
+		c.MaxContextLength = overrides.MaxContextLength
+	}
+
+	if overrides.MaxContextTokens != 0 {
+		c.MaxContextTokens = overrides.MaxContextTokens
 	}
 
 	if overrides.MaxFileSize != "" {
-		conf.MaxFileSize = overrides.MaxFileSize
+		c.MaxFileSize = overrides.MaxFileSize
+	}
+
+	if overrides.RequestTimeout != "" {
+		c.RequestTimeout = overrides.RequestTimeout
+	}
+
+	if overrides.Retry.MaxAttempts != 0 {
+		c.Retry.MaxAttempts = overrides.Retry.MaxAttempts
+	}
+
+	if overrides.Retry.BaseDelay != "" {
+		c.Retry.BaseDelay = overrides.Retry.BaseDelay
+	}
+
+	if overrides.AutoContinue != 0 {
+		c.AutoContinue = overrides.AutoContinue
+	}
+
+	c.Generation = c.Generation.Merge(overrides.Generation)
+
+	// Prices are merged, so that the user doesn’t have to repeat the default
+	// entries in order to add a model.
+	for model, price := range overrides.Prices {
+		c.Prices[model] = price
 	}
 
 	if overrides.Openai.ApiKeyEnv != "" {
-		conf.Openai.ApiKeyEnv = overrides.Openai.ApiKeyEnv
+		c.Openai.ApiKeyEnv = overrides.Openai.ApiKeyEnv
 	}
 
 	if overrides.Openai.Model != "" {
-		conf.Openai.Model = overrides.Openai.Model
+		c.Openai.Model = overrides.Openai.Model
 	}
 
+	if overrides.Openai.BaseUrl != "" {
+		c.Openai.BaseUrl = overrides.Openai.BaseUrl
+	}
+
+	if overrides.Openai.Headers != nil {
+		c.Openai.Headers = overrides.Openai.Headers
+	}
+
+	c.Openai.Generation = c.Openai.Generation.Merge(overrides.Openai.Generation)
+
 	if overrides.Anthropic.ApiKeyEnv != "" {
-		conf.Anthropic.ApiKeyEnv = overrides.Anthropic.ApiKeyEnv
+		c.Anthropic.ApiKeyEnv = overrides.Anthropic.ApiKeyEnv
 	}
 
 	if overrides.Anthropic.Model != "" {
-		conf.Anthropic.Model = overrides.Anthropic.Model
+		c.Anthropic.Model = overrides.Anthropic.Model
 	}
 
+	c.Anthropic.Generation = c.Anthropic.Generation.Merge(overrides.Anthropic.Generation)
+
+	if overrides.Ollama.Host != "" {
+		c.Ollama.Host = overrides.Ollama.Host
+	}
+
+	if overrides.Ollama.Model != "" {
+		c.Ollama.Model = overrides.Ollama.Model
+	}
+
+	if overrides.Ollama.KeepAlive != "" {
+		c.Ollama.KeepAlive = overrides.Ollama.KeepAlive
+	}
+
+	if overrides.Ollama.NumCtx != 0 {
+		c.Ollama.NumCtx = overrides.Ollama.NumCtx
+	}
+
+	c.Ollama.Generation = c.Ollama.Generation.Merge(overrides.Ollama.Generation)
+
 	if overrides.MaxConversationHistory != 0 {
-		conf.MaxConversationHistory = overrides.MaxConversationHistory
+		c.MaxConversationHistory = overrides.MaxConversationHistory
 	}
 
-	err := conf.Validate()
+	// Profiles are merged, so that both the global and the project config can
+	// define them.
+	for name, profile := range overrides.Profiles {
+		if c.Profiles == nil {
+			c.Profiles = map[string]Config{}
+		}
+		c.Profiles[name] = profile
+	}
 
-	return conf, err
+	if overrides.DefaultProfile != "" {
+		c.DefaultProfile = overrides.DefaultProfile
+	}
+}
+
+// Returns the values of the profile with the given name or, if the name is
+// empty, of the default profile. Returns an empty name if no profile is
+// selected.
+func (c *Config) SelectProfile(name string) (string, Config, error) {
+	if name == "" {
+		name = c.DefaultProfile
+	}
+
+	if name == "" {
+		return "", Config{}, nil
+	}
+
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return name, Config{}, fmt.Errorf(`%s is not a profile defined in the "%s" configuration value.`, name, "profiles")
+	}
+
+	return name, profile, nil
 }
@@ -3,6 +3,7 @@ package config
 import (
 	"github.com/malinowskip/pal/testutil"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -16,6 +17,24 @@ func TestParseConfig(t *testing.T) {
 	testutil.AssertDeepEquals(t, conf.Exclude, []string{"some-file.md"})
 }
 
+func TestParseConfigErrors(t *testing.T) {
+	tests := map[string]string{
+		"provider = \"openai\"\nmax-file-sise = \"10KB\"\n": `Unknown key "max-file-sise" at line 2, column 1.`,
+		"[profiles.quick]\nmodle = \"haiku\"\n":             `Unknown key "profiles.quick.modle" at line 2, column 1.`,
+		"[openai]\nmodel = 4\n":                             `Failed to parse "openai.model" at line 2, column 9`,
+		"max-context-length = \"big\"\n":                    `Failed to parse "max-context-length" at line 1, column 22`,
+		"provider = \"openai\"\nexclude = [\"dist\"\n":      `Failed to parse TOML at line 3, column 1`,
+	}
+
+	for input, expected := range tests {
+		_, err := ConfigFromToml(input)
+
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Parsing %q should result in an error including %q (error: %v).", input, expected, err)
+		}
+	}
+}
+
 func TestEncodeConfig(t *testing.T) {
 	conf := Config{
 		Exclude: []string{"foo.md"},
@@ -34,6 +53,7 @@ func TestDefaultConfig(t *testing.T) {
 
 	testutil.AssertDeepEquals(t, conf.Exclude, []string{"pal.toml"})
 	testutil.AssertDeepEquals(t, conf.Provider, "openai")
+	testutil.AssertDeepEquals(t, conf.ContextStrategy, "all")
 	testutil.AssertDeepEquals(t, conf.MaxContextLength, 100_000)
 	testutil.AssertDeepEquals(t, conf.MaxFileSize, "20KB")
 	testutil.AssertDeepEquals(t, conf.MaxConversationHistory, 100)
@@ -41,6 +61,8 @@ func TestDefaultConfig(t *testing.T) {
 	testutil.AssertDeepEquals(t, conf.Openai.Model, "gpt-4o-mini")
 	testutil.AssertDeepEquals(t, conf.Anthropic.Model, "claude-3-5-haiku-latest")
 	testutil.AssertDeepEquals(t, conf.Anthropic.ApiKeyEnv, "ANTHROPIC_API_KEY")
+	testutil.AssertDeepEquals(t, conf.Ollama.Host, "http://localhost:11434")
+	testutil.AssertDeepEquals(t, conf.Ollama.Model, "llama3.2")
 
 	t.Run("System message", func(t *testing.T) {
 		if len(conf.SystemMessage) == 0 {
@@ -67,11 +89,63 @@ func TestResolveConfig(t *testing.T) {
 
 	testOverride(t, "SystemMessage", "override")
 	testOverride(t, "Exclude", []string{"hello"})
+	testOverride(t, "Include", []string{"services/billing/**"})
+	testOverride(t, "Source", "git-tracked")
+	testOverride(t, "Outline", []string{"internal/**/*.go"})
+	testOverride(t, "Redact", RedactConfig{Disable: []string{"high-entropy"}, Patterns: map[string]string{"token": "tok_[a-z]+"}})
 	testOverride(t, "Provider", "hello")
 	testOverride(t, "Openai", OpenaiConfig{ApiKeyEnv: "hello", Model: "hello"})
+	testOverride(t, "Openai", OpenaiConfig{
+		ApiKeyEnv: "hello",
+		Model:     "hello",
+		BaseUrl:   "http://localhost:8080/v1",
+		Headers:   map[string]string{"X-Hello": "world"},
+	})
 	testOverride(t, "Anthropic", AnthropicConfig{ApiKeyEnv: "hello", Model: "hello"})
+	testOverride(t, "Ollama", OllamaConfig{
+		Host:      "http://ollama:11434",
+		Model:     "hello",
+		KeepAlive: "10m",
+		NumCtx:    8192,
+	})
+	testOverride(t, "ContextStrategy", "relevant")
+	testOverride(t, "MaxContextTokens", 5000)
 	testOverride(t, "MaxFileSize", "5KB")
+	testOverride(t, "RequestTimeout", "30s")
+	testOverride(t, "Retry", RetryConfig{MaxAttempts: 5, BaseDelay: "500ms"})
 	testOverride(t, "MaxConversationHistory", 5)
+	testOverride(t, "AutoContinue", 2)
+	testOverride(t, "Generation", GenerationConfig{MaxTokens: 2000, Temperature: ptr(0.0), Stop: []string{"END"}})
+	t.Run("Merges prices with the defaults", func(t *testing.T) {
+		overrides := Config{
+			Prices: map[string]ModelPrice{
+				"gpt-4o-mini": {Input: 1, Output: 2},
+				"local-model": {Input: 0.5, Output: 0.5},
+			},
+		}
+
+		FinalConfig, _ := ResolveConfig(&overrides)
+
+		testutil.AssertDeepEquals(t, FinalConfig.Prices["gpt-4o-mini"], ModelPrice{Input: 1, Output: 2})
+		testutil.AssertDeepEquals(t, FinalConfig.Prices["local-model"], ModelPrice{Input: 0.5, Output: 0.5})
+		testutil.AssertDeepEquals(t, FinalConfig.Prices["gpt-4o"], DefaultConfig().Prices["gpt-4o"])
+	})
+
+	t.Run("Provider’s generation parameters override the shared ones", func(t *testing.T) {
+		overrides := Config{
+			Provider:   "anthropic",
+			Generation: GenerationConfig{MaxTokens: 2000, Temperature: ptr(0.2)},
+			Anthropic:  AnthropicConfig{Generation: GenerationConfig{MaxTokens: 8000}},
+			Openai:     OpenaiConfig{Generation: GenerationConfig{TopP: ptr(0.9)}},
+		}
+
+		FinalConfig, err := ResolveConfig(&overrides)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testutil.AssertDeepEquals(t, FinalConfig.ResolveGeneration(), GenerationConfig{MaxTokens: 8000, Temperature: ptr(0.2)})
+	})
 
 	t.Run("Returns default config if overrides are empty.", func(t *testing.T) {
 		overrides := Config{}
@@ -83,6 +157,9 @@ func TestResolveConfig(t *testing.T) {
 }
 
 func TestConfigValidation(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	t.Setenv("ANTHROPIC_API_KEY", "")
+
 	t.Run("Default config is valid", func(t *testing.T) {
 		conf := DefaultConfig()
 		err := conf.Validate()
@@ -104,6 +181,30 @@ func TestConfigValidation(t *testing.T) {
 		}
 	})
 
+	t.Run("Incorrect context strategy", func(t *testing.T) {
+		values := []string{"", "some-unsupported-strategy"}
+
+		for _, value := range values {
+			conf := DefaultConfig()
+			conf.ContextStrategy = value
+			if conf.Validate() == nil {
+				t.Errorf("%s is not a valid value for the %s field.", value, "ContextStrategy")
+			}
+		}
+	})
+
+	t.Run("Incorrect source", func(t *testing.T) {
+		values := []string{"", "git"}
+
+		for _, value := range values {
+			conf := DefaultConfig()
+			conf.Source = value
+			if conf.Validate() == nil {
+				t.Errorf("%s is not a valid value for the %s field.", value, "Source")
+			}
+		}
+	})
+
 	t.Run("Missing system message", func(t *testing.T) {
 		conf := DefaultConfig()
 		conf.SystemMessage = ""
@@ -112,6 +213,166 @@ func TestConfigValidation(t *testing.T) {
 		}
 	})
 
+	t.Run("Incorrect OpenAI base URL", func(t *testing.T) {
+		conf := DefaultConfig()
+		conf.Openai.BaseUrl = "localhost"
+		if conf.Validate() == nil {
+			t.Errorf("%s is not a valid value for the %s field.", conf.Openai.BaseUrl, "Openai.BaseUrl")
+		}
+	})
+
+	t.Run("Ollama provider", func(t *testing.T) {
+		conf := DefaultConfig()
+		conf.Provider = "ollama"
+		conf.Ollama.KeepAlive = "-1"
+		if err := conf.Validate(); err != nil {
+			t.Errorf("The ollama provider should be supported: %v", err)
+		}
+	})
+
+	t.Run("Incorrect Ollama settings", func(t *testing.T) {
+		invalid := []OllamaConfig{
+			{Host: "localhost", Model: "llama3.2"},
+			{Host: "http://localhost:11434", Model: "llama3.2", KeepAlive: "forever"},
+			{Host: "http://localhost:11434", Model: "llama3.2", NumCtx: -1},
+		}
+
+		for _, ollamaConf := range invalid {
+			conf := DefaultConfig()
+			conf.Ollama = ollamaConf
+			if conf.Validate() == nil {
+				t.Errorf("%v is not a valid value for the %s field.", ollamaConf, "Ollama")
+			}
+		}
+	})
+
+	t.Run("Incorrect request timeout", func(t *testing.T) {
+		values := []string{"soon", "0s", "-1m", "30"}
+
+		for _, value := range values {
+			conf := DefaultConfig()
+			conf.RequestTimeout = value
+			if conf.Validate() == nil {
+				t.Errorf("%s is not a valid value for the %s field.", value, "RequestTimeout")
+			}
+		}
+	})
+
+	t.Run("Incorrect retry settings", func(t *testing.T) {
+		invalid := []RetryConfig{
+			{MaxAttempts: 0, BaseDelay: "1s"},
+			{MaxAttempts: 3, BaseDelay: "soon"},
+			{MaxAttempts: 3, BaseDelay: "0s"},
+		}
+
+		for _, retryConf := range invalid {
+			conf := DefaultConfig()
+			conf.Retry = retryConf
+			if conf.Validate() == nil {
+				t.Errorf("%v is not a valid value for the %s field.", retryConf, "Retry")
+			}
+		}
+	})
+
+	t.Run("Incorrect generation parameters", func(t *testing.T) {
+		invalid := []GenerationConfig{
+			{MaxTokens: -1},
+			{Temperature: ptr(-0.5)},
+			{Temperature: ptr(2.5)},
+			{TopP: ptr(1.5)},
+		}
+
+		for _, generation := range invalid {
+			conf := DefaultConfig()
+			conf.Ollama.Generation = generation
+			if conf.Validate() == nil {
+				t.Errorf("%v is not a valid value for the %s field.", generation, "Ollama.Generation")
+			}
+		}
+
+		conf := DefaultConfig()
+		conf.Provider = "anthropic"
+		conf.Generation.Temperature = ptr(1.5)
+		if conf.Validate() == nil {
+			t.Errorf("%v is not a valid temperature for the anthropic provider.", *conf.Generation.Temperature)
+		}
+	})
+
+	t.Run("Negative prices", func(t *testing.T) {
+		conf := DefaultConfig()
+		conf.Prices["local-model"] = ModelPrice{Input: -1, Output: 1}
+		if conf.Validate() == nil {
+			t.Errorf("%v is not a valid value for the %s field.", conf.Prices["local-model"], "Prices")
+		}
+	})
+
+	t.Run("Unknown models", func(t *testing.T) {
+		conf := DefaultConfig()
+		conf.Openai.Model = "gtp-4o"
+		if conf.Validate() == nil {
+			t.Errorf("%s is not a valid value for the %s field.", conf.Openai.Model, "Openai.Model")
+		}
+
+		// Models of OpenAI-compatible servers may have any name.
+		conf.Openai.BaseUrl = "http://localhost:8080/v1"
+		if err := conf.Validate(); err != nil {
+			t.Error(err)
+		}
+
+		// Aliases are valid.
+		conf = DefaultConfig()
+		conf.Openai.Model = "4o"
+		if err := conf.Validate(); err != nil {
+			t.Error(err)
+		}
+
+		// So are models that aren’t aliased, if their names match those of
+		// OpenAI’s models.
+		for _, model := range []string{"o4-mini", "codex-mini-latest", "gpt-4.1"} {
+			conf.Openai.Model = model
+			if err := conf.Validate(); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+
+	t.Run("API key is not validated", func(t *testing.T) {
+		// API keys are only required to send requests, so they are checked by
+		// llm_provider.CheckApiKey instead.
+		conf := DefaultConfig()
+		conf.Provider = "anthropic"
+		if err := conf.Validate(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("Negative limits", func(t *testing.T) {
+		conf := DefaultConfig()
+		conf.MaxContextLength = -1
+		conf.MaxConversationHistory = -2
+
+		err := conf.Validate()
+		if err == nil || !strings.Contains(err.Error(), `"max-context-length"`) || !strings.Contains(err.Error(), `"max-conversation-history"`) {
+			t.Errorf("Negative limits should result in errors (error: %v).", err)
+		}
+	})
+
+	t.Run("Negative AutoContinue", func(t *testing.T) {
+		conf := DefaultConfig()
+		conf.AutoContinue = -1
+		if conf.Validate() == nil {
+			t.Errorf("%d is not a valid value for the %s field.", conf.AutoContinue, "AutoContinue")
+		}
+	})
+
+	t.Run("Negative MaxContextTokens", func(t *testing.T) {
+		conf := DefaultConfig()
+		conf.MaxContextTokens = -1
+		if conf.Validate() == nil {
+			t.Errorf("%d is not a valid value for the %s field.", conf.MaxContextTokens, "MaxContextTokens")
+		}
+	})
+
 	t.Run("Incorrect MaxFileSize notation", func(t *testing.T) {
 		conf := DefaultConfig()
 		conf.MaxFileSize = "10XYZ"
@@ -120,3 +381,151 @@ func TestConfigValidation(t *testing.T) {
 		}
 	})
 }
+
+func ptr[T any](value T) *T {
+	return &value
+}
+
+func TestResolveLayers(t *testing.T) {
+	layers := []Layer{
+		{Name: "global", Config: Config{Provider: "anthropic", MaxFileSize: "50KB"}},
+		{Name: "project", Config: Config{Provider: "ollama", Exclude: []string{}}},
+		{Name: "flags", Config: Config{Ollama: OllamaConfig{Generation: GenerationConfig{Temperature: ptr(0.5)}}}},
+	}
+
+	conf, sources, err := ResolveLayers(layers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.AssertDeepEquals(t, conf.Provider, "ollama")
+	testutil.AssertDeepEquals(t, conf.MaxFileSize, "50KB")
+	testutil.AssertDeepEquals(t, conf.Exclude, []string{})
+
+	testutil.AssertDeepEquals(t, sources["provider"], "project")
+	testutil.AssertDeepEquals(t, sources["max-file-size"], "global")
+	testutil.AssertDeepEquals(t, sources["exclude"], "project")
+	testutil.AssertDeepEquals(t, sources["ollama.generation.temperature"], "flags")
+	testutil.AssertDeepEquals(t, sources["ollama.model"], DefaultLayerName)
+	testutil.AssertDeepEquals(t, sources["prices.claude-3-5-haiku-latest.input"], DefaultLayerName)
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	env := map[string]string{
+		"PAL_PROVIDER":                  "ollama",
+		"PAL_MAX_CONTEXT_TOKENS":        "8000",
+		"PAL_EXCLUDE":                   "dist/,*.lock",
+		"PAL_OLLAMA_MODEL":              "qwen2.5-coder",
+		"PAL_GENERATION_TEMPERATURE":    "0.2",
+		"PAL_OPENAI_GENERATION_TOP_P":   "0.9",
+		"PAL_UNRELATED_SETTING_IGNORED": "yes",
+	}
+
+	lookupEnv := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	conf, err := ConfigFromEnv(lookupEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.AssertDeepEquals(t, conf, Config{
+		Provider:         "ollama",
+		MaxContextTokens: 8000,
+		Exclude:          []string{"dist/", "*.lock"},
+		Generation:       GenerationConfig{Temperature: ptr(0.2)},
+		Openai:           OpenaiConfig{Generation: GenerationConfig{TopP: ptr(0.9)}},
+		Ollama:           OllamaConfig{Model: "qwen2.5-coder"},
+	})
+
+	t.Run("Invalid values", func(t *testing.T) {
+		env = map[string]string{"PAL_AUTO_CONTINUE": "twice", "PAL_PRICES": "free"}
+
+		_, err := ConfigFromEnv(lookupEnv)
+
+		if err == nil || !strings.Contains(err.Error(), "PAL_AUTO_CONTINUE") || !strings.Contains(err.Error(), "PAL_PRICES") {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+}
+
+func TestProfiles(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	t.Setenv("ANTHROPIC_API_KEY", "test-key")
+
+	conf, err := ConfigFromToml(`
+		default-profile = "quick"
+
+		[profiles.quick.anthropic]
+		model = "claude-3-5-haiku-latest"
+
+		[profiles.review]
+		provider = "anthropic"
+		system-message = "Review the code."
+
+		[profiles.review.anthropic]
+		model = "claude-3-5-sonnet-latest"
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolved, err := ResolveConfig(&conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Selects the default profile", func(t *testing.T) {
+		name, profile, err := resolved.SelectProfile("")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testutil.AssertDeepEquals(t, name, "quick")
+		testutil.AssertDeepEquals(t, profile.Anthropic.Model, "claude-3-5-haiku-latest")
+	})
+
+	t.Run("Selects the named profile", func(t *testing.T) {
+		name, profile, err := resolved.SelectProfile("review")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testutil.AssertDeepEquals(t, name, "review")
+
+		resolved.Merge(&profile)
+		testutil.AssertDeepEquals(t, resolved.Provider, "anthropic")
+		testutil.AssertDeepEquals(t, resolved.SystemMessage, "Review the code.")
+		testutil.AssertDeepEquals(t, resolved.Anthropic.Model, "claude-3-5-sonnet-latest")
+	})
+
+	t.Run("Undefined profile", func(t *testing.T) {
+		if _, _, err := resolved.SelectProfile("deep"); err == nil {
+			t.Error("Selecting an undefined profile should result in an error.")
+		}
+	})
+
+	t.Run("Profiles are merged", func(t *testing.T) {
+		merged := DefaultConfig()
+		merged.Merge(&Config{Profiles: map[string]Config{"quick": {Provider: "openai"}}})
+		merged.Merge(&Config{Profiles: map[string]Config{"review": {Provider: "anthropic"}}})
+
+		testutil.AssertDeepEquals(t, len(merged.Profiles), 2)
+	})
+
+	t.Run("Invalid profiles", func(t *testing.T) {
+		conf := DefaultConfig()
+		conf.DefaultProfile = "missing"
+		if conf.Validate() == nil {
+			t.Errorf("%s is not a valid value for the %s field.", conf.DefaultProfile, "DefaultProfile")
+		}
+
+		conf = DefaultConfig()
+		conf.Profiles = map[string]Config{"outer": {Profiles: map[string]Config{"inner": {}}}}
+		if conf.Validate() == nil {
+			t.Error("Profiles may not be nested.")
+		}
+	})
+}
@@ -0,0 +1,218 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	toml "github.com/pelletier/go-toml/v2"
+)
+
+// A source of configuration values, such as a config file or the environment.
+type Layer struct {
+	// Describes the source in the output of `pal config --sources`.
+	Name   string
+	Config Config
+}
+
+// Name of the layer of built-in default values, which all other layers
+// override.
+const DefaultLayerName = "default"
+
+// Maps each configuration key (e.g. `openai.model`) to the name of the layer
+// that its resolved value came from.
+type Sources map[string]string
+
+// Merges the layers on top of the default configuration, in order, so that the
+// values of each layer take precedence over those of the previous ones. A value
+// is considered set in a layer if it isn’t a zero value, as in ResolveConfig.
+//
+// Returns the final, resolved configuration along with the source of each
+// value, or an error if any validation checks fail.
+func ResolveLayers(layers []Layer) (Config, Sources, error) {
+	conf := DefaultConfig()
+
+	values, err := Flatten(conf)
+	if err != nil {
+		return conf, nil, err
+	}
+
+	sources := Sources{}
+	for key := range values {
+		sources[key] = DefaultLayerName
+	}
+
+	for _, layer := range layers {
+		layerValues, err := Flatten(layer.Config)
+		if err != nil {
+			return conf, nil, err
+		}
+
+		conf.Merge(&layer.Config)
+
+		mergedValues, err := Flatten(conf)
+		if err != nil {
+			return conf, nil, err
+		}
+
+		// Besides the values set in the layer, values that changed during the merge
+		// are attributed to the layer, including those that were cleared (e.g. an
+		// empty `exclude` list).
+		for key, value := range mergedValues {
+			if !reflect.DeepEqual(value, values[key]) || !isZeroValue(layerValues[key]) {
+				sources[key] = layer.Name
+			}
+		}
+
+		for key := range values {
+			if _, ok := mergedValues[key]; !ok {
+				sources[key] = layer.Name
+			}
+		}
+
+		values = mergedValues
+	}
+
+	return conf, sources, conf.Validate()
+}
+
+// Returns the configuration values keyed by their full TOML keys, e.g.
+// `openai.model`. Keys of tables such as `prices` are quoted if necessary.
+func Flatten(conf Config) (map[string]any, error) {
+	encoded, err := toml.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree map[string]any
+	if err = toml.Unmarshal(encoded, &tree); err != nil {
+		return nil, err
+	}
+
+	values := map[string]any{}
+	flattenTable(values, "", tree)
+
+	return values, nil
+}
+
+var bareKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func flattenTable(values map[string]any, prefix string, table map[string]any) {
+	for key, value := range table {
+		if !bareKeyPattern.MatchString(key) {
+			key = strconv.Quote(key)
+		}
+
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		if nested, ok := value.(map[string]any); ok {
+			flattenTable(values, key, nested)
+		} else {
+			values[key] = value
+		}
+	}
+}
+
+// Returns the keys of the flattened values in alphabetical order.
+func SortedKeys(values map[string]any) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+func isZeroValue(value any) bool {
+	if value == nil {
+		return true
+	}
+
+	if list, ok := value.([]any); ok {
+		return len(list) == 0
+	}
+
+	return reflect.ValueOf(value).IsZero()
+}
+
+// Prefix of the environment variables that override configuration values.
+const envPrefix = "PAL"
+
+// Reads configuration values from environment variables named after the keys,
+// e.g. `PAL_OPENAI_MODEL` for `openai.model`. Lists are separated by commas.
+// Tables such as `prices` can’t be set this way.
+func ConfigFromEnv(lookupEnv func(name string) (string, bool)) (Config, error) {
+	var conf Config
+
+	err := setFromEnv(reflect.ValueOf(&conf).Elem(), envPrefix, lookupEnv)
+
+	return conf, err
+}
+
+func setFromEnv(v reflect.Value, prefix string, lookupEnv func(name string) (string, bool)) error {
+	var errorBag error
+
+	for i := 0; i < v.NumField(); i++ {
+		key, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("toml"), ",")
+		if key == "" {
+			continue
+		}
+
+		name := prefix + "_" + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+		field := v.Field(i)
+
+		if field.Kind() == reflect.Struct {
+			errorBag = errors.Join(errorBag, setFromEnv(field, name, lookupEnv))
+			continue
+		}
+
+		value, ok := lookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		if err := setFromString(field, value); err != nil {
+			errorBag = errors.Join(errorBag, fmt.Errorf(`%s is not a valid value for the %s environment variable.`, value, name))
+		}
+	}
+
+	return errorBag
+}
+
+// Parses the string according to the type of the field.
+func setFromString(field reflect.Value, value string) error {
+	switch {
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+	case field.Kind() == reflect.Int:
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(number))
+	case field.Kind() == reflect.Pointer && field.Type().Elem().Kind() == reflect.Float64:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(&number))
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+		list := []string{}
+		if value != "" {
+			list = strings.Split(value, ",")
+		}
+		field.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("Unsupported type.")
+	}
+
+	return nil
+}
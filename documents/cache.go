@@ -0,0 +1,226 @@
+package documents
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/malinowskip/pal/constants"
+	"github.com/malinowskip/pal/tokenizer"
+)
+
+// Name of the cache file within the app directory of a project.
+const cacheFileName = "documents-cache.json"
+
+// Version of the format of the cache file. Files in a different format are
+// ignored.
+const cacheVersion = 1
+
+// Information about the files of a project, kept between runs in the app
+// directory. It records whether each file is a text file, so that files known
+// not to be text files aren’t read again, and the token counts of documents.
+// Text files are still read on every run, since their content is needed. The
+// information about a file is discarded once its size or modification time
+// changes.
+//
+// A nil *Cache is valid and caches nothing. A Cache is safe for concurrent use.
+type Cache struct {
+	projectPath string
+	mu          sync.Mutex
+	entries     map[string]*cacheEntry
+	// Whether any entries changed since the cache was opened.
+	changed bool
+}
+
+type cacheFile struct {
+	Version int                    `json:"version"`
+	Entries map[string]*cacheEntry `json:"entries"`
+}
+
+// Cached information about a file, keyed by its relative path within the
+// project directory.
+type cacheEntry struct {
+	Size int64 `json:"size"`
+	// Modification time in nanoseconds since the Unix epoch.
+	ModTime int64 `json:"mod-time"`
+	// Whether the file is a UTF-8 text file.
+	IsText bool `json:"is-text"`
+	// SHA-256 hash of the content whose tokens were counted.
+	Hash string `json:"hash,omitempty"`
+	// Number of tokens in the content, keyed by the name of the tokenizer.
+	Tokens map[string]int `json:"tokens,omitempty"`
+}
+
+// Opens the cache of the project. A missing or unreadable cache file results
+// in an empty cache; it’s only created when the cache is saved.
+func OpenCache(projectPath string) *Cache {
+	c := &Cache{
+		projectPath: projectPath,
+		entries:     map[string]*cacheEntry{},
+	}
+
+	content, err := os.ReadFile(filepath.Join(projectPath, constants.AppDir, cacheFileName))
+	if err != nil {
+		return c
+	}
+
+	var file cacheFile
+	if err = json.Unmarshal(content, &file); err == nil && file.Version == cacheVersion && file.Entries != nil {
+		c.entries = file.Entries
+	}
+
+	return c
+}
+
+// Returns the cached entry of the file, or nil if the file changed since it
+// was cached. Must be called with the mutex locked.
+func (c *Cache) entry(relPath string, info fs.FileInfo) *cacheEntry {
+	entry := c.entries[relPath]
+	if entry == nil || entry.Size != info.Size() || entry.ModTime != info.ModTime().UnixNano() {
+		return nil
+	}
+
+	return entry
+}
+
+// Returns the cached verdict on whether the file is a UTF-8 text file. The
+// second value is false if the verdict isn’t cached.
+func (c *Cache) isText(relPath string, info fs.FileInfo) (bool, bool) {
+	if c == nil {
+		return false, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry := c.entry(relPath, info); entry != nil {
+		return entry.IsText, true
+	}
+
+	return false, false
+}
+
+// Caches the verdict on whether the file is a UTF-8 text file.
+func (c *Cache) setText(relPath string, info fs.FileInfo, isText bool) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry := c.entry(relPath, info); entry != nil && entry.IsText == isText {
+		return
+	}
+
+	c.entries[relPath] = &cacheEntry{
+		Size:    info.Size(),
+		ModTime: info.ModTime().UnixNano(),
+		IsText:  isText,
+	}
+	c.changed = true
+}
+
+// Returns the number of tokens in the content of the document at the given
+// path. Counts are cached for one version of the content of each file (the
+// first one counted since the file changed), as long as it stays the same.
+func (c *Cache) CountTokens(relPath string, content string, t tokenizer.Tokenizer) int {
+	if c == nil {
+		return t.CountTokens(content)
+	}
+
+	sum := sha256.Sum256([]byte(content))
+	hash := hex.EncodeToString(sum[:])
+
+	c.mu.Lock()
+	entry := c.entries[relPath]
+	if entry != nil && entry.Hash == hash {
+		if tokens, ok := entry.Tokens[t.Name()]; ok {
+			c.mu.Unlock()
+			return tokens
+		}
+	}
+	c.mu.Unlock()
+
+	tokens := t.CountTokens(content)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Only the counts of text files loaded as documents are cached.
+	if entry = c.entries[relPath]; entry == nil || !entry.IsText {
+		return tokens
+	}
+
+	if entry.Hash != hash {
+		if entry.Hash != "" {
+			return tokens
+		}
+		entry.Hash = hash
+		entry.Tokens = map[string]int{}
+	}
+
+	entry.Tokens[t.Name()] = tokens
+	c.changed = true
+
+	return tokens
+}
+
+// Writes the cache to the app directory of the project, leaving out the
+// entries of files that no longer exist. Does nothing if nothing changed.
+func (c *Cache) Save() error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.changed {
+		return nil
+	}
+
+	for relPath := range c.entries {
+		if _, err := os.Lstat(filepath.Join(c.projectPath, relPath)); os.IsNotExist(err) {
+			delete(c.entries, relPath)
+		}
+	}
+
+	content, err := json.Marshal(cacheFile{Version: cacheVersion, Entries: c.entries})
+	if err != nil {
+		return err
+	}
+
+	dirPath := filepath.Join(c.projectPath, constants.AppDir)
+	if err = os.MkdirAll(dirPath, 0755); err != nil {
+		return err
+	}
+
+	// The file is replaced atomically, so that concurrent runs never read a
+	// partially written cache.
+	tempFile, err := os.CreateTemp(dirPath, cacheFileName+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+
+	if _, err = tempFile.Write(content); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err = tempFile.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tempFile.Name(), filepath.Join(dirPath, cacheFileName)); err != nil {
+		return err
+	}
+
+	c.changed = false
+
+	return nil
+}
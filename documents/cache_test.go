@@ -0,0 +1,125 @@
+package documents
+
+import (
+	"github.com/malinowskip/pal/constants"
+	"github.com/malinowskip/pal/testutil"
+	"github.com/malinowskip/pal/util"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Counts the characters of the text, keeping track of the number of calls.
+type countingTokenizer struct {
+	calls int
+}
+
+func (t *countingTokenizer) CountTokens(text string) int {
+	t.calls++
+	return len(text)
+}
+
+func (t *countingTokenizer) Name() string {
+	return "counting"
+}
+
+func TestCache(t *testing.T) {
+	projectPath := t.TempDir()
+
+	binaryPath := filepath.Join(projectPath, "image.bin")
+	textPath := filepath.Join(projectPath, "main.go")
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	writeFile := func(path string, content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeFile(binaryPath, "\xff\xfe\xfd")
+	writeFile(textPath, "package main\n")
+
+	load := func(cache *Cache) ([]Document, []SkippedEntry) {
+		t.Helper()
+		docs, skipped, err := LoadDocuments(projectPath, SourceFilesystem, nil, nil, Selection{}, 10000, cache)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = cache.Save(); err != nil {
+			t.Fatal(err)
+		}
+		return docs, skipped
+	}
+
+	load(OpenCache(projectPath))
+
+	if !util.FileExists(filepath.Join(projectPath, constants.AppDir, cacheFileName)) {
+		t.Fatal("The cache should be saved in the app directory.")
+	}
+
+	t.Run("Files that aren’t text files aren’t read again", func(t *testing.T) {
+		// The same size and modification time, so the file is assumed unchanged.
+		writeFile(binaryPath, "abc")
+
+		_, skipped := load(OpenCache(projectPath))
+		testutil.AssertDeepEquals(t, FindSkippedEntry(skipped, "image.bin").Reason, SkipReasonNotText)
+
+		// Once the modification time changes, the file is read again.
+		modTime = modTime.Add(time.Second)
+		writeFile(binaryPath, "abc")
+
+		docs, _ := load(OpenCache(projectPath))
+		testutil.AssertContains(t, docs, func(doc Document) bool {
+			return doc.Path == "image.bin"
+		})
+	})
+
+	t.Run("Token counts are reused while the content stays the same", func(t *testing.T) {
+		tokenizer := &countingTokenizer{}
+
+		cache := OpenCache(projectPath)
+		load(cache)
+		testutil.AssertDeepEquals(t, cache.CountTokens("main.go", "package main\n", tokenizer), 13)
+		if err := cache.Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		cache = OpenCache(projectPath)
+		testutil.AssertDeepEquals(t, cache.CountTokens("main.go", "package main\n", tokenizer), 13)
+		testutil.AssertDeepEquals(t, tokenizer.calls, 1)
+
+		// Different content, e.g. an outline, is counted again.
+		testutil.AssertDeepEquals(t, cache.CountTokens("main.go", "package", tokenizer), 7)
+		testutil.AssertDeepEquals(t, tokenizer.calls, 2)
+	})
+
+	t.Run("Entries of deleted files are pruned", func(t *testing.T) {
+		if err := os.Remove(binaryPath); err != nil {
+			t.Fatal(err)
+		}
+
+		// The cache is only saved if another file changed.
+		modTime = modTime.Add(time.Second)
+		writeFile(textPath, "package main\n")
+		load(OpenCache(projectPath))
+
+		cache := OpenCache(projectPath)
+		if _, ok := cache.entries["image.bin"]; ok {
+			t.Error("The entry of the deleted file should be pruned.")
+		}
+	})
+
+	t.Run("An invalid cache file is ignored", func(t *testing.T) {
+		if err := os.WriteFile(filepath.Join(projectPath, constants.AppDir, cacheFileName), []byte("{"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		docs, _ := load(OpenCache(projectPath))
+		testutil.AssertLength(t, docs, 1)
+	})
+}
@@ -0,0 +1,306 @@
+package documents
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"unicode/utf8"
+
+	// The root package of github.com/go-git/go-git (used for gitignore
+	// matching) doesn’t build at its current version, so repositories are read
+	// with the module it was released from.
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	fdiff "gopkg.in/src-d/go-git.v4/plumbing/format/diff"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/utils/diff"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// Changes made to the project, as tracked by git.
+type Diff struct {
+	// The changes in the unified diff format, with paths relative to the
+	// project directory.
+	Patch string
+	// Relative paths of the changed files within the project directory
+	// (including deleted ones), in alphabetical order.
+	Paths []string
+}
+
+// A file changed between two versions of the project. The content is nil if
+// the file doesn’t exist in the respective version.
+type fileChange struct {
+	path     string
+	from, to *string
+}
+
+// Lines of unchanged content around each change in the patch.
+const diffContextLines = 3
+
+// Returns the changes made to the project, which must be inside a git
+// repository (not necessarily at its root). If base is empty, the changes are
+// the uncommitted changes in the working tree (staged or not, but excluding
+// untracked files) compared to HEAD. Otherwise, they are the changes made on
+// HEAD since it diverged from base (like `git diff base...HEAD`).
+func LoadDiff(projectPath string, base string) (Diff, error) {
+	// Paths reported by git are relative to the root of the repository.
+	repo, worktree, prefix, err := openRepository(projectPath)
+	if err != nil {
+		return Diff{}, err
+	}
+
+	var changes []fileChange
+	if base == "" {
+		changes, err = worktreeChanges(repo, worktree)
+	} else {
+		changes, err = committedChanges(repo, base)
+	}
+	if err != nil {
+		return Diff{}, err
+	}
+
+	// Changes outside the project directory are left out.
+	var patch filePatches
+	var paths []string
+
+	for _, change := range changes {
+		path, ok := projectRelativePath(change.path, prefix)
+		if !ok {
+			continue
+		}
+
+		change.path = filepath.ToSlash(path)
+		patch = append(patch, newFilePatch(change))
+		paths = append(paths, path)
+	}
+
+	var encoded bytes.Buffer
+	if err = fdiff.NewUnifiedEncoder(&encoded, diffContextLines).Encode(patch); err != nil {
+		return Diff{}, err
+	}
+
+	return Diff{Patch: encoded.String(), Paths: paths}, nil
+}
+
+// Returns the files that differ between HEAD and the working tree, in
+// alphabetical order.
+func worktreeChanges(repo *git.Repository, worktree *git.Worktree) ([]fileChange, error) {
+	status, err := worktree.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	// A repository without commits has no HEAD, in which case every file is new.
+	var head *object.Commit
+	if ref, err := repo.Head(); err == nil {
+		if head, err = repo.CommitObject(ref.Hash()); err != nil {
+			return nil, err
+		}
+	}
+
+	var paths []string
+	for path, fileStatus := range status {
+		if fileStatus.Worktree != git.Untracked {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	var changes []fileChange
+
+	for _, path := range paths {
+		change := fileChange{path: path}
+
+		if head != nil {
+			if file, err := head.File(path); err == nil {
+				content, err := file.Contents()
+				if err != nil {
+					return nil, err
+				}
+				change.from = &content
+			}
+		}
+
+		if content, err := os.ReadFile(filepath.Join(worktree.Filesystem.Root(), path)); err == nil {
+			text := string(content)
+			change.to = &text
+		}
+
+		if change.from == nil && change.to == nil || change.from != nil && change.to != nil && *change.from == *change.to {
+			continue
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// Returns the files changed on HEAD since it diverged from the base revision,
+// in alphabetical order.
+func committedChanges(repo *git.Repository, base string) ([]fileChange, error) {
+	baseHash, err := repo.ResolveRevision(plumbing.Revision(base))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a valid git revision.", base)
+	}
+
+	baseCommit, err := repo.CommitObject(*baseHash)
+	if err != nil {
+		return nil, err
+	}
+
+	ref, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+
+	headCommit, err := repo.CommitObject(ref.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	mergeBases, err := baseCommit.MergeBase(headCommit)
+	if err != nil {
+		return nil, err
+	}
+	if len(mergeBases) == 0 {
+		return nil, fmt.Errorf("%s and HEAD have no common ancestor.", base)
+	}
+
+	fromTree, err := mergeBases[0].Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	toTree, err := headCommit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	treeChanges, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []fileChange
+
+	for _, treeChange := range treeChanges {
+		from, to, err := treeChange.Files()
+		if err != nil {
+			return nil, err
+		}
+
+		// The names of the change’s entries are full paths, unlike those of the
+		// files.
+		var change fileChange
+
+		if from != nil {
+			change.path = treeChange.From.Name
+			if change.from, err = fileContents(from); err != nil {
+				return nil, err
+			}
+		}
+
+		if to != nil {
+			change.path = treeChange.To.Name
+			if change.to, err = fileContents(to); err != nil {
+				return nil, err
+			}
+		}
+
+		changes = append(changes, change)
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].path < changes[j].path
+	})
+
+	return changes, nil
+}
+
+func fileContents(file *object.File) (*string, error) {
+	content, err := file.Contents()
+	if err != nil {
+		return nil, err
+	}
+
+	return &content, nil
+}
+
+// Implementations of the interfaces expected by the unified diff encoder.
+type (
+	filePatches []fdiff.FilePatch
+
+	filePatch struct {
+		from, to fdiff.File
+		binary   bool
+		chunks   []fdiff.Chunk
+	}
+
+	patchFile struct {
+		path    string
+		content string
+	}
+
+	patchChunk struct {
+		content   string
+		operation fdiff.Operation
+	}
+)
+
+func newFilePatch(change fileChange) *filePatch {
+	patch := &filePatch{}
+
+	var fromContent, toContent string
+
+	if change.from != nil {
+		fromContent = *change.from
+		patch.from = &patchFile{path: change.path, content: fromContent}
+	}
+
+	if change.to != nil {
+		toContent = *change.to
+		patch.to = &patchFile{path: change.path, content: toContent}
+	}
+
+	// Only the fact that a binary file changed is included.
+	if !utf8.ValidString(fromContent) || !utf8.ValidString(toContent) {
+		patch.binary = true
+		return patch
+	}
+
+	for _, d := range diff.Do(fromContent, toContent) {
+		operation := fdiff.Equal
+
+		switch d.Type {
+		case diffmatchpatch.DiffDelete:
+			operation = fdiff.Delete
+		case diffmatchpatch.DiffInsert:
+			operation = fdiff.Add
+		}
+
+		patch.chunks = append(patch.chunks, &patchChunk{content: d.Text, operation: operation})
+	}
+
+	return patch
+}
+
+func (p filePatches) FilePatches() []fdiff.FilePatch { return p }
+func (p filePatches) Message() string                { return "" }
+
+func (p *filePatch) IsBinary() bool               { return p.binary }
+func (p *filePatch) Files() (from, to fdiff.File) { return p.from, p.to }
+func (p *filePatch) Chunks() []fdiff.Chunk        { return p.chunks }
+
+func (f *patchFile) Hash() plumbing.Hash {
+	return plumbing.ComputeHash(plumbing.BlobObject, []byte(f.content))
+}
+func (f *patchFile) Mode() filemode.FileMode { return filemode.Regular }
+func (f *patchFile) Path() string            { return f.path }
+
+func (c *patchChunk) Content() string       { return c.content }
+func (c *patchChunk) Type() fdiff.Operation { return c.operation }
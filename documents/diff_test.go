@@ -0,0 +1,124 @@
+package documents
+
+import (
+	"github.com/malinowskip/pal/testutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// Writes the files to the repository and commits them. Returns the hash of the
+// commit.
+func commitFiles(t *testing.T, repo *git.Repository, files map[string]string) plumbing.Hash {
+	t.Helper()
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.WriteTestFiles(t, worktree.Filesystem.Root(), files)
+	for name := range files {
+		if _, err := worktree.Add(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hash, err := worktree.Commit("Update", &git.CommitOptions{
+		Author: &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hash
+}
+
+func TestLoadDiff(t *testing.T) {
+	repoPath := t.TempDir()
+
+	repo, err := git.PlainInit(repoPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := commitFiles(t, repo, map[string]string{
+		"README.md":       "Hello\n",
+		"app/main.go":     "package main\n\nfunc main() {}\n",
+		"app/obsolete.go": "package main\n",
+	})
+
+	commitFiles(t, repo, map[string]string{
+		"app/main.go": "package main\n\nfunc main() {\n\trun()\n}\n",
+	})
+
+	t.Run("Uncommitted changes", func(t *testing.T) {
+		if err := os.WriteFile(filepath.Join(repoPath, "README.md"), []byte("Hello, world!\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Remove(filepath.Join(repoPath, "app/obsolete.go")); err != nil {
+			t.Fatal(err)
+		}
+		// Untracked files are left out.
+		if err := os.WriteFile(filepath.Join(repoPath, "scratch.txt"), []byte("Notes"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		diff, err := LoadDiff(repoPath, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testutil.AssertDeepEquals(t, diff.Paths, []string{"README.md", filepath.Join("app", "obsolete.go")})
+
+		for _, line := range []string{"--- a/README.md\n", "-Hello\n", "+Hello, world!\n", "+++ /dev/null\n"} {
+			if !strings.Contains(diff.Patch, line) {
+				t.Errorf("The patch should contain %q:\n%s", line, diff.Patch)
+			}
+		}
+	})
+
+	t.Run("Changes since a base revision", func(t *testing.T) {
+		diff, err := LoadDiff(repoPath, first.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testutil.AssertDeepEquals(t, diff.Paths, []string{filepath.Join("app", "main.go")})
+
+		if !strings.Contains(diff.Patch, "+\trun()\n") {
+			t.Errorf("Unexpected patch:\n%s", diff.Patch)
+		}
+	})
+
+	t.Run("Paths are relative to the project directory", func(t *testing.T) {
+		diff, err := LoadDiff(filepath.Join(repoPath, "app"), first.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testutil.AssertDeepEquals(t, diff.Paths, []string{"main.go"})
+
+		if !strings.Contains(diff.Patch, "+++ b/main.go\n") {
+			t.Errorf("Unexpected patch:\n%s", diff.Patch)
+		}
+	})
+
+	t.Run("Fails for invalid revisions", func(t *testing.T) {
+		if _, err := LoadDiff(repoPath, "no-such-branch"); err == nil {
+			t.Error("Expected an error.")
+		}
+	})
+
+	t.Run("Fails outside of a repository", func(t *testing.T) {
+		if _, err := LoadDiff(t.TempDir(), ""); err == nil {
+			t.Error("Expected an error.")
+		}
+	})
+}
@@ -1,10 +1,14 @@
 package documents
 
 import (
-	"io"
+	"errors"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
 	"unicode/utf8"
 
 	"github.com/go-git/go-git/plumbing/format/gitignore"
@@ -18,155 +22,602 @@ type Document struct {
 	Content string
 }
 
+// Reasons for leaving an entry in the project directory out of the context.
+type SkipReason string
+
+const (
+	// Always excluded, e.g. the `.git` directory.
+	SkipReasonBuiltIn SkipReason = "built-in"
+	// Matched by a pattern in a .gitignore file.
+	SkipReasonGitignore SkipReason = "gitignore"
+	// Matched by one of the `exclude` patterns in the config.
+	SkipReasonExclude SkipReason = "exclude"
+	// Not matched by any of the `include` patterns in the config.
+	SkipReasonInclude SkipReason = "include"
+	// Matched by one of the Exclude patterns of the selection.
+	SkipReasonSelectionExclude SkipReason = "selection-exclude"
+	// Not matched by any of the Only patterns of the selection.
+	SkipReasonSelectionOnly SkipReason = "selection-only"
+	// Exceeds the file size limit.
+	SkipReasonMaxFileSize SkipReason = "max-file-size"
+	// Not a UTF-8 text file (e.g. an image) or not a regular file (e.g. a
+	// symbolic link to a directory).
+	SkipReasonNotText SkipReason = "not-text"
+	// Could not be read, e.g. because of its permissions or because it’s a
+	// broken symbolic link.
+	SkipReasonUnreadable SkipReason = "unreadable"
+)
+
+// An entry in the project directory that was left out of the context. If a
+// directory is skipped, its contents are not visited, so they are not listed
+// separately.
+type SkippedEntry struct {
+	// Relative path within the project directory.
+	Path  string
+	IsDir bool
+	// Why the entry was skipped.
+	Reason SkipReason
+	// The pattern that matched the entry, if it was skipped because of a
+	// pattern.
+	Pattern string
+	// Path of the .gitignore file containing the pattern, if the entry was
+	// skipped because of a .gitignore file.
+	Source string
+	// Size of the file in bytes, if it exceeded the file size limit.
+	Size int64
+}
+
+// Patterns that adjust which documents are loaded for a single run (e.g. using
+// command-line flags), on top of the `exclude` patterns in the config and the
+// .gitignore files. The patterns use the .gitignore syntax.
+type Selection struct {
+	// Entries to be loaded even if they are excluded by the config or a
+	// .gitignore file. Built-in exclusions (e.g. `.git`) still apply. Files
+	// inside an excluded directory are re-included only by patterns starting
+	// with the directory’s path, e.g. `vendor/lib/api.go`.
+	Include []string
+	// If not empty, only files matching at least one of these patterns are
+	// loaded.
+	Only []string
+	// Entries to be excluded, in addition to the `exclude` patterns in the
+	// config.
+	Exclude []string
+}
+
+// A .gitignore pattern, along with the information needed to explain why it
+// excluded an entry.
+type sourcedPattern struct {
+	pattern gitignore.Pattern
+	text    string
+	reason  SkipReason
+	// Path of the .gitignore file the pattern comes from.
+	source string
+}
+
 // Given the project path, loads documents that will be included in the context
-// sent to the LLM.
+// sent to the LLM. The files are listed according to the source: either by
+// walking the project directory or, for the git sources, by reading the index
+// of the git repository containing the project, in which case untracked files
+// are never visited.
 //
 // It accepts additional .gitignore glob patterns for files to be excluded and
-// respects any .gitignore files in the project directory.
+// respects any .gitignore files in the project directory (except with the git
+// sources, since ignored files aren’t tracked in the first place). If include patterns
+// are given, only the remaining files that match at least one of them (or
+// whose parent directory does) are loaded, and directories that can’t contain
+// such files are not walked. The patterns of the selection take precedence
+// over all of these.
 //
 // It excludes files whose size exceeds the maxFileSize argument.
-func LoadDocuments(projectPath string, excludePatterns []string, maxFileSize int64) ([]Document, error) {
-	var documents []Document
+//
+// Besides the documents, it returns every entry that was skipped, along with
+// the reason.
+func LoadDocuments(
+	projectPath string,
+	source Source,
+	includePatterns []string,
+	excludePatterns []string,
+	selection Selection,
+	maxFileSize int64,
+	cache *Cache,
+) ([]Document, []SkippedEntry, error) {
+	l := &loader{
+		projectPath: projectPath,
+		readDir: func(relPath string) ([]fs.DirEntry, error) {
+			return os.ReadDir(filepath.Join(projectPath, relPath))
+		},
+		readGitignore: source == SourceFilesystem,
+		// Hardcoded patterns that should always be applied. They can’t be
+		// overridden, so they are matched separately.
+		builtInPatterns: []sourcedPattern{
+			newSourcedPattern(".git", nil, SkipReasonBuiltIn, ""),
+			newSourcedPattern(".pal", nil, SkipReasonBuiltIn, ""),
+		},
+		selection:   selection,
+		includeList: newAllowlist(includePatterns),
+		onlyList:    newAllowlist(selection.Only),
+		maxFileSize: maxFileSize,
+		cache:       cache,
+		semaphore:   make(chan struct{}, runtime.GOMAXPROCS(0)),
+	}
+
+	// Patterns of the selection, matched before the remaining patterns. If an
+	// entry matches both an Include and an Exclude pattern, it’s excluded.
+	for _, pattern := range selection.Include {
+		l.selectionPatterns = append(l.selectionPatterns, newSourcedPattern("!"+pattern, nil, "", ""))
+	}
+	for _, pattern := range selection.Exclude {
+		l.selectionPatterns = append(l.selectionPatterns, newSourcedPattern(pattern, nil, SkipReasonSelectionExclude, ""))
+	}
 
-	// Hardcoded patterns that should always be applied.
-	patterns := []gitignore.Pattern{
-		gitignore.ParsePattern(".git", []string{}),
-		gitignore.ParsePattern(".pal", []string{}),
+	if source == SourceGitTracked || source == SourceGitStaged {
+		paths, err := trackedFiles(projectPath, source == SourceGitStaged)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		l.readDir = newTrackedDirReader(projectPath, paths)
 	}
 
-	// Include custom `exclude` patterns provided by the user.
+	// Include custom `exclude` patterns provided by the user. The list will be
+	// extended with the patterns of any .gitignore files encountered during the
+	// walk.
+	var patterns []sourcedPattern
 	for _, pattern := range excludePatterns {
-		patterns = append(patterns, gitignore.ParsePattern(pattern, []string{}))
+		patterns = append(patterns, newSourcedPattern(pattern, nil, SkipReasonExclude, ""))
+	}
+
+	result, err := l.walkDir(".", patterns, nil)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	// The matcher uses .gitignore patterns to filter out files and directories. It
-	// starts with several hard-coded patterns and any patterns provided by the
-	// user, and will be updated as we traverse the project’s directory structure
-	// and encounter any .gitignore files.
-	matcher := gitignore.NewMatcher(patterns)
+	return result.documents, result.skipped, nil
+}
+
+// The arguments of LoadDocuments, shared by all the directories being walked.
+type loader struct {
+	projectPath string
+	// Returns the entries of the directory at the given relative path, sorted by
+	// name.
+	readDir func(relPath string) ([]fs.DirEntry, error)
+	// Whether the patterns of .gitignore files are applied.
+	readGitignore     bool
+	builtInPatterns   []sourcedPattern
+	selectionPatterns []sourcedPattern
+	selection         Selection
+	includeList       allowlist
+	onlyList          allowlist
+	maxFileSize       int64
+	cache             *Cache
+	// Limits the number of directories walked concurrently.
+	semaphore chan struct{}
+}
+
+// Documents loaded from a directory (including its subdirectories) and the
+// entries skipped in it, in the order of their paths.
+type walkResult struct {
+	documents []Document
+	skipped   []SkippedEntry
+}
+
+func (r *walkResult) append(other walkResult) {
+	r.documents = append(r.documents, other.documents...)
+	r.skipped = append(r.skipped, other.skipped...)
+}
 
-	err := filepath.WalkDir(projectPath, func(path string, dirEntry os.DirEntry, err error) error {
+// Walks the directory at the given relative path. The patterns are those
+// applying to the directory, i.e. the `exclude` patterns and the patterns of
+// the .gitignore files in its parent directories. If the directory is
+// excluded, but walked anyway because the selection re-includes some of its
+// contents, excludedBy is the pattern that excluded it; its entries are
+// excluded by the same pattern, unless they are re-included.
+//
+// Subdirectories are walked concurrently, as long as the semaphore allows it.
+func (l *loader) walkDir(relPath string, patterns []sourcedPattern, excludedBy *sourcedPattern) (walkResult, error) {
+	if l.readGitignore {
+		gitignorePatterns, err := l.readGitignorePatterns(relPath)
 		if err != nil {
-			return err
+			return walkResult{}, err
+		}
+
+		// Patterns appended to the list have higher precedence and will override
+		// any earlier conflicting patterns during matching. The list is clipped,
+		// so that the patterns of sibling directories don’t overwrite each other.
+		patterns = append(slices.Clip(patterns), gitignorePatterns...)
+	}
+
+	entries, err := l.readDir(relPath)
+	if err != nil {
+		return walkResult{}, err
+	}
+
+	// Results of the entries, in the same order, filled in by the goroutines
+	// walking the subdirectories.
+	results := make([]walkResult, len(entries))
+	errs := make([]error, len(entries))
+	var wg sync.WaitGroup
+
+	for i, entry := range entries {
+		entryPath := entry.Name()
+		if relPath != "." {
+			entryPath = filepath.Join(relPath, entry.Name())
 		}
 
-		// Calculate the relative path from the project root to the current file. This
-		// is needed to properly handle .gitignore patterns and create correct document
-		// paths.
-		relPath, err := filepath.Rel(projectPath, path)
+		walkInside, entryExcludedBy, result, err := l.visit(entryPath, entry, patterns, excludedBy)
 		if err != nil {
-			return err
-		}
-
-		pathElements := strings.Split(relPath, string(os.PathSeparator))
-
-		// For directory entries, immediately attempt to identify any .gitignore
-		// files, whose patterns should be included in the matcher.
-		if dirEntry.IsDir() {
-			gitignorePath := filepath.Join(path, ".gitignore")
-
-			// Attempt to read a .gitignore file in the current directory. If one exists,
-			// parse its patterns and update the matcher; if not, continue with the
-			// current patterns.
-			if content, err := os.ReadFile(gitignorePath); err == nil {
-				// The domain specifies the scope where gitignore patterns should apply. When
-				// processing the root .gitignore file (at projectPath), we leave the domain
-				// empty so patterns apply project-wide. For .gitignore files in subdirectories,
-				// patterns only apply within that subdirectory’s tree.
-				var domain []string
-				if relPath != "." {
-					domain = strings.Split(relPath, string(os.PathSeparator))
-				}
-
-				for _, line := range strings.Split(string(content), "\n") {
-					if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
-						pattern := gitignore.ParsePattern(line, domain)
-						// Add the pattern to the global list by adding it at the end. Patterns
-						// appended to the list have higher precedence and will override any
-						// earlier conflicting patterns during matching.
-						patterns = append(patterns, pattern)
-					}
-				}
-
-				// Update the gitignore matcher, allowing newly added patterns from this
-				// directory’s .gitignore to take effect.
-				matcher = gitignore.NewMatcher(patterns)
-			}
+			return walkResult{}, err
 		}
 
-		// If the .matcher matches an entry, it entry should be excluded.
-		if matcher.Match(pathElements, dirEntry.IsDir()) {
-			// If a directory is being excluded, tell WalkDir to skip the entire
-			// directory and its subdirectories.
-			if dirEntry.IsDir() {
-				return filepath.SkipDir
+		results[i] = result
+
+		if !walkInside {
+			continue
+		}
+
+		// If no goroutine is available, the subdirectory is walked right away, so
+		// that walking never waits for a directory that is waiting itself.
+		select {
+		case l.semaphore <- struct{}{}:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-l.semaphore }()
+				results[i], errs[i] = l.walkDir(entryPath, patterns, entryExcludedBy)
+			}()
+		default:
+			if results[i], err = l.walkDir(entryPath, patterns, entryExcludedBy); err != nil {
+				wg.Wait()
+				return walkResult{}, err
 			}
-			return nil
 		}
+	}
 
-		// We need to fetch fs.FileInfo for this entry to check its size.
-		info, err := dirEntry.Info()
-		if err != nil {
-			return err
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return walkResult{}, err
+	}
+
+	var result walkResult
+	for _, r := range results {
+		result.append(r)
+	}
+
+	return result, nil
+}
+
+// Reads the patterns of the .gitignore file in the directory at the given
+// relative path, if there is one.
+func (l *loader) readGitignorePatterns(relPath string) ([]sourcedPattern, error) {
+	gitignorePath := filepath.Join(l.projectPath, relPath, ".gitignore")
+
+	content, err := os.ReadFile(gitignorePath)
+	if err != nil {
+		return nil, nil
+	}
+
+	// The domain specifies the scope where gitignore patterns should apply. When
+	// processing the root .gitignore file (at projectPath), we leave the domain
+	// empty so patterns apply project-wide. For .gitignore files in
+	// subdirectories, patterns only apply within that subdirectory’s tree.
+	var domain []string
+	if relPath != "." {
+		domain = strings.Split(relPath, string(os.PathSeparator))
+	}
+
+	source, err := filepath.Rel(l.projectPath, gitignorePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var patterns []sourcedPattern
+	for _, line := range strings.Split(string(content), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			patterns = append(patterns, newSourcedPattern(line, domain, SkipReasonGitignore, source))
 		}
+	}
+
+	return patterns, nil
+}
+
+// Decides whether an entry of a directory being walked is loaded (if it’s a
+// file) or walked (if it’s a directory), or whether it’s skipped. Reports
+// whether the entry is a directory that should be walked, along with the
+// pattern excluding its contents, if any. The result contains the loaded
+// document or the skipped entry, if any.
+func (l *loader) visit(relPath string, dirEntry fs.DirEntry, patterns []sourcedPattern, excludedBy *sourcedPattern) (bool, *sourcedPattern, walkResult, error) {
+	pathElements := strings.Split(relPath, string(os.PathSeparator))
+	isDir := dirEntry.IsDir()
+
+	// If a pattern matches an entry, the entry should be excluded. The built-in
+	// patterns come first, followed by the patterns of the selection and the
+	// exclusion of the parent directory, if any.
+	match, matched := matchPatterns(l.builtInPatterns, pathElements, isDir)
+	if !matched {
+		match, matched = matchPatterns(l.selectionPatterns, pathElements, isDir)
+	}
+	reincluded := matched && match == nil
+	if !matched && excludedBy != nil {
+		match, matched = excludedBy, true
+	}
+	if !matched {
+		match, _ = matchPatterns(patterns, pathElements, isDir)
+	}
 
-		// Exclude files that exceed the file size limit.
-		if info.Size() > maxFileSize {
-			return nil
+	// An excluded directory is walked if the selection re-includes a path
+	// inside it.
+	if match != nil && isDir && match.reason != SkipReasonBuiltIn && match.reason != SkipReasonSelectionExclude {
+		if reachesInside(l.selection.Include, relPath) {
+			return true, match, walkResult{}, nil
 		}
+	}
+
+	// If a directory is being excluded, its contents are not walked.
+	if match != nil {
+		return false, nil, skippedResult(SkippedEntry{
+			Path:    relPath,
+			IsDir:   isDir,
+			Reason:  match.reason,
+			Pattern: match.text,
+			Source:  match.source,
+		}), nil
+	}
+
+	// Entries re-included by the selection aren’t subject to the `include`
+	// patterns, but they are subject to the Only patterns of the selection. A
+	// directory that isn’t allowed itself is still walked if it may contain
+	// allowed files.
+	allowedByInclude := reincluded || l.includeList.allows(pathElements, isDir) ||
+		isDir && (l.includeList.mayAllowInside(pathElements) || mayMatchInside(l.selection.Include, pathElements))
+	allowedByOnly := l.onlyList.allows(pathElements, isDir) ||
+		isDir && l.onlyList.mayAllowInside(pathElements)
+
+	if !allowedByInclude {
+		return false, nil, skippedResult(SkippedEntry{Path: relPath, IsDir: isDir, Reason: SkipReasonInclude}), nil
+	}
+	if !allowedByOnly {
+		return false, nil, skippedResult(SkippedEntry{Path: relPath, IsDir: isDir, Reason: SkipReasonSelectionOnly}), nil
+	}
+
+	// Directories are walked, but not included themselves.
+	if isDir {
+		return true, nil, walkResult{}, nil
+	}
+
+	result, err := l.loadFile(relPath, dirEntry)
+
+	return false, nil, result, err
+}
+
+// Loads the file at the given relative path as a document, unless it exceeds
+// the file size limit, isn’t a text file or can’t be read. Symbolic links are
+// followed.
+func (l *loader) loadFile(relPath string, dirEntry fs.DirEntry) (walkResult, error) {
+	filePath := filepath.Join(l.projectPath, relPath)
+
+	unreadable := skippedResult(SkippedEntry{
+		Path:   relPath,
+		Reason: SkipReasonUnreadable,
+	})
+
+	notText := skippedResult(SkippedEntry{
+		Path:   relPath,
+		Reason: SkipReasonNotText,
+	})
+
+	// We need to fetch fs.FileInfo for this entry to check its size. For
+	// symbolic links, it describes the target, which may not exist.
+	info, err := dirEntry.Info()
+	if err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		info, err = os.Stat(filePath)
+	}
+	if err != nil {
+		return unreadable, nil
+	}
+
+	// Only regular files are loaded, so that e.g. links to directories and named
+	// pipes are skipped rather than read.
+	if !info.Mode().IsRegular() {
+		return notText, nil
+	}
+
+	// Exclude files that exceed the file size limit.
+	if info.Size() > l.maxFileSize {
+		return skippedResult(SkippedEntry{
+			Path:   relPath,
+			Reason: SkipReasonMaxFileSize,
+			Size:   info.Size(),
+		}), nil
+	}
+
+	// Files that are known not to be text files aren’t read again.
+	if isText, ok := l.cache.isText(relPath, info); ok && !isText {
+		return notText, nil
+	}
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return unreadable, nil
+	}
+
+	// Exclude non-UTF-8 files, such as images.
+	isText := isValidUTF8(content)
+	l.cache.setText(relPath, info, isText)
 
-		// Exclude non-UTF-8 files, such as images.
-		isTextFile, err := IsValidUTF8File(path)
-		if !isTextFile || err != nil {
-			return nil
+	if !isText {
+		return notText, nil
+	}
+
+	return walkResult{documents: []Document{{Path: relPath, Content: string(content)}}}, nil
+}
+
+func skippedResult(entry SkippedEntry) walkResult {
+	return walkResult{skipped: []SkippedEntry{entry}}
+}
+
+func newSourcedPattern(text string, domain []string, reason SkipReason, source string) sourcedPattern {
+	return sourcedPattern{
+		pattern: gitignore.ParsePattern(text, domain),
+		text:    text,
+		reason:  reason,
+		source:  source,
+	}
+}
+
+// Matches the path against the patterns the same way as gitignore.Matcher
+// does: the last pattern that matches takes precedence. Returns the pattern
+// that excludes the path, or nil if the path is not excluded (either because no
+// pattern matches or because it was re-included by a negated pattern). The
+// second return value reports whether any pattern matched.
+func matchPatterns(patterns []sourcedPattern, path []string, isDir bool) (*sourcedPattern, bool) {
+	for i := len(patterns) - 1; i >= 0; i-- {
+		switch patterns[i].pattern.Match(path, isDir) {
+		case gitignore.Exclude:
+			return &patterns[i], true
+		case gitignore.Include:
+			return nil, true
 		}
+	}
 
-		// Now if the entry is a file, add it to the list of documents.
-		if !info.IsDir() {
-			content, err := os.ReadFile(path)
-			if err != nil {
-				return err
+	return nil, false
+}
+
+// Patterns of the entries to be loaded, such as the `include` patterns in the
+// config. An entry is allowed if it or any of its parent directories matches a
+// pattern. If there are no patterns, every entry is allowed.
+type allowlist struct {
+	patterns []gitignore.Pattern
+	texts    []string
+}
+
+func newAllowlist(texts []string) allowlist {
+	list := allowlist{texts: texts}
+
+	for _, text := range texts {
+		list.patterns = append(list.patterns, gitignore.ParsePattern(text, nil))
+	}
+
+	return list
+}
+
+// Reports whether the entry is allowed.
+func (a allowlist) allows(path []string, isDir bool) bool {
+	if len(a.patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range a.patterns {
+		for i := 1; i <= len(path); i++ {
+			if pattern.Match(path[:i], isDir || i < len(path)) == gitignore.Exclude {
+				return true
 			}
+		}
+	}
+
+	return false
+}
 
-			documents = append(documents, Document{
-				Path:    relPath,
-				Content: string(content),
-			})
+// Reports whether the directory, which isn’t allowed itself, may contain
+// allowed entries.
+func (a allowlist) mayAllowInside(dir []string) bool {
+	return mayMatchInside(a.texts, dir)
+}
+
+// Reports whether any of the patterns may match entries inside the directory.
+// Patterns without a slash (other than a trailing one) match at any depth, so
+// they may match inside any directory. Other patterns are relative to the
+// project root, so their leading segments must match the directory’s path,
+// unless they contain `**`.
+func mayMatchInside(patterns []string, dir []string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(pattern, "/")
+
+		if !strings.Contains(pattern, "/") {
+			return true
 		}
 
-		return nil
-	})
+		segments := strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+		if mayMatchSegments(segments, dir) {
+			return true
+		}
+	}
 
-	if err != nil {
-		return nil, err
+	return false
+}
+
+func mayMatchSegments(segments []string, dir []string) bool {
+	for i, element := range dir {
+		if i >= len(segments) {
+			return false
+		}
+
+		if segments[i] == "**" {
+			return true
+		}
+
+		if matched, _ := filepath.Match(segments[i], element); !matched {
+			return false
+		}
 	}
 
-	return documents, nil
+	return len(segments) > len(dir)
 }
 
-// Based on a sample of the file, checks if the file contains valid UTF-8
-// encoding.
-func IsValidUTF8File(filePath string) (bool, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return false, err
+// Reports whether any of the patterns starts with the path of the directory,
+// i.e. whether it may match entries inside the directory.
+func reachesInside(patterns []string, dirPath string) bool {
+	prefix := filepath.ToSlash(dirPath) + "/"
+
+	for _, pattern := range patterns {
+		if strings.HasPrefix(strings.TrimPrefix(pattern, "/"), prefix) {
+			return true
+		}
 	}
-	defer file.Close()
 
-	fileInfo, err := file.Stat()
+	return false
+}
+
+// Explains why the path was skipped, based on the entries returned by
+// LoadDocuments. If the path is inside a skipped directory, the directory’s
+// entry is returned. Returns nil if the path was not skipped.
+func FindSkippedEntry(skipped []SkippedEntry, path string) *SkippedEntry {
+	path = filepath.Clean(path)
+
+	for i, entry := range skipped {
+		if entry.Path == path {
+			return &skipped[i]
+		}
+
+		if entry.IsDir && strings.HasPrefix(path, entry.Path+string(os.PathSeparator)) {
+			return &skipped[i]
+		}
+	}
+
+	return nil
+}
+
+// Checks if the file contains valid UTF-8 encoding.
+func IsValidUTF8File(filePath string) (bool, error) {
+	info, err := os.Stat(filePath)
 	if err != nil {
 		return false, err
 	}
 
-	if fileInfo.IsDir() {
+	if info.IsDir() {
 		return false, nil
 	}
 
-	buffer := make([]byte, 8*1024) // 8KB should be enough for a initial check
-	n, err := file.Read(buffer)
-	if err != nil && err != io.EOF {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
 		return false, err
 	}
 
-	return utf8.Valid(buffer[:n]), nil
+	return isValidUTF8(content), nil
+}
+
+// Checks if the content of a file is valid UTF-8. The whole content is checked,
+// since a sample may end in the middle of a multi-byte character.
+func isValidUTF8(content []byte) bool {
+	return utf8.Valid(content)
 }
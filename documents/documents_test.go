@@ -5,6 +5,7 @@ import (
 	"github.com/malinowskip/pal/testutil"
 	"path"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -67,7 +68,7 @@ func TestDocumentLoading(t *testing.T) {
 		addTestFile(p, content)
 	}
 
-	docs, _ := LoadDocuments(projectPath, additionalExcludePatterns, 10000)
+	docs, _, _ := LoadDocuments(projectPath, SourceFilesystem, nil, additionalExcludePatterns, Selection{}, 10000, nil)
 
 	for _, doc := range docs {
 		content, exists := toInclude[doc.Path]
@@ -101,7 +102,7 @@ func TestDocumentLoadingRespectsFileSizeRestriction(t *testing.T) {
 
 	t.Run("Loads file normally", func(t *testing.T) {
 		maxFileSize := int64(10_000)
-		d, _ := LoadDocuments(projectPath, []string{}, maxFileSize)
+		d, _, _ := LoadDocuments(projectPath, SourceFilesystem, nil, []string{}, Selection{}, maxFileSize, nil)
 		testutil.AssertContains(t, d, func(doc Document) bool {
 			return doc.Path == testFileName
 		})
@@ -109,9 +110,290 @@ func TestDocumentLoadingRespectsFileSizeRestriction(t *testing.T) {
 
 	t.Run("Does not load the file if it’s too large", func(t *testing.T) {
 		maxFileSize := int64(1)
-		d, _ := LoadDocuments(projectPath, []string{}, maxFileSize)
+		d, _, _ := LoadDocuments(projectPath, SourceFilesystem, nil, []string{}, Selection{}, maxFileSize, nil)
 		testutil.AssertNotContains(t, d, func(doc Document) bool {
 			return doc.Path == testFileName
 		})
 	})
 }
+
+func TestDocumentLoadingRecordsSkipReasons(t *testing.T) {
+	projectPath := t.TempDir()
+
+	files := map[string]string{
+		".gitignore":          "*.log\nbuild/\n",
+		"sub/.gitignore":      "secret.txt\n",
+		"sub/secret.txt":      "hunter2",
+		"app.log":             "log",
+		"build/output.txt":    "output",
+		"data.xml":            "<data/>",
+		"large.md":            strings.Repeat("a", 100),
+		"image.png":           "\x89PNG\r\n\x1a\n\x00\x00\xff",
+		"README.md":           "Hello, world!",
+		".git/HEAD":           "ref: refs/heads/main",
+		"sub/not-ignored.txt": "Included",
+	}
+
+	testutil.WriteTestFiles(t, projectPath, files)
+
+	_, skipped, err := LoadDocuments(projectPath, SourceFilesystem, nil, []string{"*.xml"}, Selection{}, 50, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]SkippedEntry{
+		".git":           {Path: ".git", IsDir: true, Reason: SkipReasonBuiltIn, Pattern: ".git"},
+		"app.log":        {Path: "app.log", Reason: SkipReasonGitignore, Pattern: "*.log", Source: ".gitignore"},
+		"build":          {Path: "build", IsDir: true, Reason: SkipReasonGitignore, Pattern: "build/", Source: ".gitignore"},
+		"sub/secret.txt": {Path: "sub/secret.txt", Reason: SkipReasonGitignore, Pattern: "secret.txt", Source: "sub/.gitignore"},
+		"data.xml":       {Path: "data.xml", Reason: SkipReasonExclude, Pattern: "*.xml"},
+		"large.md":       {Path: "large.md", Reason: SkipReasonMaxFileSize, Size: 100},
+		"image.png":      {Path: "image.png", Reason: SkipReasonNotText},
+	}
+
+	testutil.AssertLength(t, skipped, len(expected))
+
+	for _, entry := range skipped {
+		testutil.AssertDeepEquals(t, entry, expected[entry.Path])
+	}
+
+	t.Run("Finds the entry of a skipped directory", func(t *testing.T) {
+		entry := FindSkippedEntry(skipped, "build/output.txt")
+		testutil.AssertDeepEquals(t, entry.Path, "build")
+
+		if FindSkippedEntry(skipped, "README.md") != nil {
+			t.Error("README.md was not skipped.")
+		}
+
+		if FindSkippedEntry(skipped, "builder.go") != nil {
+			t.Error("builder.go is not inside the build directory.")
+		}
+	})
+}
+
+func TestDocumentLoadingSkipsSpecialFiles(t *testing.T) {
+	projectPath := t.TempDir()
+
+	testutil.WriteTestFiles(t, projectPath, map[string]string{"docs/guide.md": "# Guide"})
+
+	links := map[string]string{
+		// Links to files are followed.
+		"guide-link.md": filepath.Join("docs", "guide.md"),
+		"docs-link":     "docs",
+		"broken-link":   "missing.md",
+	}
+
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(projectPath, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	docs, skipped, err := LoadDocuments(projectPath, SourceFilesystem, nil, nil, Selection{}, 10000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.AssertDeepEquals(t, docs, []Document{
+		{Path: filepath.Join("docs", "guide.md"), Content: "# Guide"},
+		{Path: "guide-link.md", Content: "# Guide"},
+	})
+
+	testutil.AssertDeepEquals(t, skipped, []SkippedEntry{
+		{Path: "broken-link", Reason: SkipReasonUnreadable},
+		{Path: "docs-link", Reason: SkipReasonNotText},
+	})
+}
+
+func TestDocumentLoadingWithSelection(t *testing.T) {
+	projectPath := t.TempDir()
+
+	files := map[string]string{
+		".gitignore":         "vendor/\n*.log\n",
+		"main.go":            "package main",
+		"main_test.go":       "package main",
+		"README.md":          "Hello, world!",
+		"app.log":            "log",
+		"data.xml":           "<data/>",
+		"vendor/lib/api.go":  "package lib",
+		"vendor/lib/util.go": "package lib",
+		".git/HEAD":          "ref: refs/heads/main",
+	}
+
+	testutil.WriteTestFiles(t, projectPath, files)
+
+	load := func(selection Selection) ([]string, []SkippedEntry) {
+		docs, skipped, err := LoadDocuments(projectPath, SourceFilesystem, nil, []string{"*.xml"}, selection, 10000, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var paths []string
+		for _, doc := range docs {
+			paths = append(paths, doc.Path)
+		}
+
+		return paths, skipped
+	}
+
+	t.Run("Include re-includes excluded files", func(t *testing.T) {
+		paths, skipped := load(Selection{Include: []string{"*.xml", "app.log", "vendor/lib/api.go", ".git"}})
+
+		testutil.AssertDeepEquals(t, paths, []string{".gitignore", "README.md", "app.log", "data.xml", "main.go", "main_test.go", "vendor/lib/api.go"})
+
+		// Other files in the excluded directory are skipped individually.
+		entry := FindSkippedEntry(skipped, "vendor/lib/util.go")
+		testutil.AssertDeepEquals(t, *entry, SkippedEntry{
+			Path:    "vendor/lib/util.go",
+			Reason:  SkipReasonGitignore,
+			Pattern: "vendor/",
+			Source:  ".gitignore",
+		})
+	})
+
+	t.Run("Only narrows the documents", func(t *testing.T) {
+		paths, skipped := load(Selection{Only: []string{"*.go"}, Exclude: []string{"*_test.go"}})
+
+		testutil.AssertDeepEquals(t, paths, []string{"main.go"})
+		testutil.AssertDeepEquals(t, *FindSkippedEntry(skipped, "README.md"), SkippedEntry{
+			Path:   "README.md",
+			Reason: SkipReasonSelectionOnly,
+		})
+		testutil.AssertDeepEquals(t, *FindSkippedEntry(skipped, "main_test.go"), SkippedEntry{
+			Path:    "main_test.go",
+			Reason:  SkipReasonSelectionExclude,
+			Pattern: "*_test.go",
+		})
+	})
+
+	t.Run("Exclude takes precedence over Include", func(t *testing.T) {
+		paths, _ := load(Selection{Include: []string{"app.log"}, Exclude: []string{"app.log"}})
+
+		testutil.AssertNotContains(t, paths, func(path string) bool { return path == "app.log" })
+	})
+}
+
+func TestDocumentLoadingWithIncludePatterns(t *testing.T) {
+	projectPath := t.TempDir()
+
+	files := map[string]string{
+		".gitignore":                        "*.log\n",
+		"README.md":                         "Hello, world!",
+		"docs/guide.md":                     "Guide",
+		"docs/api/index.md":                 "API",
+		"services/auth/main.go":             "package auth",
+		"services/billing/main.go":          "package billing",
+		"services/billing/main_test.go":     "package billing",
+		"services/billing/debug.log":        "log",
+		"services/billing/internal/calc.go": "package internal",
+	}
+
+	testutil.WriteTestFiles(t, projectPath, files)
+
+	docs, skipped, err := LoadDocuments(
+		projectPath,
+		SourceFilesystem,
+		[]string{"services/billing/**", "/docs"},
+		[]string{"*_test.go"},
+		Selection{},
+		10000,
+		nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	for _, doc := range docs {
+		paths = append(paths, doc.Path)
+	}
+
+	testutil.AssertDeepEquals(t, paths, []string{
+		"docs/api/index.md",
+		"docs/guide.md",
+		"services/billing/internal/calc.go",
+		"services/billing/main.go",
+	})
+
+	// Unrelated directories are not walked, so they are skipped as a whole.
+	testutil.AssertDeepEquals(t, *FindSkippedEntry(skipped, "services/auth/main.go"), SkippedEntry{
+		Path:   "services/auth",
+		IsDir:  true,
+		Reason: SkipReasonInclude,
+	})
+
+	// The include patterns apply after the exclusions.
+	testutil.AssertDeepEquals(t, FindSkippedEntry(skipped, "services/billing/main_test.go").Reason, SkipReasonExclude)
+	testutil.AssertDeepEquals(t, FindSkippedEntry(skipped, "services/billing/debug.log").Reason, SkipReasonGitignore)
+	testutil.AssertDeepEquals(t, FindSkippedEntry(skipped, "README.md").Reason, SkipReasonInclude)
+
+	t.Run("The selection re-includes files that aren’t included", func(t *testing.T) {
+		docs, _, err := LoadDocuments(
+			projectPath,
+			SourceFilesystem,
+			[]string{"docs"},
+			nil,
+			Selection{Include: []string{"services/auth/main.go"}},
+			10000,
+			nil,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testutil.AssertContains(t, docs, func(doc Document) bool {
+			return doc.Path == "services/auth/main.go"
+		})
+		testutil.AssertNotContains(t, docs, func(doc Document) bool {
+			return doc.Path == "services/billing/main.go"
+		})
+	})
+}
+
+func TestMayMatchInside(t *testing.T) {
+	cases := []struct {
+		pattern  string
+		dir      string
+		expected bool
+	}{
+		{"*.go", "vendor", true},
+		{"docs/", "services", true},
+		{"services/billing/**", "services", true},
+		{"services/billing/**", "services/billing", true},
+		{"services/billing/**", "services/auth", false},
+		{"/services/*/api", "services/auth", true},
+		{"services/billing", "services/billing/internal", false},
+		{"**/api", "docs", true},
+		{"docs/guide.md", "services", false},
+	}
+
+	for _, c := range cases {
+		if mayMatchInside([]string{c.pattern}, strings.Split(c.dir, "/")) != c.expected {
+			t.Errorf("%s may match inside %s: expected %v", c.pattern, c.dir, c.expected)
+		}
+	}
+}
+
+func TestIsValidUTF8File(t *testing.T) {
+	dirPath := t.TempDir()
+
+	// The multi-byte character straddles the boundary of the 8KB sample that
+	// used to be checked.
+	textPath := filepath.Join(dirPath, "text.md")
+	if err := os.WriteFile(textPath, []byte(strings.Repeat("a", 8*1024-1)+"é"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	binaryPath := filepath.Join(dirPath, "image.bin")
+	if err := os.WriteFile(binaryPath, []byte("\xff\xfe"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, expected := range map[string]bool{textPath: true, binaryPath: false, dirPath: false} {
+		isText, err := IsValidUTF8File(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.AssertDeepEquals(t, isText, expected)
+	}
+}
@@ -0,0 +1,159 @@
+package documents
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/plumbing/format/gitignore"
+)
+
+// Replaces the content of Go files matching any of the .gitignore glob patterns
+// with their outlines (see OutlineGo), which lets large Go projects fit within
+// the context limit while still conveying their API surface. Files that cannot
+// be parsed are left intact.
+//
+// The returned slice is a copy; the original documents are not modified.
+func Outline(docs []Document, patterns []string) []Document {
+	outlined := make([]Document, len(docs))
+	copy(outlined, docs)
+
+	if len(patterns) == 0 {
+		return outlined
+	}
+
+	var parsedPatterns []gitignore.Pattern
+	for _, pattern := range patterns {
+		parsedPatterns = append(parsedPatterns, gitignore.ParsePattern(pattern, []string{}))
+	}
+	matcher := gitignore.NewMatcher(parsedPatterns)
+
+	for i, doc := range outlined {
+		if filepath.Ext(doc.Path) != ".go" {
+			continue
+		}
+
+		if !matcher.Match(strings.Split(filepath.ToSlash(doc.Path), "/"), false) {
+			continue
+		}
+
+		if outline, err := OutlineGo(doc.Content); err == nil {
+			outlined[i].Content = outline
+		}
+	}
+
+	return outlined
+}
+
+// Reduces Go source code to its outline: the package clause, exported type
+// declarations, and signatures of exported functions and methods, each along
+// with its doc comment. Function bodies, imports, unexported declarations,
+// constants and variables are left out.
+func OutlineGo(source string) (string, error) {
+	fset := token.NewFileSet()
+
+	file, err := parser.ParseFile(fset, "", source, parser.ParseComments)
+	if err != nil {
+		return "", err
+	}
+
+	// Each part of the outline (e.g. a declaration with its doc comment) is
+	// separated by a blank line.
+	var parts []string
+
+	// Same settings as gofmt.
+	printConfig := &printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+
+	printNode := func(node any) string {
+		var output strings.Builder
+		printConfig.Fprint(&output, fset, node)
+		return output.String()
+	}
+
+	docComment := func(doc *ast.CommentGroup) string {
+		if doc == nil {
+			return ""
+		}
+
+		start := fset.Position(doc.Pos()).Offset
+		end := fset.Position(doc.End()).Offset
+
+		return source[start:end] + "\n"
+	}
+
+	parts = append(parts, docComment(file.Doc)+"package "+file.Name.Name)
+
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.GenDecl:
+			if decl.Tok != token.TYPE {
+				continue
+			}
+
+			for _, spec := range decl.Specs {
+				typeSpec := spec.(*ast.TypeSpec)
+				if !typeSpec.Name.IsExported() {
+					continue
+				}
+
+				// In a declaration of a single type, the doc comment is attached to the
+				// declaration rather than the type.
+				doc := typeSpec.Doc
+				if doc == nil && !decl.Lparen.IsValid() {
+					doc = decl.Doc
+				}
+
+				// The doc comment is printed separately, before the `type` keyword.
+				withoutDoc := *typeSpec
+				withoutDoc.Doc = nil
+
+				// Comments are included, so that struct fields and interface methods keep
+				// their documentation.
+				node := &printer.CommentedNode{Node: &withoutDoc, Comments: file.Comments}
+
+				parts = append(parts, docComment(doc)+"type "+printNode(node))
+			}
+		case *ast.FuncDecl:
+			if !decl.Name.IsExported() || !hasExportedReceiver(decl) {
+				continue
+			}
+
+			signature := *decl
+			signature.Doc = nil
+			signature.Body = nil
+
+			parts = append(parts, docComment(decl.Doc)+printNode(&signature))
+		}
+	}
+
+	return strings.Join(parts, "\n\n") + "\n", nil
+}
+
+// Reports whether the function is not a method, or whether it’s a method of an
+// exported type.
+func hasExportedReceiver(decl *ast.FuncDecl) bool {
+	if decl.Recv == nil || len(decl.Recv.List) == 0 {
+		return true
+	}
+
+	receiverType := decl.Recv.List[0].Type
+
+	// Unwrap pointers and type parameters, e.g. `*List[T]`.
+	for {
+		switch t := receiverType.(type) {
+		case *ast.StarExpr:
+			receiverType = t.X
+		case *ast.IndexExpr:
+			receiverType = t.X
+		case *ast.IndexListExpr:
+			receiverType = t.X
+		case *ast.Ident:
+			return t.IsExported()
+		default:
+			return false
+		}
+	}
+}
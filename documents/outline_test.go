@@ -0,0 +1,100 @@
+package documents
+
+import (
+	"github.com/malinowskip/pal/testutil"
+	"testing"
+)
+
+const outlineTestSource = `// Package shapes does geometry.
+package shapes
+
+import "math"
+
+const pi = math.Pi
+
+// A Circle is round.
+type Circle struct {
+	// Radius in meters.
+	Radius float64
+	label  string
+}
+
+type (
+	// Shape is anything with an area.
+	Shape interface {
+		Area() float64
+	}
+	point struct{ x, y float64 }
+)
+
+// Area returns the area of the circle.
+func (c *Circle) Area() float64 {
+	// Multiply.
+	return pi * c.Radius * c.Radius
+}
+
+func (p point) Distance() float64 {
+	return math.Hypot(p.x, p.y)
+}
+
+// New creates a circle.
+func New(radius float64) *Circle {
+	return &Circle{Radius: radius}
+}
+
+func helper() {}
+`
+
+const expectedOutline = `// Package shapes does geometry.
+package shapes
+
+// A Circle is round.
+type Circle struct {
+	// Radius in meters.
+	Radius float64
+	label  string
+}
+
+// Shape is anything with an area.
+type Shape interface {
+	Area() float64
+}
+
+// Area returns the area of the circle.
+func (c *Circle) Area() float64
+
+// New creates a circle.
+func New(radius float64) *Circle
+`
+
+func TestOutlineGo(t *testing.T) {
+	outline, err := OutlineGo(outlineTestSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.AssertDeepEquals(t, outline, expectedOutline)
+
+	if _, err = OutlineGo("not go"); err == nil {
+		t.Error("Expected an error.")
+	}
+}
+
+func TestOutline(t *testing.T) {
+	docs := []Document{
+		{Path: "internal/shapes/shapes.go", Content: outlineTestSource},
+		{Path: "internal/broken.go", Content: "not go"},
+		{Path: "internal/README.md", Content: "# Shapes"},
+		{Path: "main.go", Content: outlineTestSource},
+	}
+
+	outlined := Outline(docs, []string{"internal/**/*.go"})
+
+	testutil.AssertDeepEquals(t, outlined[0].Content, expectedOutline)
+	testutil.AssertDeepEquals(t, outlined[1].Content, "not go")
+	testutil.AssertDeepEquals(t, outlined[2].Content, "# Shapes")
+	testutil.AssertDeepEquals(t, outlined[3].Content, outlineTestSource)
+
+	// The original documents are left intact.
+	testutil.AssertDeepEquals(t, docs[0].Content, outlineTestSource)
+}
@@ -0,0 +1,256 @@
+package documents
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// A Detector finds secrets of a certain kind (e.g. AWS access keys) in the
+// content of documents, so that they can be redacted before the documents are
+// sent to the LLM.
+type Detector struct {
+	// Name of the detector, included in the placeholders of redacted secrets.
+	Name string
+	// Returns the byte offsets ([start, end) pairs) of the secrets found in the
+	// text.
+	find func(text string) [][]int
+}
+
+// A secret that was redacted from a document.
+type Redaction struct {
+	// Relative path of the document within the project directory.
+	Path string
+	// Line of the secret in the original document, starting at 1.
+	Line int
+	// Name of the detector that found the secret.
+	Detector string
+}
+
+// Names of the built-in detectors, in the order in which they are applied.
+var BuiltInDetectorNames = []string{
+	"private-key",
+	"aws-access-key",
+	"github-token",
+	"key-assignment",
+	"high-entropy",
+}
+
+var builtInDetectors = map[string]Detector{
+	// PEM blocks of private keys, including the header and the footer.
+	"private-key": patternDetector(
+		"private-key",
+		regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY( BLOCK)?-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY( BLOCK)?-----`),
+	),
+	"aws-access-key": patternDetector(
+		"aws-access-key",
+		regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`),
+	),
+	// Personal access, OAuth, user-to-server, server-to-server and refresh
+	// tokens, as well as fine-grained personal access tokens.
+	"github-token": patternDetector(
+		"github-token",
+		regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,255}|github_pat_[A-Za-z0-9_]{22,255})\b`),
+	),
+	// Values assigned to variables such as `OPENAI_API_KEY` at the beginning of
+	// a line, as in `.env` files and shell scripts. Only the value is redacted.
+	"key-assignment": patternDetector(
+		"key-assignment",
+		regexp.MustCompile(`(?m)^[ \t]*(?:export[ \t]+)?[A-Z0-9_]*_(?:KEY|SECRET|TOKEN|PASSWORD)[ \t]*=[ \t]*["']?([^\s"'#]+)`),
+	),
+	"high-entropy": {Name: "high-entropy", find: findHighEntropyStrings},
+}
+
+// Returns the built-in detectors, except for the disabled ones (`all` disables
+// all of them), followed by a detector for each of the custom patterns, keyed
+// by name. If a custom pattern contains a capture group, only the first group
+// is redacted.
+func Detectors(disabled []string, customPatterns map[string]string) ([]Detector, error) {
+	var detectors []Detector
+
+	for _, name := range disabled {
+		if name != "all" && !slices.Contains(BuiltInDetectorNames, name) {
+			return nil, fmt.Errorf(`%s is not a built-in detector in the "%s" configuration value.`, name, "redact.disable")
+		}
+	}
+
+	if !slices.Contains(disabled, "all") {
+		for _, name := range BuiltInDetectorNames {
+			if !slices.Contains(disabled, name) {
+				detectors = append(detectors, builtInDetectors[name])
+			}
+		}
+	}
+
+	// Custom patterns are sorted by name, so that the order is deterministic.
+	names := make([]string, 0, len(customPatterns))
+	for name := range customPatterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		pattern, err := regexp.Compile(customPatterns[name])
+		if err != nil {
+			return nil, fmt.Errorf(`The %s pattern in the "%s" configuration value is not a valid regular expression.`, name, "redact.patterns")
+		}
+
+		detectors = append(detectors, patternDetector(name, pattern))
+	}
+
+	return detectors, nil
+}
+
+// Returns a detector of the matches of the pattern or, if the pattern contains
+// a capture group, of the first group.
+func patternDetector(name string, pattern *regexp.Regexp) Detector {
+	return Detector{
+		Name: name,
+		find: func(text string) [][]int {
+			var secrets [][]int
+
+			for _, match := range pattern.FindAllStringSubmatchIndex(text, -1) {
+				if len(match) >= 4 && match[2] >= 0 {
+					secrets = append(secrets, match[2:4])
+				} else {
+					secrets = append(secrets, match[0:2])
+				}
+			}
+
+			return secrets
+		},
+	}
+}
+
+// Candidates for high-entropy secrets: long strings of the characters used in
+// base64 and similar encodings.
+var highEntropyCandidatePattern = regexp.MustCompile(`[A-Za-z0-9+/_\-]{32,}={0,2}`)
+
+// Checksums (e.g. in `go.sum` or lock files) look random, but aren’t secret.
+// They are recognized by the prefix directly preceding them.
+var checksumPrefixes = []string{"h1:", "sha1-", "sha1:", "sha256-", "sha256:", "sha384-", "sha384:", "sha512-", "sha512:"}
+
+// Minimum Shannon entropy (in bits per character) of a high-entropy secret.
+const minSecretEntropy = 4.3
+
+// Finds long strings that look random: they mix lowercase letters, uppercase
+// letters and digits, and their entropy is high.
+func findHighEntropyStrings(text string) [][]int {
+	var secrets [][]int
+
+	for _, match := range highEntropyCandidatePattern.FindAllStringIndex(text, -1) {
+		candidate := text[match[0]:match[1]]
+
+		if hasChecksumPrefix(text[:match[0]]) {
+			continue
+		}
+
+		hasLower := strings.IndexFunc(candidate, unicode.IsLower) >= 0
+		hasUpper := strings.IndexFunc(candidate, unicode.IsUpper) >= 0
+		hasDigit := strings.IndexFunc(candidate, unicode.IsDigit) >= 0
+
+		if hasLower && hasUpper && hasDigit && shannonEntropy(candidate) >= minSecretEntropy {
+			secrets = append(secrets, match)
+		}
+	}
+
+	return secrets
+}
+
+// Reports whether the text preceding a candidate ends with a checksum prefix.
+func hasChecksumPrefix(preceding string) bool {
+	for _, prefix := range checksumPrefixes {
+		if strings.HasSuffix(preceding, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the Shannon entropy of the string in bits per character.
+func shannonEntropy(text string) float64 {
+	counts := map[rune]int{}
+	length := 0
+
+	for _, r := range text {
+		counts[r]++
+		length++
+	}
+
+	var entropy float64
+	for _, count := range counts {
+		p := float64(count) / float64(length)
+		entropy -= p * math.Log2(p)
+	}
+
+	return entropy
+}
+
+// Replaces the secrets found by the detectors with placeholders such as
+// `[REDACTED:aws-access-key]`. If secrets overlap, the one that starts first
+// (or, if they start at the same position, the one found by the earlier
+// detector) is redacted.
+//
+// Returns the redacted documents, along with each redaction. The returned
+// slice is a copy; the original documents are not modified.
+func Redact(docs []Document, detectors []Detector) ([]Document, []Redaction) {
+	redacted := make([]Document, len(docs))
+	copy(redacted, docs)
+
+	var redactions []Redaction
+
+	for i, doc := range redacted {
+		type secret struct {
+			start, end int
+			detector   string
+		}
+
+		var secrets []secret
+
+		for _, detector := range detectors {
+			for _, offsets := range detector.find(doc.Content) {
+				// Custom patterns may match empty strings.
+				if offsets[1] > offsets[0] {
+					secrets = append(secrets, secret{offsets[0], offsets[1], detector.Name})
+				}
+			}
+		}
+
+		if len(secrets) == 0 {
+			continue
+		}
+
+		sort.SliceStable(secrets, func(a, b int) bool {
+			return secrets[a].start < secrets[b].start
+		})
+
+		var content strings.Builder
+		position := 0
+
+		for _, s := range secrets {
+			if s.start < position {
+				continue
+			}
+
+			content.WriteString(doc.Content[position:s.start])
+			content.WriteString(fmt.Sprintf("[REDACTED:%s]", s.detector))
+			position = s.end
+
+			redactions = append(redactions, Redaction{
+				Path:     doc.Path,
+				Line:     strings.Count(doc.Content[:s.start], "\n") + 1,
+				Detector: s.detector,
+			})
+		}
+
+		content.WriteString(doc.Content[position:])
+		redacted[i].Content = content.String()
+	}
+
+	return redacted, redactions
+}
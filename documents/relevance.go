@@ -0,0 +1,232 @@
+package documents
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// BM25 parameters: k1 controls term frequency saturation and b controls how
+// strongly scores are normalized by document length. These are the commonly
+// used defaults.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Terms found in a document’s path are a strong signal of relevance (e.g. a
+// question about “persistence” and a file named `persistence/client.go`), so
+// they are counted as if they appeared this many times in the content.
+const pathTermWeight = 3
+
+// Common English words that carry no information about relevance. Questions
+// asked by the user are full of them.
+var stopWords = map[string]bool{
+	"a": true, "about": true, "an": true, "and": true, "are": true, "as": true,
+	"at": true, "be": true, "by": true, "can": true, "could": true, "do": true,
+	"does": true, "for": true, "from": true, "how": true, "if": true, "in": true,
+	"is": true, "it": true, "its": true, "me": true, "my": true, "of": true,
+	"on": true, "or": true, "our": true, "please": true, "should": true,
+	"that": true, "the": true, "this": true, "to": true, "we": true,
+	"what": true, "when": true, "where": true, "which": true, "who": true,
+	"why": true, "with": true, "would": true, "you": true, "your": true,
+}
+
+// A lexical index of documents for ranking them by relevance to a query, using
+// the Okapi BM25 ranking function over the documents’ paths and contents.
+type Index struct {
+	documents []Document
+	// Term frequencies in each document, in the same order as `documents`.
+	termFrequencies []map[string]int
+	// Length of each document, measured in terms.
+	lengths []int
+	// Average length of the documents, measured in terms.
+	averageLength float64
+	// Number of documents containing each term.
+	documentFrequencies map[string]int
+}
+
+// A document along with its relevance score. The higher the score, the more
+// relevant the document.
+type ScoredDocument struct {
+	Document Document
+	Score    float64
+}
+
+// Builds a BM25 index of the given documents.
+func NewIndex(docs []Document) *Index {
+	index := &Index{
+		documents:           docs,
+		termFrequencies:     make([]map[string]int, len(docs)),
+		lengths:             make([]int, len(docs)),
+		documentFrequencies: make(map[string]int),
+	}
+
+	totalLength := 0
+
+	for i, doc := range docs {
+		frequencies := make(map[string]int)
+
+		for _, term := range tokenize(doc.Content) {
+			frequencies[term]++
+			index.lengths[i]++
+		}
+
+		for _, term := range tokenize(doc.Path) {
+			frequencies[term] += pathTermWeight
+			index.lengths[i] += pathTermWeight
+		}
+
+		for term := range frequencies {
+			index.documentFrequencies[term]++
+		}
+
+		index.termFrequencies[i] = frequencies
+		totalLength += index.lengths[i]
+	}
+
+	if len(docs) > 0 {
+		index.averageLength = float64(totalLength) / float64(len(docs))
+	}
+
+	return index
+}
+
+// Scores every document in the index against the query. The documents are
+// returned sorted by score, most relevant first; documents with equal scores
+// keep their original order.
+func (index *Index) Rank(query string) []ScoredDocument {
+	// Each distinct query term is counted once. The terms are sorted so that
+	// scores are always summed in the same order.
+	var queryTerms []string
+	for _, term := range tokenize(query) {
+		if !slices.Contains(queryTerms, term) {
+			queryTerms = append(queryTerms, term)
+		}
+	}
+	slices.Sort(queryTerms)
+
+	numberOfDocuments := float64(len(index.documents))
+
+	scored := make([]ScoredDocument, len(index.documents))
+
+	for i, doc := range index.documents {
+		score := 0.0
+
+		for _, term := range queryTerms {
+			frequency := float64(index.termFrequencies[i][term])
+			if frequency == 0 {
+				continue
+			}
+
+			// This variant of the inverse document frequency is always positive, even
+			// for terms that appear in most documents.
+			documentFrequency := float64(index.documentFrequencies[term])
+			idf := math.Log(1 + (numberOfDocuments-documentFrequency+0.5)/(documentFrequency+0.5))
+
+			lengthNormalization := 1 - bm25B + bm25B*float64(index.lengths[i])/index.averageLength
+
+			score += idf * frequency * (bm25K1 + 1) / (frequency + bm25K1*lengthNormalization)
+		}
+
+		scored[i] = ScoredDocument{Document: doc, Score: score}
+	}
+
+	sort.SliceStable(scored, func(i, j int) bool {
+		return scored[i].Score > scored[j].Score
+	})
+
+	return scored
+}
+
+// Selects the documents most relevant to the query that fit within the budget.
+//
+// Documents are considered in the order of their relevance, and each one is
+// selected if its cost (as measured by the `cost` function, e.g. in tokens)
+// doesn’t exceed the remaining budget. Less relevant documents are used to
+// fill any remaining space. The selected documents are returned in their
+// original order.
+func SelectRelevant(docs []Document, query string, budget int, cost func(doc Document) int) []Document {
+	ranked := NewIndex(docs).Rank(query)
+
+	selectedPaths := make(map[string]bool)
+	remaining := budget
+
+	for _, candidate := range ranked {
+		if c := cost(candidate.Document); c <= remaining {
+			selectedPaths[candidate.Document.Path] = true
+			remaining -= c
+		}
+	}
+
+	var selected []Document
+
+	for _, doc := range docs {
+		if selectedPaths[doc.Path] {
+			selected = append(selected, doc)
+		}
+	}
+
+	return selected
+}
+
+// Splits text into lowercase terms for indexing. Besides splitting on any
+// character that is not a letter or a digit, identifiers written in camelCase
+// or PascalCase are split into their components, while also being kept whole,
+// so that e.g. `LoadDocuments` matches both “load documents” and
+// “loaddocuments”. Single-character terms and stop words are ignored.
+func tokenize(text string) []string {
+	var terms []string
+
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	addTerm := func(term string) {
+		term = strings.ToLower(term)
+		if len([]rune(term)) > 1 && !stopWords[term] {
+			terms = append(terms, term)
+		}
+	}
+
+	for _, word := range words {
+		parts := splitCamelCase(word)
+
+		if len(parts) > 1 {
+			for _, part := range parts {
+				addTerm(part)
+			}
+		}
+
+		addTerm(word)
+	}
+
+	return terms
+}
+
+// Splits a camelCase or PascalCase identifier into its components, keeping
+// acronyms together, e.g. `parseHTTPRequest` becomes `parse`, `HTTP` and
+// `Request`.
+func splitCamelCase(word string) []string {
+	runes := []rune(word)
+
+	var parts []string
+	start := 0
+
+	for i := 1; i < len(runes); i++ {
+		previous, current := runes[i-1], runes[i]
+
+		lowerToUpper := unicode.IsLower(previous) && unicode.IsUpper(current)
+		endOfAcronym := unicode.IsUpper(previous) && unicode.IsUpper(current) &&
+			i+1 < len(runes) && unicode.IsLower(runes[i+1])
+
+		if lowerToUpper || endOfAcronym {
+			parts = append(parts, string(runes[start:i]))
+			start = i
+		}
+	}
+
+	return append(parts, string(runes[start:]))
+}
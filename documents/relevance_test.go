@@ -0,0 +1,87 @@
+package documents
+
+import (
+	"github.com/malinowskip/pal/testutil"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	testutil.AssertDeepEquals(
+		t,
+		tokenize("func LoadDocuments(projectPath string) // a parseHTTPRequest for the user"),
+		[]string{
+			"func",
+			"load", "documents", "loaddocuments",
+			"project", "path", "projectpath",
+			"string",
+			"parse", "http", "request", "parsehttprequest",
+			"user",
+		},
+	)
+
+	testutil.AssertDeepEquals(t, tokenize("persistence/client_test.go"), []string{
+		"persistence", "client", "test", "go",
+	})
+}
+
+func TestRank(t *testing.T) {
+	docs := []Document{
+		{Path: "README.md", Content: "Pal is a command-line tool. It stores conversations in a database."},
+		{Path: "persistence/client.go", Content: "package persistence\n\nfunc StartClient() {}"},
+		{Path: "config/config.go", Content: "package config\n\ntype Config struct{}"},
+		{Path: "main.go", Content: "package main"},
+	}
+
+	ranked := NewIndex(docs).Rank("How is the database client started?")
+
+	testutil.AssertDeepEquals(t, ranked[0].Document.Path, "persistence/client.go")
+	testutil.AssertDeepEquals(t, ranked[1].Document.Path, "README.md")
+
+	// Irrelevant documents have a score of zero and keep their original order.
+	testutil.AssertDeepEquals(t, ranked[2].Document.Path, "config/config.go")
+	testutil.AssertDeepEquals(t, ranked[2].Score, 0.0)
+	testutil.AssertDeepEquals(t, ranked[3].Document.Path, "main.go")
+
+	t.Run("Path terms are weighted", func(t *testing.T) {
+		ranked := NewIndex(docs).Rank("config")
+		testutil.AssertDeepEquals(t, ranked[0].Document.Path, "config/config.go")
+	})
+
+	t.Run("Empty index", func(t *testing.T) {
+		testutil.AssertLength(t, NewIndex(nil).Rank("hello"), 0)
+	})
+}
+
+func TestSelectRelevant(t *testing.T) {
+	docs := []Document{
+		{Path: "a.md", Content: "apples and oranges"},
+		{Path: "b.md", Content: "bananas, lots of bananas, nothing but bananas"},
+		{Path: "c.md", Content: "bananas"},
+		{Path: "d.md", Content: "cherries"},
+	}
+
+	cost := func(doc Document) int {
+		return len(doc.Content)
+	}
+
+	t.Run("Selects the most relevant documents that fit", func(t *testing.T) {
+		selected := SelectRelevant(docs, "bananas", 20, cost)
+
+		// b.md doesn’t fit, but the remaining budget is filled with less relevant
+		// documents. The original order is preserved.
+		var paths []string
+		for _, doc := range selected {
+			paths = append(paths, doc.Path)
+		}
+
+		testutil.AssertDeepEquals(t, paths, []string{"c.md", "d.md"})
+	})
+
+	t.Run("Selects everything if the budget allows", func(t *testing.T) {
+		testutil.AssertLength(t, SelectRelevant(docs, "bananas", 1000, cost), 4)
+	})
+
+	t.Run("Selects nothing if nothing fits", func(t *testing.T) {
+		testutil.AssertLength(t, SelectRelevant(docs, "bananas", 1, cost), 0)
+	})
+}
@@ -0,0 +1,131 @@
+package documents
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// The functions below let the LLM explore the project on its own, using tool
+// calls, rather than receiving every document up front. They operate on the
+// documents returned by LoadDocuments, so files that are excluded (by the
+// .gitignore files, the `exclude` patterns or the file size limit) can neither
+// be listed nor read.
+
+// Maximum number of matching lines returned by Grep.
+const maxGrepResults = 100
+
+// Renders the paths of the documents as an indented tree, with directories
+// listed before the files they contain, e.g.:
+//
+//	app/
+//	  app.go
+//	main.go
+func FileTree(docs []Document) string {
+	var paths []string
+	for _, doc := range docs {
+		paths = append(paths, filepath.ToSlash(doc.Path))
+	}
+	slices.Sort(paths)
+
+	var output strings.Builder
+
+	// Directories that have already been printed.
+	printed := make(map[string]bool)
+
+	for _, path := range paths {
+		elements := strings.Split(path, "/")
+
+		for depth := range len(elements) - 1 {
+			dir := strings.Join(elements[:depth+1], "/")
+			if !printed[dir] {
+				printed[dir] = true
+				fmt.Fprintf(&output, "%s%s/\n", strings.Repeat("  ", depth), elements[depth])
+			}
+		}
+
+		fmt.Fprintf(&output, "%s%s\n", strings.Repeat("  ", len(elements)-1), elements[len(elements)-1])
+	}
+
+	return output.String()
+}
+
+// Returns the content of the document at the given path.
+func ReadFile(docs []Document, path string) (string, error) {
+	path = filepath.Clean(path)
+
+	for _, doc := range docs {
+		if doc.Path == path {
+			return doc.Content, nil
+		}
+	}
+
+	return "", fmt.Errorf("The file %s does not exist or is excluded from the context.", path)
+}
+
+// Lists the entries of the given directory (relative to the project root),
+// with subdirectories marked by a trailing slash.
+func ListDir(docs []Document, dir string) ([]string, error) {
+	dir = filepath.ToSlash(filepath.Clean(dir))
+
+	var prefix string
+	if dir != "." {
+		prefix = dir + "/"
+	}
+
+	var entries []string
+
+	for _, doc := range docs {
+		rest, found := strings.CutPrefix(filepath.ToSlash(doc.Path), prefix)
+		if !found {
+			continue
+		}
+
+		entry, _, isDir := strings.Cut(rest, "/")
+		if isDir {
+			entry += "/"
+		}
+
+		if !slices.Contains(entries, entry) {
+			entries = append(entries, entry)
+		}
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("The directory %s does not exist or is excluded from the context.", dir)
+	}
+
+	slices.Sort(entries)
+
+	return entries, nil
+}
+
+// Searches the documents for lines matching the regular expression. Matches
+// are formatted as `path:line: content`. At most maxGrepResults matches are
+// returned.
+func Grep(docs []Document, pattern string) ([]string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid regular expression: %v", err)
+	}
+
+	var matches []string
+
+	for _, doc := range docs {
+		for i, line := range strings.Split(doc.Content, "\n") {
+			if !re.MatchString(line) {
+				continue
+			}
+
+			if len(matches) == maxGrepResults {
+				return matches, nil
+			}
+
+			matches = append(matches, fmt.Sprintf("%s:%d: %s", doc.Path, i+1, line))
+		}
+	}
+
+	return matches, nil
+}
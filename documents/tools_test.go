@@ -0,0 +1,65 @@
+package documents
+
+import (
+	"github.com/malinowskip/pal/testutil"
+	"testing"
+)
+
+var toolTestDocuments = []Document{
+	{Path: "main.go", Content: "package main\n\nfunc main() {}"},
+	{Path: "app/app.go", Content: "package app\n\nfunc CreateApp() {}"},
+	{Path: "app/internal/util.go", Content: "package internal"},
+	{Path: "README.md", Content: "# Pal"},
+}
+
+func TestFileTree(t *testing.T) {
+	testutil.AssertDeepEquals(t, FileTree(toolTestDocuments), "README.md\napp/\n  app.go\n  internal/\n    util.go\nmain.go\n")
+}
+
+func TestReadFile(t *testing.T) {
+	content, err := ReadFile(toolTestDocuments, "./app/app.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.AssertDeepEquals(t, content, "package app\n\nfunc CreateApp() {}")
+
+	_, err = ReadFile(toolTestDocuments, ".pal/db.sqlite")
+	if err == nil {
+		t.Error("Expected an error.")
+	}
+}
+
+func TestListDir(t *testing.T) {
+	entries, err := ListDir(toolTestDocuments, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.AssertDeepEquals(t, entries, []string{"README.md", "app/", "main.go"})
+
+	entries, err = ListDir(toolTestDocuments, "app/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.AssertDeepEquals(t, entries, []string{"app.go", "internal/"})
+
+	_, err = ListDir(toolTestDocuments, "ap")
+	if err == nil {
+		t.Error("Expected an error.")
+	}
+}
+
+func TestGrep(t *testing.T) {
+	matches, err := Grep(toolTestDocuments, `^func \w+\(`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.AssertDeepEquals(t, matches, []string{
+		"main.go:3: func main() {}",
+		"app/app.go:3: func CreateApp() {}",
+	})
+
+	_, err = Grep(toolTestDocuments, "(")
+	if err == nil {
+		t.Error("Expected an error.")
+	}
+}
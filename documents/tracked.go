@@ -0,0 +1,164 @@
+package documents
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// Where the files of the project are listed.
+type Source string
+
+const (
+	// Every file in the project directory.
+	SourceFilesystem Source = "filesystem"
+	// Files committed to the git repository containing the project.
+	SourceGitTracked Source = "git-tracked"
+	// Files committed to the git repository or staged for the next commit.
+	SourceGitStaged Source = "git-staged"
+)
+
+// Opens the git repository containing the project, which doesn’t need to be at
+// its root. Besides the repository, it returns the path of the project relative
+// to the root of the repository.
+func openRepository(projectPath string) (*git.Repository, *git.Worktree, string, error) {
+	repo, err := git.PlainOpenWithOptions(projectPath, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("%s is not inside a git repository.", projectPath)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	absProjectPath, err := filepath.Abs(projectPath)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	prefix, err := filepath.Rel(worktree.Filesystem.Root(), absProjectPath)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	return repo, worktree, prefix, nil
+}
+
+// Converts a path reported by git (relative to the root of the repository and
+// separated by slashes) to a path relative to the project directory. Reports
+// false if the path is outside the project directory.
+func projectRelativePath(gitPath string, prefix string) (string, bool) {
+	path := filepath.FromSlash(gitPath)
+
+	if prefix == "." {
+		return path, true
+	}
+
+	if !strings.HasPrefix(path, prefix+string(os.PathSeparator)) {
+		return "", false
+	}
+
+	return strings.TrimPrefix(path, prefix+string(os.PathSeparator)), true
+}
+
+// Returns the relative paths of the files in the project directory that are
+// listed in the git index. Unless staged is true, files that are staged but not
+// yet committed are left out. Submodules are left out as well.
+func trackedFiles(projectPath string, staged bool) ([]string, error) {
+	repo, _, prefix, err := openRepository(projectPath)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := repo.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	// A repository without commits has no HEAD, in which case no file is
+	// committed.
+	var head *object.Tree
+	if !staged {
+		if ref, err := repo.Head(); err == nil {
+			commit, err := repo.CommitObject(ref.Hash())
+			if err != nil {
+				return nil, err
+			}
+			if head, err = commit.Tree(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var paths []string
+
+	for _, entry := range index.Entries {
+		if entry.Mode == filemode.Submodule {
+			continue
+		}
+
+		if !staged {
+			if head == nil {
+				continue
+			}
+			if _, err := head.FindEntry(entry.Name); err != nil {
+				continue
+			}
+		}
+
+		if path, ok := projectRelativePath(entry.Name, prefix); ok {
+			paths = append(paths, path)
+		}
+	}
+
+	return paths, nil
+}
+
+// Returns a function listing the entries of the directories (relative to the
+// root) that contain the given files, sorted by name, as if the directories
+// contained nothing else. Files that don’t exist on disk (e.g. deleted, but not
+// yet staged for removal) are left out.
+func newTrackedDirReader(root string, paths []string) func(relPath string) ([]fs.DirEntry, error) {
+	// Entries of each directory, keyed by its relative path.
+	children := map[string][]fs.DirEntry{}
+	added := map[string]bool{".": true}
+
+	var addEntry func(path string, info fs.FileInfo)
+	addEntry = func(path string, info fs.FileInfo) {
+		added[path] = true
+
+		dir := filepath.Dir(path)
+		children[dir] = append(children[dir], fs.FileInfoToDirEntry(info))
+
+		if added[dir] {
+			return
+		}
+		if dirInfo, err := os.Stat(filepath.Join(root, dir)); err == nil {
+			addEntry(dir, dirInfo)
+		}
+	}
+
+	for _, path := range paths {
+		if info, err := os.Lstat(filepath.Join(root, path)); err == nil && !added[path] {
+			addEntry(path, info)
+		}
+	}
+
+	for _, entries := range children {
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Name() < entries[j].Name()
+		})
+	}
+
+	return func(relPath string) ([]fs.DirEntry, error) {
+		return children[relPath], nil
+	}
+}
@@ -0,0 +1,108 @@
+package documents
+
+import (
+	"github.com/malinowskip/pal/testutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	git "gopkg.in/src-d/go-git.v4"
+)
+
+func TestDocumentLoadingFromGitIndex(t *testing.T) {
+	repoPath := t.TempDir()
+
+	repo, err := git.PlainInit(repoPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commitFiles(t, repo, map[string]string{
+		".gitignore":    "*.log\n",
+		"main.go":       "package main\n",
+		"docs/guide.md": "# Guide\n",
+		"notes.xml":     "<notes />",
+		"big.txt":       strings.Repeat("a", 200),
+		"obsolete.go":   "package main\n",
+		// Tracked files are loaded even if they are ignored.
+		"debug.log": "Tracked anyway",
+	})
+
+	// A file staged for the next commit, but not committed yet.
+	if err := os.WriteFile(filepath.Join(repoPath, "staged.go"), []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := worktree.Add("staged.go"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Untracked files and a tracked file deleted from disk.
+	testutil.WriteTestFiles(t, repoPath, map[string]string{
+		"scratch.txt":    "Scratch",
+		"tmp/scratch.go": "Scratch",
+	})
+	if err := os.Remove(filepath.Join(repoPath, "obsolete.go")); err != nil {
+		t.Fatal(err)
+	}
+
+	paths := func(docs []Document) []string {
+		var paths []string
+		for _, doc := range docs {
+			paths = append(paths, doc.Path)
+		}
+		return paths
+	}
+
+	t.Run("Committed files", func(t *testing.T) {
+		docs, skipped, err := LoadDocuments(repoPath, SourceGitTracked, nil, []string{"*.xml"}, Selection{}, 100, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testutil.AssertDeepEquals(t, paths(docs), []string{
+			".gitignore",
+			"debug.log",
+			filepath.Join("docs", "guide.md"),
+			"main.go",
+		})
+
+		testutil.AssertDeepEquals(t, skipped, []SkippedEntry{
+			{Path: "big.txt", Reason: SkipReasonMaxFileSize, Size: 200},
+			{Path: "notes.xml", Reason: SkipReasonExclude, Pattern: "*.xml"},
+		})
+	})
+
+	t.Run("Committed and staged files", func(t *testing.T) {
+		docs, _, err := LoadDocuments(repoPath, SourceGitStaged, nil, []string{"*.xml"}, Selection{}, 100, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testutil.AssertContains(t, docs, func(doc Document) bool {
+			return doc.Path == "staged.go"
+		})
+		testutil.AssertNotContains(t, docs, func(doc Document) bool {
+			return strings.Contains(doc.Path, "scratch")
+		})
+	})
+
+	t.Run("Project in a subdirectory", func(t *testing.T) {
+		docs, _, err := LoadDocuments(filepath.Join(repoPath, "docs"), SourceGitTracked, nil, nil, Selection{}, 100, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testutil.AssertDeepEquals(t, paths(docs), []string{"guide.md"})
+	})
+
+	t.Run("Fails outside of a repository", func(t *testing.T) {
+		if _, _, err := LoadDocuments(t.TempDir(), SourceGitTracked, nil, nil, Selection{}, 100, nil); err == nil {
+			t.Error("Expected an error.")
+		}
+	})
+}
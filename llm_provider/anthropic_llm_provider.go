@@ -2,46 +2,115 @@ package llm_provider
 
 import (
 	"context"
+	"encoding/json"
+	"github.com/malinowskip/pal/config"
+	"net/http"
 
 	"github.com/liushuangls/go-anthropic/v2"
 )
 
+// Anthropic requires the maximum length of the reply to be specified, so this
+// limit is used unless the user sets a different one.
+const defaultAnthropicMaxTokens = 4096
+
 type AnthropicLLMProvider struct {
 	apiKey string
 	model  string
+	// Parameters of the generated replies.
+	generation GenerationParams
+	// URL of the API, if not the default one (e.g. in tests).
+	baseUrl string
 }
 
 // Quality-of-life function to support short-hand model names for Anthropic.
 func resolveAnthropicModel(input string) string {
-	if input == "opus" {
-		return "claude-3-opus-latest"
-	}
-
-	if input == "sonnet" {
-		return "claude-3-5-sonnet-latest"
-	}
-
-	if input == "haiku" {
-		return "claude-3-5-haiku-latest"
+	if model, ok := config.AnthropicModelAliases[input]; ok {
+		return model
 	}
 
 	return input
 }
 
-func NewAnthropicLLMProvider(apiKey string, model string) *AnthropicLLMProvider {
+func NewAnthropicLLMProvider(apiKey string, model string, generation GenerationParams) *AnthropicLLMProvider {
 	return &AnthropicLLMProvider{
-		apiKey: apiKey,
-		model:  resolveAnthropicModel(model),
+		apiKey:     apiKey,
+		model:      resolveAnthropicModel(model),
+		generation: generation,
 	}
 }
 
 func (p *AnthropicLLMProvider) GetCompletion(
+	ctx context.Context,
+	fullSystemMessage string,
+	messages []Message,
+	handleTokens func(tokens string) error,
+) (Completion, error) {
+	response, err := p.streamReply(ctx, p.newRequest(fullSystemMessage, messages, nil), handleTokens)
+
+	return fromAnthropicResponse(response), err
+}
+
+func (p *AnthropicLLMProvider) GetCompletionWithTools(
+	ctx context.Context,
 	fullSystemMessage string,
 	messages []Message,
+	tools []Tool,
 	handleTokens func(tokens string) error,
-) error {
-	client := anthropic.NewClient(p.apiKey, anthropic.WithBetaVersion(anthropic.BetaPromptCaching20240731))
+	handleMessage func(message Message) error,
+) (Completion, error) {
+	request := p.newRequest(fullSystemMessage, messages, tools)
+
+	for turn := 0; turn < maxToolCallTurns; turn++ {
+		response, err := p.streamReply(ctx, request, handleTokens)
+		if err != nil {
+			return fromAnthropicResponse(response), err
+		}
+
+		reply := fromAnthropicMessage(response.Content)
+		reply.Usage = fromAnthropicUsage(response.Usage)
+		if len(reply.ToolCalls) == 0 {
+			return fromAnthropicResponse(response), nil
+		}
+
+		if err = handleMessage(reply); err != nil {
+			return Completion{}, err
+		}
+
+		// The results of all tool calls are sent back in a single user message.
+		results := anthropic.Message{Role: anthropic.RoleUser}
+
+		for _, call := range reply.ToolCalls {
+			result := runToolCall(tools, call)
+
+			if err = handleMessage(result); err != nil {
+				return Completion{}, err
+			}
+			results.Content = append(
+				results.Content,
+				anthropic.NewToolResultMessageContent(call.Id, result.Content, result.IsError),
+			)
+		}
 
+		request.Messages = append(request.Messages, toAnthropicMessage(reply), results)
+	}
+
+	return Completion{}, errTooManyToolCallTurns()
+}
+
+// The API client marks the request as streamed when sending it, so the returned
+// request is marked as such as well.
+func (p *AnthropicLLMProvider) BuildRequest(fullSystemMessage string, messages []Message, tools []Tool) any {
+	request := p.newRequest(fullSystemMessage, messages, tools).MessagesRequest
+	request.Stream = true
+
+	return request
+}
+
+func (p *AnthropicLLMProvider) newRequest(
+	fullSystemMessage string,
+	messages []Message,
+	tools []Tool,
+) anthropic.MessagesStreamRequest {
 	request := anthropic.MessagesStreamRequest{
 		MessagesRequest: anthropic.MessagesRequest{
 			Model: anthropic.Model(p.model),
@@ -54,28 +123,190 @@ func (p *AnthropicLLMProvider) GetCompletion(
 					},
 				},
 			},
-			MaxTokens: 1000,
-		},
-		OnContentBlockDelta: func(data anthropic.MessagesEventContentBlockDeltaData) {
-			handleTokens(*data.Delta.Text)
+			MaxTokens:     defaultAnthropicMaxTokens,
+			StopSequences: p.generation.Stop,
 		},
 	}
 
+	if p.generation.MaxTokens > 0 {
+		request.MaxTokens = p.generation.MaxTokens
+	}
+
+	if p.generation.Temperature != nil {
+		temperature := float32(*p.generation.Temperature)
+		request.Temperature = &temperature
+	}
+
+	if p.generation.TopP != nil {
+		topP := float32(*p.generation.TopP)
+		request.TopP = &topP
+	}
+
 	for _, message := range messages {
-		if message.Role == "user" {
-			request.MessagesRequest.Messages = append(
-				request.MessagesRequest.Messages,
-				anthropic.NewUserTextMessage(message.Content),
-			)
-		} else {
-			request.MessagesRequest.Messages = append(
-				request.MessagesRequest.Messages,
-				anthropic.NewAssistantTextMessage(message.Content),
-			)
+		switch {
+		case message.Role == "tool":
+			// Anthropic expects tool results in a user message following the tool
+			// calls. Results of calls made in the same turn must share a single
+			// message.
+			result := anthropic.NewToolResultMessageContent(message.ToolCallId, message.Content, message.IsError)
+			previous := len(request.Messages) - 1
+
+			if previous >= 0 && isToolResultsMessage(request.Messages[previous]) {
+				request.Messages[previous].Content = append(request.Messages[previous].Content, result)
+			} else {
+				request.Messages = append(request.Messages, anthropic.Message{
+					Role:    anthropic.RoleUser,
+					Content: []anthropic.MessageContent{result},
+				})
+			}
+		case message.Role == "user":
+			request.Messages = append(request.Messages, anthropic.NewUserTextMessage(message.Content))
+		default:
+			request.Messages = append(request.Messages, toAnthropicMessage(message))
 		}
 	}
 
-	_, err := client.CreateMessagesStream(context.Background(), request)
+	for _, tool := range tools {
+		request.Tools = append(request.Tools, anthropic.ToolDefinition{
+			Name:        tool.Name,
+			Description: tool.Description,
+			InputSchema: tool.Parameters,
+		})
+	}
+
+	return request
+}
+
+// Sends the request and streams the text of the reply through handleTokens.
+// Returns the complete response, which includes any tool calls, as well as the
+// usage and the stop reason reported by the `message_start` and
+// `message_delta` events.
+func (p *AnthropicLLMProvider) streamReply(
+	ctx context.Context,
+	request anthropic.MessagesStreamRequest,
+	handleTokens func(tokens string) error,
+) (anthropic.MessagesResponse, error) {
+	// Keeps track of the responses of the API, so that failed requests can be
+	// retried.
+	recorder := &responseRecorder{base: http.DefaultTransport}
+
+	options := []anthropic.ClientOption{
+		anthropic.WithBetaVersion(anthropic.BetaPromptCaching20240731),
+		anthropic.WithHTTPClient(&http.Client{Transport: recorder}),
+	}
+	if p.baseUrl != "" {
+		options = append(options, anthropic.WithBaseURL(p.baseUrl))
+	}
+
+	client := anthropic.NewClient(p.apiKey, options...)
+
+	// If handling the tokens fails (e.g. they can’t be saved), the stream is
+	// cancelled and the first error is returned.
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var handleErr error
+
+	request.OnContentBlockDelta = func(data anthropic.MessagesEventContentBlockDeltaData) {
+		// Deltas of tool calls carry JSON fragments instead of text.
+		if data.Delta.Text == nil || handleErr != nil {
+			return
+		}
+
+		if handleErr = handleTokens(*data.Delta.Text); handleErr != nil {
+			cancel()
+		}
+	}
+
+	response, err := client.CreateMessagesStream(streamCtx, request)
+
+	if handleErr != nil {
+		return response, handleErr
+	}
+
+	// An aborted request is reported as such, rather than as an error of the API
+	// client.
+	if ctx.Err() != nil {
+		return response, ctx.Err()
+	}
+
+	return response, recorder.wrapError(err)
+}
+
+// Converts an assistant message, including any tool calls, into the format
+// expected by Anthropic.
+func toAnthropicMessage(message Message) anthropic.Message {
+	result := anthropic.Message{Role: anthropic.RoleAssistant}
+
+	// Anthropic rejects empty text blocks, which precede tool calls if the model
+	// didn’t say anything before calling a tool.
+	if message.Content != "" || len(message.ToolCalls) == 0 {
+		result.Content = append(result.Content, anthropic.NewTextMessageContent(message.Content))
+	}
+
+	for _, call := range message.ToolCalls {
+		arguments := call.Arguments
+		if arguments == "" {
+			arguments = "{}"
+		}
+
+		result.Content = append(
+			result.Content,
+			anthropic.NewToolUseMessageContent(call.Id, call.Name, json.RawMessage(arguments)),
+		)
+	}
+
+	return result
+}
+
+// Converts the content blocks of a reply into an assistant message.
+func fromAnthropicMessage(content []anthropic.MessageContent) Message {
+	message := Message{Role: "assistant"}
+
+	for _, block := range content {
+		switch block.Type {
+		case anthropic.MessagesContentTypeText:
+			if block.Text != nil {
+				message.Content += *block.Text
+			}
+		case anthropic.MessagesContentTypeToolUse:
+			message.ToolCalls = append(message.ToolCalls, ToolCall{
+				Id:        block.MessageContentToolUse.ID,
+				Name:      block.MessageContentToolUse.Name,
+				Arguments: string(block.MessageContentToolUse.Input),
+			})
+		}
+	}
+
+	return message
+}
+
+func fromAnthropicResponse(response anthropic.MessagesResponse) Completion {
+	completion := Completion{Usage: fromAnthropicUsage(response.Usage)}
+
+	// Anthropic’s stop reasons are used as they are, except that generating a
+	// stop sequence is a natural end of the reply.
+	switch response.StopReason {
+	case anthropic.MessagesStopReasonStopSequence:
+		completion.StopReason = StopReasonEndTurn
+	default:
+		completion.StopReason = StopReason(response.StopReason)
+	}
+
+	return completion
+}
+
+func fromAnthropicUsage(usage anthropic.MessagesUsage) Usage {
+	return Usage{
+		InputTokens:      usage.InputTokens,
+		OutputTokens:     usage.OutputTokens,
+		CacheReadTokens:  usage.CacheReadInputTokens,
+		CacheWriteTokens: usage.CacheCreationInputTokens,
+	}
+}
 
-	return err
+func isToolResultsMessage(message anthropic.Message) bool {
+	return message.Role == anthropic.RoleUser &&
+		len(message.Content) > 0 &&
+		message.Content[0].Type == anthropic.MessagesContentTypeToolResult
 }
@@ -1,13 +1,22 @@
 package llm_provider
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/malinowskip/pal/testutil"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+
+	"github.com/liushuangls/go-anthropic/v2"
 )
 
 func TestAnthropicLLMProviderCreation(t *testing.T) {
 	apikey := "key"
 	model := "claude-3-5-haiku-latest"
-	provider := NewAnthropicLLMProvider(apikey, model)
+	provider := NewAnthropicLLMProvider(apikey, model, GenerationParams{})
 
 	if provider.apiKey != apikey {
 		t.Error("The provider is missing the api key")
@@ -33,3 +42,149 @@ func TestAnthropicModelResolution(t *testing.T) {
 		}
 	}
 }
+
+func TestAnthropicRequestWithToolCalls(t *testing.T) {
+	provider := NewAnthropicLLMProvider("key", "haiku", GenerationParams{})
+
+	request := provider.newRequest("System", []Message{
+		{Role: "user", Content: "Compare a.go and b.go."},
+		{
+			Role: "assistant",
+			ToolCalls: []ToolCall{
+				{Id: "call_1", Name: "read_file", Arguments: `{"path":"a.go"}`},
+				{Id: "call_2", Name: "read_file", Arguments: `{"path":"b.go"}`},
+			},
+		},
+		{Role: "tool", Content: "package a", ToolCallId: "call_1"},
+		{Role: "tool", Content: "No such file: b.go.", ToolCallId: "call_2", IsError: true},
+		{Role: "assistant", Content: "They differ."},
+	}, nil)
+
+	messages := request.Messages
+	testutil.AssertLength(t, messages, 4)
+
+	// The assistant message consists of tool calls only, without an empty text
+	// block.
+	testutil.AssertLength(t, messages[1].Content, 2)
+	testutil.AssertDeepEquals(t, messages[1].Content[0].Type, anthropic.MessagesContentTypeToolUse)
+	testutil.AssertDeepEquals(t, messages[1].Content[1].MessageContentToolUse.ID, "call_2")
+
+	// Both results are sent in a single user message.
+	testutil.AssertDeepEquals(t, messages[2].Role, anthropic.RoleUser)
+	testutil.AssertLength(t, messages[2].Content, 2)
+	testutil.AssertDeepEquals(t, *messages[2].Content[1].MessageContentToolResult.ToolUseID, "call_2")
+
+	// Failed calls are replayed as errors.
+	testutil.AssertDeepEquals(t, *messages[2].Content[0].MessageContentToolResult.IsError, false)
+	testutil.AssertDeepEquals(t, *messages[2].Content[1].MessageContentToolResult.IsError, true)
+
+	testutil.AssertDeepEquals(t, messages[3].Role, anthropic.RoleAssistant)
+}
+
+func TestAnthropicGenerationParams(t *testing.T) {
+	t.Run("Default", func(t *testing.T) {
+		request := NewAnthropicLLMProvider("key", "haiku", GenerationParams{}).newRequest("System", nil, nil)
+
+		testutil.AssertDeepEquals(t, request.MaxTokens, defaultAnthropicMaxTokens)
+		testutil.AssertDeepEquals(t, request.Temperature, (*float32)(nil))
+		testutil.AssertDeepEquals(t, request.TopP, (*float32)(nil))
+	})
+
+	t.Run("Custom", func(t *testing.T) {
+		temperature := 0.0
+		provider := NewAnthropicLLMProvider("key", "haiku", GenerationParams{
+			MaxTokens:   8000,
+			Temperature: &temperature,
+			Stop:        []string{"END"},
+		})
+		request := provider.newRequest("System", nil, nil)
+
+		testutil.AssertDeepEquals(t, request.MaxTokens, 8000)
+		testutil.AssertDeepEquals(t, *request.Temperature, float32(0))
+		testutil.AssertDeepEquals(t, request.StopSequences, []string{"END"})
+	})
+}
+
+func TestAnthropicBuildRequest(t *testing.T) {
+	provider := NewAnthropicLLMProvider("key", "haiku", GenerationParams{})
+
+	tools := []Tool{{Name: "read_file", Description: "Reads a file.", Parameters: map[string]any{"type": "object"}}}
+	request := provider.BuildRequest("System", []Message{{Role: "user", Content: "Hi"}}, tools)
+
+	encoded, err := json.Marshal(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body map[string]any
+	if err = json.Unmarshal(encoded, &body); err != nil {
+		t.Fatal(err)
+	}
+
+	// The system message is marked for caching, as in the request that is sent.
+	testutil.AssertDeepEquals(t, body["system"], []any{map[string]any{
+		"type":          "text",
+		"text":          "System",
+		"cache_control": map[string]any{"type": "ephemeral"},
+	}})
+	testutil.AssertDeepEquals(t, body["model"], "claude-3-5-haiku-latest")
+	testutil.AssertDeepEquals(t, body["stream"], true)
+	testutil.AssertLength(t, body["messages"].([]any), 1)
+	testutil.AssertDeepEquals(t, body["tools"].([]any)[0].(map[string]any)["name"], "read_file")
+}
+
+func TestAnthropicUsageConversion(t *testing.T) {
+	usage := fromAnthropicUsage(anthropic.MessagesUsage{
+		InputTokens:              12,
+		OutputTokens:             40,
+		CacheCreationInputTokens: 2048,
+		CacheReadInputTokens:     1024,
+	})
+
+	testutil.AssertDeepEquals(t, usage, Usage{
+		InputTokens:      12,
+		OutputTokens:     40,
+		CacheReadTokens:  1024,
+		CacheWriteTokens: 2048,
+	})
+}
+
+func TestAnthropicStreamReportsTokenHandlerErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+
+		events := []string{
+			`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","content":[],"model":"claude-3-5-haiku-latest","usage":{"input_tokens":10,"output_tokens":1}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":", world!"}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":4}}`,
+			`{"type":"message_stop"}`,
+		}
+
+		for _, event := range events {
+			var data struct{ Type string }
+			json.Unmarshal([]byte(event), &data)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", data.Type, event)
+		}
+	}))
+	defer server.Close()
+
+	provider := NewAnthropicLLMProvider("key", "haiku", GenerationParams{})
+	provider.baseUrl = server.URL
+
+	handlerErr := fmt.Errorf("The reply could not be saved.")
+	calls := 0
+
+	_, err := provider.GetCompletion(context.Background(), "System", []Message{{Role: "user", Content: "Hi"}}, func(tokens string) error {
+		calls++
+		return handlerErr
+	})
+
+	if !errors.Is(err, handlerErr) {
+		t.Errorf("The error of the token handler should be returned (error: %v).", err)
+	}
+
+	testutil.AssertDeepEquals(t, calls, 1)
+}
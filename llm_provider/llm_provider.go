@@ -1,32 +1,118 @@
 package llm_provider
 
 import (
-	"os"
+	"context"
+	"fmt"
 	"github.com/malinowskip/pal/config"
+	"os"
+	"time"
 )
 
 // A provider should act as a proxy to some LLM provider, such as "openai",
-// "anthropic" or different. Its only task is to implement the GetCompletion
+// "anthropic", "ollama" or different. Its only task is to implement the GetCompletion
 // function.
 type LLMProvider interface {
 	// Get a completion from an LLM. The function should pass the system message
-	// (already including the context) to the LLM.
+	// (already including the context) to the LLM. If the context is canceled
+	// (e.g. the user pressed Ctrl-C or the request timed out), the request
+	// should be aborted and the context’s error returned.
+	//
+	// Returns the number of tokens used by the request and the reason why the
+	// model stopped generating, as reported by the API.
 	GetCompletion(
+		ctx context.Context,
 		fullSystemMessage string,
 		messages []Message,
 		handleTokens func(tokens string) error,
-	) error
+	) (Completion, error)
+}
+
+// A provider that can show the request it would send to the API, e.g. for a dry
+// run.
+type RequestBuilder interface {
+	// Returns the body of the first request that GetCompletion (or, if tools are
+	// given, GetCompletionWithTools) would send, ready to be encoded as JSON.
+	// Returns nil if the request can’t be built.
+	BuildRequest(fullSystemMessage string, messages []Message, tools []Tool) any
+}
+
+// Parameters of the generated reply. Unset parameters are left to the API’s
+// defaults.
+type GenerationParams struct {
+	// Maximum number of tokens in the reply. 0 if not set.
+	MaxTokens int
+	// Sampling temperature. Nil if not set.
+	Temperature *float64
+	// Nucleus sampling probability. Nil if not set.
+	TopP *float64
+	// Sequences that end the reply when generated.
+	Stop []string
+}
+
+// Information on a completed request, as reported by the API.
+type Completion struct {
+	// Zero if the API doesn’t report usage.
+	Usage Usage
+	// Why the model stopped generating. Empty if the API doesn’t report it.
+	StopReason StopReason
+}
+
+// Reason why the model stopped generating. The providers’ own reasons are
+// translated into these, except for uncommon ones, which are passed through.
+type StopReason string
+
+const (
+	// The model finished its reply naturally or generated a stop sequence.
+	StopReasonEndTurn StopReason = "end_turn"
+	// The reply was cut off, because it reached the maximum number of tokens.
+	StopReasonMaxTokens StopReason = "max_tokens"
+	// The model called a tool.
+	StopReasonToolUse StopReason = "tool_use"
+)
+
+// Number of tokens used by a request. Input tokens that were read from or
+// written to the prompt cache are counted separately (and aren’t included in
+// InputTokens), because they are priced differently.
+type Usage struct {
+	InputTokens      int
+	OutputTokens     int
+	CacheReadTokens  int
+	CacheWriteTokens int
+}
+
+// Returns the sum of both usages.
+func (u Usage) Add(other Usage) Usage {
+	return Usage{
+		InputTokens:      u.InputTokens + other.InputTokens,
+		OutputTokens:     u.OutputTokens + other.OutputTokens,
+		CacheReadTokens:  u.CacheReadTokens + other.CacheReadTokens,
+		CacheWriteTokens: u.CacheWriteTokens + other.CacheWriteTokens,
+	}
 }
 
 type Message struct {
+	// Either `user`, `assistant` or `tool` (the result of a tool call).
 	Role    string
 	Content string
+	// Tools called by the model in an assistant message.
+	ToolCalls []ToolCall
+	// In a `tool` message, the id of the call that this message is the result of.
+	ToolCallId string
+	// In a `tool` message, whether the call failed (e.g. an unknown tool was
+	// called), in which case the content is the error.
+	IsError bool
+	// In an assistant message passed to handleMessage, the tokens used by the
+	// request that produced it.
+	Usage Usage
 }
 
 func ResolveFromConfig(conf *config.Config) (LLMProvider, error) {
 	var llmProvider LLMProvider
 	var err error
 
+	// Shared parameters, overridden by those of the selected provider.
+	generation := GenerationParams(conf.ResolveGeneration())
+
 	if conf.Provider == "testing" {
 		llmProvider = &TestLLMProvider{}
 	}
@@ -34,14 +120,79 @@ func ResolveFromConfig(conf *config.Config) (LLMProvider, error) {
 	if conf.Provider == "openai" {
 		apiKey := os.Getenv(conf.Openai.ApiKeyEnv)
 		model := conf.Openai.Model
-		llmProvider = NewOpenAILLMProvider(apiKey, model)
+		llmProvider = NewOpenAILLMProvider(
+			apiKey,
+			model,
+			WithBaseUrl(conf.Openai.BaseUrl),
+			WithHeaders(conf.Openai.Headers),
+			WithGeneration(generation),
+		)
 	}
 
 	if conf.Provider == "anthropic" {
 		apiKey := os.Getenv(conf.Anthropic.ApiKeyEnv)
 		model := conf.Anthropic.Model
-		llmProvider = NewAnthropicLLMProvider(apiKey, model)
+		llmProvider = NewAnthropicLLMProvider(apiKey, model, generation)
+	}
+
+	if conf.Provider == "ollama" {
+		llmProvider = NewOllamaLLMProvider(
+			conf.Ollama.Host,
+			conf.Ollama.Model,
+			conf.Ollama.KeepAlive,
+			conf.Ollama.NumCtx,
+			generation,
+		)
+	}
+
+	// Requests that fail because of rate limits or transient errors are retried.
+	// The delay has already been validated along with the rest of the config.
+	if llmProvider != nil {
+		baseDelay, _ := time.ParseDuration(conf.Retry.BaseDelay)
+
+		llmProvider = NewRetryingLLMProvider(
+			llmProvider,
+			conf.Retry.MaxAttempts,
+			baseDelay,
+			func(err error, delay time.Duration) {
+				fmt.Fprintln(os.Stderr, DescribeRetry(err, delay))
+			},
+		)
 	}
 
 	return llmProvider, err
 }
+
+// Checks that the API key of the selected provider is set. Only commands that
+// send requests need it, so it isn’t checked by ResolveFromConfig (e.g. a dry
+// run builds the request without sending it).
+func CheckApiKey(conf *config.Config) error {
+	switch conf.Provider {
+	case "openai":
+		// OpenAI-compatible servers may not require an API key.
+		if os.Getenv(conf.Openai.ApiKeyEnv) == "" && conf.Openai.BaseUrl == "" {
+			return fmt.Errorf(`The %s environment variable, named in the "%s" configuration value, must contain the OpenAI API key.`, conf.Openai.ApiKeyEnv, "openai.api-key-env")
+		}
+	case "anthropic":
+		if os.Getenv(conf.Anthropic.ApiKeyEnv) == "" {
+			return fmt.Errorf(`The %s environment variable, named in the "%s" configuration value, must contain the Anthropic API key.`, conf.Anthropic.ApiKeyEnv, "anthropic.api-key-env")
+		}
+	}
+
+	return nil
+}
+
+// Returns the name of the model selected in the config, as sent to the API
+// (i.e. with short-hand names resolved).
+func ResolveModel(conf *config.Config) string {
+	switch conf.Provider {
+	case "openai":
+		return resolveOpenaiModel(conf.Openai.Model)
+	case "anthropic":
+		return resolveAnthropicModel(conf.Anthropic.Model)
+	case "ollama":
+		return conf.Ollama.Model
+	default:
+		return conf.Provider
+	}
+}
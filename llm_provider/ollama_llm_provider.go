@@ -0,0 +1,240 @@
+package llm_provider
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Talks to a local (or remote) Ollama server using its native chat API, which
+// streams the reply as newline-delimited JSON objects.
+type OllamaLLMProvider struct {
+	host  string
+	model string
+	// How long the model stays loaded after the request. Either a duration
+	// string, such as `10m`, or a number of seconds. Empty to use the server’s
+	// default.
+	keepAlive string
+	// Size of the context window. 0 to use the model’s default.
+	numCtx int
+	// Parameters of the generated replies.
+	generation GenerationParams
+}
+
+// Body of a request to the `/api/chat` endpoint.
+type ollamaChatRequest struct {
+	Model     string              `json:"model"`
+	Messages  []ollamaChatMessage `json:"messages"`
+	Stream    bool                `json:"stream"`
+	KeepAlive any                 `json:"keep_alive,omitempty"`
+	Options   map[string]any      `json:"options,omitempty"`
+}
+
+type ollamaChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// A single line of the streamed reply. The last line reports the number of
+// tokens in the prompt and in the reply, and why the reply ended.
+type ollamaChatResponse struct {
+	Message         ollamaChatMessage `json:"message"`
+	Done            bool              `json:"done"`
+	Error           string            `json:"error"`
+	DoneReason      string            `json:"done_reason"`
+	PromptEvalCount int               `json:"prompt_eval_count"`
+	EvalCount       int               `json:"eval_count"`
+}
+
+func NewOllamaLLMProvider(
+	host string,
+	model string,
+	keepAlive string,
+	numCtx int,
+	generation GenerationParams,
+) *OllamaLLMProvider {
+	return &OllamaLLMProvider{
+		host:       strings.TrimSuffix(host, "/"),
+		model:      model,
+		keepAlive:  keepAlive,
+		numCtx:     numCtx,
+		generation: generation,
+	}
+}
+
+func (p *OllamaLLMProvider) GetCompletion(
+	ctx context.Context,
+	fullSystemMessage string,
+	messages []Message,
+	handleTokens func(tokens string) error,
+) (Completion, error) {
+	body, err := json.Marshal(p.buildRequest(fullSystemMessage, messages))
+	if err != nil {
+		return Completion{}, err
+	}
+
+	request, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		p.host+"/api/chat",
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		return Completion{}, err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		if ctx.Err() != nil {
+			return Completion{}, ctx.Err()
+		}
+		return Completion{}, fmt.Errorf("Unsuccessful request to the Ollama API: %v", err)
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		statusErr := &StatusError{
+			StatusCode: response.StatusCode,
+			RetryAfter: parseRetryAfter(response.Header.Get("Retry-After"), time.Now()),
+			Err:        fmt.Errorf("Unsuccessful request to the Ollama API: %s", response.Status),
+		}
+
+		// Ollama describes errors using a JSON object with an `error` field.
+		var errorResponse ollamaChatResponse
+		responseBody, _ := io.ReadAll(response.Body)
+		if json.Unmarshal(responseBody, &errorResponse) == nil && errorResponse.Error != "" {
+			statusErr.Err = fmt.Errorf("Unsuccessful request to the Ollama API: %s", errorResponse.Error)
+		}
+
+		return Completion{}, statusErr
+	}
+
+	scanner := bufio.NewScanner(response.Body)
+	// A single line contains a small batch of tokens, but error messages might be
+	// longer than the default limit.
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var chunk ollamaChatResponse
+		if err = json.Unmarshal(line, &chunk); err != nil {
+			return Completion{}, fmt.Errorf("Invalid response from the Ollama API: %v", err)
+		}
+
+		if chunk.Error != "" {
+			return Completion{}, fmt.Errorf("Stream error: %s", chunk.Error)
+		}
+
+		if chunk.Message.Content != "" {
+			if err = handleTokens(chunk.Message.Content); err != nil {
+				return Completion{}, err
+			}
+		}
+
+		if chunk.Done {
+			return Completion{
+				Usage:      Usage{InputTokens: chunk.PromptEvalCount, OutputTokens: chunk.EvalCount},
+				StopReason: fromOllamaDoneReason(chunk.DoneReason),
+			}, nil
+		}
+	}
+
+	// Reading the body fails if the request is aborted.
+	if ctx.Err() != nil {
+		return Completion{}, ctx.Err()
+	}
+
+	if err = scanner.Err(); err != nil {
+		return Completion{}, err
+	}
+
+	return Completion{}, fmt.Errorf("The Ollama API closed the stream before the reply was complete.")
+}
+
+// Ollama’s native API is used without tools, so they are ignored.
+func (p *OllamaLLMProvider) BuildRequest(fullSystemMessage string, messages []Message, tools []Tool) any {
+	return p.buildRequest(fullSystemMessage, messages)
+}
+
+func (p *OllamaLLMProvider) buildRequest(
+	fullSystemMessage string,
+	messages []Message,
+) ollamaChatRequest {
+	request := ollamaChatRequest{
+		Model:  p.model,
+		Stream: true,
+		Messages: []ollamaChatMessage{
+			{Role: "system", Content: fullSystemMessage},
+		},
+	}
+
+	for _, m := range messages {
+		request.Messages = append(request.Messages, ollamaChatMessage{Role: m.Role, Content: m.Content})
+	}
+
+	// The API accepts either a duration string or a number of seconds, but a
+	// negative number (keep the model loaded indefinitely) can only be passed as
+	// a number.
+	if p.keepAlive != "" {
+		if seconds, err := strconv.Atoi(p.keepAlive); err == nil {
+			request.KeepAlive = seconds
+		} else {
+			request.KeepAlive = p.keepAlive
+		}
+	}
+
+	// Ollama calls the options of the model (including the generation
+	// parameters) differently than other providers.
+	options := map[string]any{}
+
+	if p.numCtx > 0 {
+		options["num_ctx"] = p.numCtx
+	}
+
+	if p.generation.MaxTokens > 0 {
+		options["num_predict"] = p.generation.MaxTokens
+	}
+
+	if p.generation.Temperature != nil {
+		options["temperature"] = *p.generation.Temperature
+	}
+
+	if p.generation.TopP != nil {
+		options["top_p"] = *p.generation.TopP
+	}
+
+	if len(p.generation.Stop) > 0 {
+		options["stop"] = p.generation.Stop
+	}
+
+	if len(options) > 0 {
+		request.Options = options
+	}
+
+	return request
+}
+
+func fromOllamaDoneReason(reason string) StopReason {
+	switch reason {
+	case "stop":
+		return StopReasonEndTurn
+	case "length":
+		return StopReasonMaxTokens
+	default:
+		return StopReason(reason)
+	}
+}
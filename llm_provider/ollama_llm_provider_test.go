@@ -0,0 +1,147 @@
+package llm_provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/malinowskip/pal/testutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOllamaLLMProviderCreation(t *testing.T) {
+	provider := NewOllamaLLMProvider("http://localhost:11434/", "llama3.2", "10m", 8192, GenerationParams{})
+
+	testutil.AssertDeepEquals(t, provider.host, "http://localhost:11434")
+	testutil.AssertDeepEquals(t, provider.model, "llama3.2")
+	testutil.AssertDeepEquals(t, provider.keepAlive, "10m")
+	testutil.AssertDeepEquals(t, provider.numCtx, 8192)
+}
+
+func TestOllamaLLMProviderStreamsCompletion(t *testing.T) {
+	var receivedBody map[string]any
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			http.NotFound(w, r)
+			return
+		}
+
+		json.NewDecoder(r.Body).Decode(&receivedBody)
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":"Hello"},"done":false}`)
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":", world!"},"done":false}`)
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":26,"eval_count":4}`)
+	}))
+	defer server.Close()
+
+	temperature := 0.0
+	provider := NewOllamaLLMProvider(server.URL, "llama3.2", "-1", 4096, GenerationParams{
+		MaxTokens:   500,
+		Temperature: &temperature,
+		Stop:        []string{"END"},
+	})
+
+	var receivedMessage string
+
+	completion, err := provider.GetCompletion(context.Background(), "System", []Message{{Role: "user", Content: "Hi"}}, func(tokens string) error {
+		receivedMessage += tokens
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.AssertDeepEquals(t, receivedMessage, "Hello, world!")
+	testutil.AssertDeepEquals(t, completion, Completion{
+		Usage:      Usage{InputTokens: 26, OutputTokens: 4},
+		StopReason: StopReasonEndTurn,
+	})
+	testutil.AssertDeepEquals(t, receivedBody["model"], "llama3.2")
+	testutil.AssertDeepEquals(t, receivedBody["stream"], true)
+	testutil.AssertDeepEquals(t, receivedBody["keep_alive"], float64(-1))
+	testutil.AssertDeepEquals(t, receivedBody["options"], map[string]any{
+		"num_ctx":     float64(4096),
+		"num_predict": float64(500),
+		"temperature": float64(0),
+		"stop":        []any{"END"},
+	})
+	testutil.AssertDeepEquals(t, receivedBody["messages"], []any{
+		map[string]any{"role": "system", "content": "System"},
+		map[string]any{"role": "user", "content": "Hi"},
+	})
+}
+
+func TestOllamaLLMProviderReportsErrors(t *testing.T) {
+	t.Run("Error status", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":"model \"nope\" not found"}`)
+		}))
+		defer server.Close()
+
+		provider := NewOllamaLLMProvider(server.URL, "nope", "", 0, GenerationParams{})
+		_, err := provider.GetCompletion(context.Background(), "System", nil, func(tokens string) error { return nil })
+
+		if err == nil {
+			t.Fatal("An error status should result in an error.")
+		}
+
+		testutil.AssertDeepEquals(t, err.Error(), `Unsuccessful request to the Ollama API: model "nope" not found`)
+	})
+
+	t.Run("Error in the stream", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, `{"message":{"role":"assistant","content":"Hel"},"done":false}`)
+			fmt.Fprintln(w, `{"error":"out of memory"}`)
+		}))
+		defer server.Close()
+
+		provider := NewOllamaLLMProvider(server.URL, "llama3.2", "", 0, GenerationParams{})
+		_, err := provider.GetCompletion(context.Background(), "System", nil, func(tokens string) error { return nil })
+
+		if err == nil {
+			t.Fatal("An error in the stream should result in an error.")
+		}
+	})
+
+	t.Run("Incomplete stream", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, `{"message":{"role":"assistant","content":"Hel"},"done":false}`)
+		}))
+		defer server.Close()
+
+		provider := NewOllamaLLMProvider(server.URL, "llama3.2", "", 0, GenerationParams{})
+		_, err := provider.GetCompletion(context.Background(), "System", nil, func(tokens string) error { return nil })
+
+		if err == nil {
+			t.Fatal("A stream that ends before the reply is done should result in an error.")
+		}
+	})
+	t.Run("Canceled request", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, `{"message":{"role":"assistant","content":"Hel"},"done":false}`)
+			w.(http.Flusher).Flush()
+			// Wait until the client gives up.
+			<-r.Context().Done()
+		}))
+		defer server.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		provider := NewOllamaLLMProvider(server.URL, "llama3.2", "", 0, GenerationParams{})
+		_, err := provider.GetCompletion(ctx, "System", nil, func(tokens string) error {
+			cancel()
+			return nil
+		})
+
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("A canceled request should result in context.Canceled (actual: %v).", err)
+		}
+	})
+}
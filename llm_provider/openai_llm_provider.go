@@ -4,7 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/malinowskip/pal/config"
 	"io"
+	"math"
+	"net/http"
+	"strings"
 
 	"github.com/sashabaranov/go-openai"
 )
@@ -12,6 +16,37 @@ import (
 type OpenAILLMProvider struct {
 	apiKey string
 	model  string
+	// Base URL of the API. If empty, the official OpenAI API is used.
+	baseUrl string
+	// Additional HTTP headers sent with each request.
+	headers map[string]string
+	// Parameters of the generated replies.
+	generation GenerationParams
+}
+
+// Optional settings of the OpenAI provider.
+type OpenAIOption func(p *OpenAILLMProvider)
+
+// Sends requests to an OpenAI-compatible API at the given base URL (e.g. a
+// local llama.cpp, vLLM or Ollama server) instead of the official OpenAI API.
+func WithBaseUrl(baseUrl string) OpenAIOption {
+	return func(p *OpenAILLMProvider) {
+		p.baseUrl = baseUrl
+	}
+}
+
+// Adds the given HTTP headers to each request.
+func WithHeaders(headers map[string]string) OpenAIOption {
+	return func(p *OpenAILLMProvider) {
+		p.headers = headers
+	}
+}
+
+// Sets the parameters of the generated replies, such as the temperature.
+func WithGeneration(generation GenerationParams) OpenAIOption {
+	return func(p *OpenAILLMProvider) {
+		p.generation = generation
+	}
 }
 
 type payload struct {
@@ -27,63 +62,269 @@ type message struct {
 
 // Quality-of-life function to support short-hand model names for OpenAI.
 func resolveOpenaiModel(input string) string {
-	if input == "4o-mini" {
-		return "gpt-4o-mini"
-	}
-
-	if input == "4o" {
-		return "gpt-4o"
+	if model, ok := config.OpenaiModelAliases[input]; ok {
+		return model
 	}
 
 	return input
 }
 
-func NewOpenAILLMProvider(apiKey string, model string) *OpenAILLMProvider {
-	return &OpenAILLMProvider{
+func NewOpenAILLMProvider(apiKey string, model string, options ...OpenAIOption) *OpenAILLMProvider {
+	provider := &OpenAILLMProvider{
 		apiKey: apiKey,
 		model:  resolveOpenaiModel(model),
 	}
+
+	for _, option := range options {
+		option(provider)
+	}
+
+	return provider
+}
+
+// Instantiates an API client, taking into account the optional base URL and
+// additional headers. The returned recorder keeps track of the responses of the
+// API, so that failed requests can be retried.
+func (p *OpenAILLMProvider) newClient() (*openai.Client, *responseRecorder) {
+	clientConfig := openai.DefaultConfig(p.apiKey)
+
+	if p.baseUrl != "" {
+		clientConfig.BaseURL = strings.TrimSuffix(p.baseUrl, "/")
+	}
+
+	var transport http.RoundTripper = http.DefaultTransport
+
+	if len(p.headers) > 0 {
+		transport = &headerTransport{
+			headers: p.headers,
+			base:    transport,
+		}
+	}
+
+	recorder := &responseRecorder{base: transport}
+	clientConfig.HTTPClient = &http.Client{Transport: recorder}
+
+	return openai.NewClientWithConfig(clientConfig), recorder
+}
+
+// An http.RoundTripper that adds a fixed set of headers to each request.
+type headerTransport struct {
+	headers map[string]string
+	base    http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper should not modify the original request.
+	req = req.Clone(req.Context())
+
+	for name, value := range t.headers {
+		req.Header.Set(name, value)
+	}
+
+	return t.base.RoundTrip(req)
 }
 
 func (p *OpenAILLMProvider) GetCompletion(
+	ctx context.Context,
+	fullSystemMessage string,
+	messages []Message,
+	handleTokens func(tokens string) error,
+) (Completion, error) {
+	client, recorder := p.newClient()
+
+	_, completion, err := streamReply(ctx, client, recorder, p.newRequest(fullSystemMessage, messages, nil), handleTokens)
+
+	return completion, err
+}
+
+func (p *OpenAILLMProvider) GetCompletionWithTools(
+	ctx context.Context,
 	fullSystemMessage string,
 	messages []Message,
+	tools []Tool,
 	handleTokens func(tokens string) error,
-) error {
-	client := openai.NewClient(p.apiKey)
+	handleMessage func(message Message) error,
+) (Completion, error) {
+	client, recorder := p.newClient()
+
+	request := p.newRequest(fullSystemMessage, messages, tools)
+
+	for turn := 0; turn < maxToolCallTurns; turn++ {
+		reply, completion, err := streamReply(ctx, client, recorder, request, handleTokens)
+		if err != nil {
+			return completion, err
+		}
+
+		if len(reply.ToolCalls) == 0 {
+			return completion, nil
+		}
+
+		reply.Usage = completion.Usage
+
+		if err = handleMessage(reply); err != nil {
+			return Completion{}, err
+		}
+		request.Messages = append(request.Messages, toOpenaiMessage(reply))
+
+		for _, call := range reply.ToolCalls {
+			result := runToolCall(tools, call)
+
+			if err = handleMessage(result); err != nil {
+				return Completion{}, err
+			}
+			request.Messages = append(request.Messages, toOpenaiMessage(result))
+		}
+	}
 
-	finalMessages := buildMessages(fullSystemMessage, messages)
+	return Completion{}, errTooManyToolCallTurns()
+}
+
+func (p *OpenAILLMProvider) BuildRequest(fullSystemMessage string, messages []Message, tools []Tool) any {
+	return p.newRequest(fullSystemMessage, messages, tools)
+}
 
+func (p *OpenAILLMProvider) newRequest(
+	fullSystemMessage string,
+	messages []Message,
+	tools []Tool,
+) openai.ChatCompletionRequest {
 	request := openai.ChatCompletionRequest{
-		Model:    p.model,
-		Messages: finalMessages,
-		Stream:   true,
+		Model:     p.model,
+		Messages:  buildMessages(fullSystemMessage, messages),
+		Stream:    true,
+		MaxTokens: p.generation.MaxTokens,
+		Stop:      p.generation.Stop,
+	}
+
+	// Without this option, the official API doesn’t report usage in streamed
+	// replies. Some OpenAI-compatible servers reject it, so it isn’t sent to
+	// them.
+	if p.baseUrl == "" {
+		request.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+	}
+
+	if p.generation.Temperature != nil {
+		request.Temperature = nonZeroFloat32(*p.generation.Temperature)
+	}
+
+	if p.generation.TopP != nil {
+		request.TopP = nonZeroFloat32(*p.generation.TopP)
+	}
+
+	for _, tool := range tools {
+		request.Tools = append(request.Tools, openai.Tool{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
 	}
 
-	stream, err := client.CreateChatCompletionStream(
-		context.Background(),
-		request,
-	)
+	return request
+}
+
+// The API client leaves out parameters equal to 0, in which case the API’s
+// default would be used instead. The smallest positive number is practically
+// equivalent to 0, and is sent.
+func nonZeroFloat32(value float64) float32 {
+	if value == 0 {
+		return math.SmallestNonzeroFloat32
+	}
+
+	return float32(value)
+}
+
+// Sends the request and streams the reply through handleTokens. Returns the
+// complete reply, including any tool calls, which are streamed in fragments,
+// along with the usage, which is reported in the last chunk, and the stop
+// reason.
+func streamReply(
+	ctx context.Context,
+	client *openai.Client,
+	recorder *responseRecorder,
+	request openai.ChatCompletionRequest,
+	handleTokens func(tokens string) error,
+) (Message, Completion, error) {
+	reply := Message{Role: "assistant"}
+	var completion Completion
+
+	stream, err := client.CreateChatCompletionStream(ctx, request)
 
 	if err != nil {
-		return fmt.Errorf("Unsuccessful request to the OpenAI API: %v", err)
+		if ctx.Err() != nil {
+			return reply, completion, ctx.Err()
+		}
+		return reply, completion, recorder.wrapError(fmt.Errorf("Unsuccessful request to the OpenAI API: %v", err))
 	}
 
 	defer stream.Close()
 
+	var content strings.Builder
+
 	for {
 		response, err := stream.Recv()
 		if errors.Is(err, io.EOF) {
-			return nil
+			reply.Content = content.String()
+			return reply, completion, nil
 		}
 
 		if err != nil {
-			fmt.Printf("\nStream error: %v\n", err)
-			return err
+			// An aborted request is not an error of the stream.
+			if ctx.Err() != nil {
+				return reply, completion, ctx.Err()
+			}
+			return reply, completion, err
+		}
+
+		if response.Usage != nil {
+			completion.Usage = fromOpenaiUsage(*response.Usage)
+		}
+
+		// The usage is reported in a chunk without any choices.
+		if len(response.Choices) == 0 {
+			continue
+		}
+
+		if reason := response.Choices[0].FinishReason; reason != "" {
+			completion.StopReason = fromOpenaiFinishReason(reason)
 		}
 
-		text := response.Choices[0].Delta.Content
-		handleTokens(text)
+		delta := response.Choices[0].Delta
+
+		// Each tool call is streamed in fragments, identified by the call’s
+		// index. The id and the name come first, followed by pieces of the
+		// arguments.
+		for _, fragment := range delta.ToolCalls {
+			// Some OpenAI-compatible servers omit the index, in which case a new call
+			// starts with each id.
+			index := max(len(reply.ToolCalls)-1, 0)
+			if fragment.Index != nil {
+				index = *fragment.Index
+			} else if fragment.ID != "" {
+				index = len(reply.ToolCalls)
+			}
+
+			for len(reply.ToolCalls) <= index {
+				reply.ToolCalls = append(reply.ToolCalls, ToolCall{})
+			}
+
+			if fragment.ID != "" {
+				reply.ToolCalls[index].Id = fragment.ID
+			}
+			reply.ToolCalls[index].Name += fragment.Function.Name
+			reply.ToolCalls[index].Arguments += fragment.Function.Arguments
+		}
+
+		if delta.Content == "" {
+			continue
+		}
+
+		content.WriteString(delta.Content)
+		if err = handleTokens(delta.Content); err != nil {
+			return reply, completion, err
+		}
 	}
 }
 
@@ -96,8 +337,58 @@ func buildMessages(
 	finalMessages = append(finalMessages, openai.ChatCompletionMessage{Role: "system", Content: fullSystemMessage})
 
 	for _, m := range messages {
-		finalMessages = append(finalMessages, openai.ChatCompletionMessage{Role: m.Role, Content: m.Content})
+		finalMessages = append(finalMessages, toOpenaiMessage(m))
 	}
 
 	return finalMessages
 }
+
+func toOpenaiMessage(m Message) openai.ChatCompletionMessage {
+	message := openai.ChatCompletionMessage{
+		Role:       m.Role,
+		Content:    m.Content,
+		ToolCallID: m.ToolCallId,
+	}
+
+	for _, call := range m.ToolCalls {
+		message.ToolCalls = append(message.ToolCalls, openai.ToolCall{
+			ID:   call.Id,
+			Type: openai.ToolTypeFunction,
+			Function: openai.FunctionCall{
+				Name:      call.Name,
+				Arguments: call.Arguments,
+			},
+		})
+	}
+
+	return message
+}
+
+// OpenAI counts cached tokens as part of the prompt tokens. Caching happens
+// automatically, so nothing is reported as written to the cache.
+func fromOpenaiUsage(usage openai.Usage) Usage {
+	result := Usage{
+		InputTokens:  usage.PromptTokens,
+		OutputTokens: usage.CompletionTokens,
+	}
+
+	if usage.PromptTokensDetails != nil {
+		result.CacheReadTokens = usage.PromptTokensDetails.CachedTokens
+		result.InputTokens -= result.CacheReadTokens
+	}
+
+	return result
+}
+
+func fromOpenaiFinishReason(reason openai.FinishReason) StopReason {
+	switch reason {
+	case openai.FinishReasonStop:
+		return StopReasonEndTurn
+	case openai.FinishReasonLength:
+		return StopReasonMaxTokens
+	case openai.FinishReasonToolCalls, openai.FinishReasonFunctionCall:
+		return StopReasonToolUse
+	default:
+		return StopReason(reason)
+	}
+}
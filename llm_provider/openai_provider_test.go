@@ -1,7 +1,17 @@
 package llm_provider
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/malinowskip/pal/config"
+	"github.com/malinowskip/pal/testutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/sashabaranov/go-openai"
 )
 
 func TestOpenAILLMProviderCreation(t *testing.T) {
@@ -34,3 +44,239 @@ func TestOpenaiModelResolution(t *testing.T) {
 		}
 	}
 }
+
+// Starts a local stand-in for an OpenAI-compatible API, which streams the given
+// chunks of text in reply to any chat completion request. Each request is
+// passed to `inspect` before the reply is sent.
+func startFakeOpenAIServer(t *testing.T, chunks []string, inspect func(r *http.Request)) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inspect(r)
+
+		if r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+
+		var deltas []map[string]any
+		for _, chunk := range chunks {
+			deltas = append(deltas, map[string]any{"content": chunk})
+		}
+
+		writeStreamedDeltas(w, deltas)
+	}))
+
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// Streams the given message deltas as chat completion chunks.
+func writeStreamedDeltas(w http.ResponseWriter, deltas []map[string]any) {
+	w.Header().Set("Content-Type", "text/event-stream")
+
+	for _, delta := range deltas {
+		data, _ := json.Marshal(map[string]any{
+			"id":      "chatcmpl-1",
+			"object":  "chat.completion.chunk",
+			"created": 0,
+			"model":   "local-model",
+			"choices": []map[string]any{
+				{"index": 0, "delta": delta},
+			},
+		})
+		fmt.Fprintf(w, "data: %s\n\n", data)
+	}
+
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+func TestOpenAILLMProviderWithCustomBaseUrl(t *testing.T) {
+	var receivedRequest *http.Request
+	var receivedBody map[string]any
+
+	server := startFakeOpenAIServer(t, []string{"Hello", ", world!"}, func(r *http.Request) {
+		receivedRequest = r
+		json.NewDecoder(r.Body).Decode(&receivedBody)
+	})
+
+	provider := NewOpenAILLMProvider(
+		"key",
+		"local-model",
+		WithBaseUrl(server.URL+"/v1/"),
+		WithHeaders(map[string]string{"X-Team": "pal"}),
+		WithGeneration(GenerationParams{MaxTokens: 500, Temperature: new(float64)}),
+	)
+
+	var receivedMessage string
+
+	_, err := provider.GetCompletion(context.Background(), "System", []Message{{Role: "user", Content: "Hi"}}, func(tokens string) error {
+		receivedMessage += tokens
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.AssertDeepEquals(t, receivedMessage, "Hello, world!")
+	testutil.AssertDeepEquals(t, receivedRequest.Header.Get("X-Team"), "pal")
+	testutil.AssertDeepEquals(t, receivedRequest.Header.Get("Authorization"), "Bearer key")
+	testutil.AssertDeepEquals(t, receivedBody["model"], "local-model")
+	testutil.AssertDeepEquals(t, receivedBody["max_tokens"], float64(500))
+
+	// Some OpenAI-compatible servers reject stream options.
+	if _, ok := receivedBody["stream_options"]; ok {
+		t.Error("Stream options should only be sent to the official API.")
+	}
+
+	// A temperature of 0 must not be left out.
+	if temperature, ok := receivedBody["temperature"].(float64); !ok || temperature > 0.0001 {
+		t.Errorf("Unexpected temperature: %v", receivedBody["temperature"])
+	}
+
+	if _, ok := receivedBody["top_p"]; ok {
+		t.Error("The top-p parameter should not be sent unless it’s set.")
+	}
+}
+
+func TestOpenAIRequestIncludesUsageOption(t *testing.T) {
+	provider := NewOpenAILLMProvider("key", "gpt-4o")
+
+	request := provider.BuildRequest("System", []Message{{Role: "user", Content: "Hi"}}, nil).(openai.ChatCompletionRequest)
+
+	testutil.AssertDeepEquals(t, request.StreamOptions, &openai.StreamOptions{IncludeUsage: true})
+}
+
+func TestOpenAILLMProviderReportsCompletion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, `data: {"choices":[{"index":0,"delta":{"content":"Hello"}}]}`+"\n\n")
+		fmt.Fprint(w, `data: {"choices":[{"index":0,"delta":{},"finish_reason":"length"}]}`+"\n\n")
+		fmt.Fprint(w, `data: {"choices":[],"usage":{"prompt_tokens":1200,"completion_tokens":30,"total_tokens":1230,"prompt_tokens_details":{"cached_tokens":1024}}}`+"\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(server.Close)
+
+	provider := NewOpenAILLMProvider("key", "local-model", WithBaseUrl(server.URL+"/v1"))
+
+	completion, err := provider.GetCompletion(context.Background(), "System", nil, func(tokens string) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cached tokens are counted separately.
+	testutil.AssertDeepEquals(t, completion, Completion{
+		Usage:      Usage{InputTokens: 176, OutputTokens: 30, CacheReadTokens: 1024},
+		StopReason: StopReasonMaxTokens,
+	})
+}
+
+func TestOpenAILLMProviderWithTools(t *testing.T) {
+	var receivedBodies []map[string]any
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		receivedBodies = append(receivedBodies, body)
+
+		// The first reply calls a tool, with its arguments split across chunks.
+		if len(receivedBodies) == 1 {
+			writeStreamedDeltas(w, []map[string]any{
+				{"content": "Let me check."},
+				{"tool_calls": []map[string]any{
+					{"index": 0, "id": "call_1", "type": "function", "function": map[string]string{"name": "read_file", "arguments": `{"path":`}},
+				}},
+				{"tool_calls": []map[string]any{
+					{"index": 0, "function": map[string]string{"arguments": `"main.go"}`}},
+				}},
+			})
+			return
+		}
+
+		writeStreamedDeltas(w, []map[string]any{{"content": "It’s empty."}})
+	}))
+	t.Cleanup(server.Close)
+
+	provider := NewOpenAILLMProvider("key", "local-model", WithBaseUrl(server.URL+"/v1"))
+
+	tools := []Tool{
+		{
+			Name:       "read_file",
+			Parameters: map[string]any{"type": "object"},
+			Run: func(arguments string) (string, error) {
+				testutil.AssertDeepEquals(t, arguments, `{"path":"main.go"}`)
+				return "package main", nil
+			},
+		},
+	}
+
+	var receivedMessage string
+	var recordedMessages []Message
+
+	completion, err := provider.GetCompletionWithTools(
+		context.Background(),
+		"System",
+		[]Message{{Role: "user", Content: "What’s in main.go?"}},
+		tools,
+		func(tokens string) error {
+			receivedMessage += tokens
+			return nil
+		},
+		func(message Message) error {
+			recordedMessages = append(recordedMessages, message)
+			return nil
+		},
+	)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.AssertDeepEquals(t, receivedMessage, "Let me check.It’s empty.")
+	testutil.AssertDeepEquals(t, completion, Completion{})
+	testutil.AssertDeepEquals(t, recordedMessages, []Message{
+		{
+			Role:      "assistant",
+			Content:   "Let me check.",
+			ToolCalls: []ToolCall{{Id: "call_1", Name: "read_file", Arguments: `{"path":"main.go"}`}},
+		},
+		{Role: "tool", Content: "package main", ToolCallId: "call_1"},
+	})
+
+	// The tool call and its result are sent back to the model.
+	testutil.AssertLength(t, receivedBodies, 2)
+	testutil.AssertLength(t, receivedBodies[0]["tools"].([]any), 1)
+
+	followUp := receivedBodies[1]["messages"].([]any)
+	testutil.AssertLength(t, followUp, 4)
+	testutil.AssertDeepEquals(t, followUp[3].(map[string]any)["tool_call_id"], "call_1")
+}
+
+func TestCheckApiKey(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "")
+	t.Setenv("ANTHROPIC_API_KEY", "")
+
+	conf := config.DefaultConfig()
+
+	if err := CheckApiKey(&conf); err == nil || !strings.Contains(err.Error(), "OPENAI_API_KEY") {
+		t.Errorf("A missing API key should result in an error (error: %v).", err)
+	}
+
+	// The provider can still be resolved, e.g. to build a request for a dry run.
+	if _, err := ResolveFromConfig(&conf); err != nil {
+		t.Error(err)
+	}
+
+	// OpenAI-compatible servers may not require an API key.
+	conf.Openai.BaseUrl = "http://localhost:8080/v1"
+	if err := CheckApiKey(&conf); err != nil {
+		t.Error(err)
+	}
+
+	conf.Provider = "anthropic"
+	if err := CheckApiKey(&conf); err == nil || !strings.Contains(err.Error(), "ANTHROPIC_API_KEY") {
+		t.Errorf("A missing API key should result in an error (error: %v).", err)
+	}
+}
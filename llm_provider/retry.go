@@ -0,0 +1,264 @@
+package llm_provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// HTTP status codes indicating that the request may succeed if it’s retried
+// later. 529 is used by Anthropic when its API is overloaded.
+var retryableStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+	529,
+}
+
+// An error returned by a provider when the API responded with an error status.
+type StatusError struct {
+	StatusCode int
+	// Delay requested by the API through the `Retry-After` header. 0 if the
+	// header was not present.
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *StatusError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// Wraps another provider, retrying requests that fail because of rate limits or
+// transient errors of the API, with exponential backoff. A request is retried
+// only if nothing has been streamed yet, so that the caller never receives (and
+// records) the same part of a reply twice.
+type RetryingLLMProvider struct {
+	provider LLMProvider
+	// Maximum number of attempts, including the first one.
+	maxAttempts int
+	// Delay before the first retry. It doubles with each subsequent retry,
+	// unless the API specifies the delay using the `Retry-After` header.
+	baseDelay time.Duration
+	// Called before waiting for the next attempt.
+	onRetry func(err error, delay time.Duration)
+	// Waits for the given duration, unless the context is canceled first.
+	sleep func(ctx context.Context, delay time.Duration) error
+}
+
+// Wraps the provider with retries. The returned provider supports tool calling
+// if the wrapped provider does.
+func NewRetryingLLMProvider(
+	provider LLMProvider,
+	maxAttempts int,
+	baseDelay time.Duration,
+	onRetry func(err error, delay time.Duration),
+) LLMProvider {
+	retrying := &RetryingLLMProvider{
+		provider:    provider,
+		maxAttempts: maxAttempts,
+		baseDelay:   baseDelay,
+		onRetry:     onRetry,
+		sleep:       sleepWithContext,
+	}
+
+	if _, ok := provider.(ToolCallingLLMProvider); ok {
+		return &retryingToolCallingLLMProvider{retrying}
+	}
+
+	return retrying
+}
+
+func (p *RetryingLLMProvider) GetCompletion(
+	ctx context.Context,
+	fullSystemMessage string,
+	messages []Message,
+	handleTokens func(tokens string) error,
+) (Completion, error) {
+	var completion Completion
+
+	err := p.retry(ctx, func(markStarted func()) error {
+		var err error
+		completion, err = p.provider.GetCompletion(ctx, fullSystemMessage, messages, func(tokens string) error {
+			markStarted()
+			return handleTokens(tokens)
+		})
+		return err
+	})
+
+	return completion, err
+}
+
+// Returns the request built by the wrapped provider, or nil if it can’t build
+// requests.
+func (p *RetryingLLMProvider) BuildRequest(fullSystemMessage string, messages []Message, tools []Tool) any {
+	if builder, ok := p.provider.(RequestBuilder); ok {
+		return builder.BuildRequest(fullSystemMessage, messages, tools)
+	}
+
+	return nil
+}
+
+// Calls the function until it succeeds, it fails with an error that can’t be
+// retried, the function reports (by calling markStarted) that it has passed
+// some data to the caller, or the attempts run out.
+func (p *RetryingLLMProvider) retry(ctx context.Context, attempt func(markStarted func()) error) error {
+	for attemptNumber := 1; ; attemptNumber++ {
+		started := false
+
+		err := attempt(func() { started = true })
+		if err == nil || started || attemptNumber >= p.maxAttempts {
+			return err
+		}
+
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) || !slices.Contains(retryableStatusCodes, statusErr.StatusCode) {
+			return err
+		}
+
+		delay := statusErr.RetryAfter
+		if delay == 0 {
+			delay = backoffDelay(p.baseDelay, attemptNumber)
+		}
+
+		if p.onRetry != nil {
+			p.onRetry(err, delay)
+		}
+
+		if err = p.sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+type retryingToolCallingLLMProvider struct {
+	*RetryingLLMProvider
+}
+
+func (p *retryingToolCallingLLMProvider) GetCompletionWithTools(
+	ctx context.Context,
+	fullSystemMessage string,
+	messages []Message,
+	tools []Tool,
+	handleTokens func(tokens string) error,
+	handleMessage func(message Message) error,
+) (Completion, error) {
+	provider := p.provider.(ToolCallingLLMProvider)
+
+	var completion Completion
+
+	err := p.retry(ctx, func(markStarted func()) error {
+		var err error
+		completion, err = provider.GetCompletionWithTools(
+			ctx,
+			fullSystemMessage,
+			messages,
+			tools,
+			func(tokens string) error {
+				markStarted()
+				return handleTokens(tokens)
+			},
+			func(message Message) error {
+				markStarted()
+				return handleMessage(message)
+			},
+		)
+		return err
+	})
+
+	return completion, err
+}
+
+// Returns the delay before the given retry: the base delay, doubled for each
+// previous retry, with up to 20% of random jitter added, so that multiple
+// clients don’t retry in lockstep.
+func backoffDelay(baseDelay time.Duration, retry int) time.Duration {
+	delay := baseDelay << (retry - 1)
+	jitter := time.Duration(rand.Int63n(int64(delay)/5 + 1))
+
+	return delay + jitter
+}
+
+func sleepWithContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// An http.RoundTripper that records the status code and the `Retry-After`
+// header of the most recent response. The SDKs used by the providers don’t
+// expose the headers of failed requests, so this is needed to tell whether a
+// request may be retried, and when.
+type responseRecorder struct {
+	base       http.RoundTripper
+	statusCode int
+	retryAfter time.Duration
+}
+
+func (r *responseRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	response, err := r.base.RoundTrip(req)
+	if err != nil {
+		return response, err
+	}
+
+	r.statusCode = response.StatusCode
+	r.retryAfter = parseRetryAfter(response.Header.Get("Retry-After"), time.Now())
+
+	return response, nil
+}
+
+// Wraps the error in a StatusError if the most recent response had an error
+// status.
+func (r *responseRecorder) wrapError(err error) error {
+	if err == nil || r.statusCode < http.StatusBadRequest {
+		return err
+	}
+
+	return &StatusError{StatusCode: r.statusCode, RetryAfter: r.retryAfter, Err: err}
+}
+
+// Parses the value of the `Retry-After` header, which is either a number of
+// seconds or an HTTP date. Returns 0 if the value is missing or invalid.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+
+	return 0
+}
+
+// Describes a retry to the user, e.g. “Retrying in 2s (rate limited)”.
+func DescribeRetry(err error, delay time.Duration) string {
+	reason := "the API is unavailable"
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusTooManyRequests {
+		reason = "rate limited"
+	}
+
+	return fmt.Sprintf("Retrying in %s (%s)…", delay.Round(100*time.Millisecond), reason)
+}
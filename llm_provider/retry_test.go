@@ -0,0 +1,186 @@
+package llm_provider
+
+import (
+	"context"
+	"fmt"
+	"github.com/malinowskip/pal/testutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Wraps the provider with retries, recording the delays instead of waiting.
+func newTestRetryingProvider(provider LLMProvider, maxAttempts int, delays *[]time.Duration) LLMProvider {
+	retrying := NewRetryingLLMProvider(provider, maxAttempts, time.Second, nil)
+
+	sleep := func(ctx context.Context, delay time.Duration) error {
+		*delays = append(*delays, delay)
+		return nil
+	}
+
+	switch retrying := retrying.(type) {
+	case *RetryingLLMProvider:
+		retrying.sleep = sleep
+	case *retryingToolCallingLLMProvider:
+		retrying.sleep = sleep
+	}
+
+	return retrying
+}
+
+func TestRetriesRateLimitedRequests(t *testing.T) {
+	requests := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		switch requests {
+		case 1:
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"error":{"message":"Rate limit reached","type":"requests"}}`)
+		case 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, `{"error":{"message":"Overloaded","type":"server_error"}}`)
+		default:
+			writeStreamedDeltas(w, []map[string]any{{"content": "Hello, world!"}})
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	var delays []time.Duration
+	provider := newTestRetryingProvider(
+		NewOpenAILLMProvider("key", "local-model", WithBaseUrl(server.URL+"/v1")),
+		3,
+		&delays,
+	)
+
+	if _, ok := provider.(ToolCallingLLMProvider); !ok {
+		t.Error("The wrapped provider supports tool calling, so the retrying provider should as well.")
+	}
+
+	var receivedMessage string
+
+	_, err := provider.GetCompletion(context.Background(), "System", []Message{{Role: "user", Content: "Hi"}}, func(tokens string) error {
+		receivedMessage += tokens
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.AssertDeepEquals(t, receivedMessage, "Hello, world!")
+	testutil.AssertDeepEquals(t, requests, 3)
+
+	// The first delay is requested by the API. The second one is based on the
+	// base delay, doubled for the second retry, plus up to 20% of jitter.
+	testutil.AssertLength(t, delays, 2)
+	testutil.AssertDeepEquals(t, delays[0], 7*time.Second)
+
+	if delays[1] < 2*time.Second || delays[1] > 2400*time.Millisecond {
+		t.Errorf("Unexpected delay of the second retry: %s", delays[1])
+	}
+}
+
+func TestGivesUpRetrying(t *testing.T) {
+	statusCodes := map[int]int{
+		// Attempts run out.
+		http.StatusTooManyRequests: 2,
+		// Errors that won’t go away are not retried.
+		http.StatusBadRequest:   1,
+		http.StatusUnauthorized: 1,
+	}
+
+	for statusCode, expectedRequests := range statusCodes {
+		requests := 0
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.WriteHeader(statusCode)
+			fmt.Fprint(w, `{"error":{"message":"Nope"}}`)
+		}))
+
+		var delays []time.Duration
+		provider := newTestRetryingProvider(
+			NewOllamaLLMProvider(server.URL, "llama3.2", "", 0, GenerationParams{}),
+			2,
+			&delays,
+		)
+
+		_, err := provider.GetCompletion(context.Background(), "System", nil, func(tokens string) error { return nil })
+		server.Close()
+
+		if err == nil {
+			t.Errorf("Status %d should result in an error.", statusCode)
+		}
+
+		if requests != expectedRequests {
+			t.Errorf("Status %d should result in %d requests (actual: %d).", statusCode, expectedRequests, requests)
+		}
+	}
+}
+
+// Fails with a retryable error after streaming the given tokens.
+type failingProvider struct {
+	tokens   string
+	attempts int
+}
+
+func (p *failingProvider) GetCompletion(
+	ctx context.Context,
+	fullSystemMessage string,
+	messages []Message,
+	handleTokens func(tokens string) error,
+) (Completion, error) {
+	p.attempts++
+
+	if p.tokens != "" {
+		if err := handleTokens(p.tokens); err != nil {
+			return Completion{}, err
+		}
+	}
+
+	return Completion{}, &StatusError{StatusCode: http.StatusServiceUnavailable, Err: fmt.Errorf("Overloaded")}
+}
+
+func TestDoesNotRetryAfterStreamingTokens(t *testing.T) {
+	var delays []time.Duration
+
+	t.Run("Retries before any tokens are streamed", func(t *testing.T) {
+		failing := &failingProvider{}
+		provider := newTestRetryingProvider(failing, 3, &delays)
+
+		provider.GetCompletion(context.Background(), "System", nil, func(tokens string) error { return nil })
+
+		testutil.AssertDeepEquals(t, failing.attempts, 3)
+	})
+
+	t.Run("Does not retry once tokens have been streamed", func(t *testing.T) {
+		failing := &failingProvider{tokens: "Hel"}
+		provider := newTestRetryingProvider(failing, 3, &delays)
+
+		var receivedMessage string
+		_, err := provider.GetCompletion(context.Background(), "System", nil, func(tokens string) error {
+			receivedMessage += tokens
+			return nil
+		})
+
+		if err == nil {
+			t.Error("The error should be returned.")
+		}
+
+		testutil.AssertDeepEquals(t, failing.attempts, 1)
+		testutil.AssertDeepEquals(t, receivedMessage, "Hel")
+	})
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 11, 1, 12, 0, 0, 0, time.UTC)
+
+	testutil.AssertDeepEquals(t, parseRetryAfter("", now), time.Duration(0))
+	testutil.AssertDeepEquals(t, parseRetryAfter("30", now), 30*time.Second)
+	testutil.AssertDeepEquals(t, parseRetryAfter("Fri, 01 Nov 2024 12:01:00 GMT", now), time.Minute)
+	testutil.AssertDeepEquals(t, parseRetryAfter("soon", now), time.Duration(0))
+}
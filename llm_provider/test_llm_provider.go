@@ -1,13 +1,75 @@
 package llm_provider
 
+import "context"
+
 type TestLLMProvider struct{}
 
 const TestProviderExpectedMessage = "Hello, world!"
 
+// Arguments of the tool call made by the test provider.
+const TestProviderToolArguments = "{}"
+
+// Usage reported by the test provider for each request.
+var TestProviderUsage = Usage{InputTokens: 1000, OutputTokens: 100, CacheReadTokens: 500}
+
 func (p *TestLLMProvider) GetCompletion(
+	ctx context.Context,
+	fullSystemMessage string,
+	messages []Message,
+	handleTokens func(tokens string) error,
+) (Completion, error) {
+	if err := ctx.Err(); err != nil {
+		return Completion{}, err
+	}
+
+	return Completion{Usage: TestProviderUsage, StopReason: StopReasonEndTurn}, handleTokens(TestProviderExpectedMessage)
+}
+
+// Calls the first of the given tools (with empty arguments) before replying
+// with the expected message.
+func (p *TestLLMProvider) GetCompletionWithTools(
+	ctx context.Context,
 	fullSystemMessage string,
 	messages []Message,
+	tools []Tool,
 	handleTokens func(tokens string) error,
-) error {
-	return handleTokens(TestProviderExpectedMessage)
+	handleMessage func(message Message) error,
+) (Completion, error) {
+	if err := ctx.Err(); err != nil {
+		return Completion{}, err
+	}
+
+	if len(tools) > 0 {
+		call := ToolCall{
+			Id:        "call_1",
+			Name:      tools[0].Name,
+			Arguments: TestProviderToolArguments,
+		}
+
+		reply := Message{Role: "assistant", ToolCalls: []ToolCall{call}, Usage: TestProviderUsage}
+		if err := handleMessage(reply); err != nil {
+			return Completion{}, err
+		}
+
+		result := runToolCall(tools, call)
+		if err := handleMessage(result); err != nil {
+			return Completion{}, err
+		}
+	}
+
+	return Completion{Usage: TestProviderUsage, StopReason: StopReasonEndTurn}, handleTokens(TestProviderExpectedMessage)
+}
+
+// Returns the system message, the messages and the names of the tools.
+func (p *TestLLMProvider) BuildRequest(fullSystemMessage string, messages []Message, tools []Tool) any {
+	var toolNames []string
+	for _, tool := range tools {
+		toolNames = append(toolNames, tool.Name)
+	}
+
+	return map[string]any{
+		"system":   fullSystemMessage,
+		"messages": messages,
+		"tools":    toolNames,
+	}
 }
@@ -1,6 +1,7 @@
 package llm_provider
 
 import (
+	"context"
 	"testing"
 )
 
@@ -12,7 +13,7 @@ func TestTestLLMProvider(t *testing.T) {
 
 	var receivedMessage string
 
-	provider.GetCompletion(fullSystemMessage, messages, func(tokens string) error {
+	provider.GetCompletion(context.Background(), fullSystemMessage, messages, func(tokens string) error {
 		receivedMessage = receivedMessage + tokens
 		return nil
 	})
@@ -0,0 +1,89 @@
+package llm_provider
+
+import (
+	"context"
+	"fmt"
+)
+
+// Maximum number of consecutive turns in which the model may call tools
+// before giving its final reply. This prevents a confused model from looping
+// indefinitely.
+const maxToolCallTurns = 20
+
+// A tool that the model may call to obtain additional information, e.g. the
+// contents of a file.
+type Tool struct {
+	Name        string
+	Description string
+	// JSON schema of the tool’s arguments.
+	Parameters map[string]any
+	// Runs the tool with the arguments provided by the model (a JSON object) and
+	// returns the result, which is passed back to the model. If an error is
+	// returned, its message is passed to the model instead.
+	Run func(arguments string) (string, error)
+}
+
+// A call to a tool requested by the model.
+type ToolCall struct {
+	// Identifier assigned by the provider, which links the call to its result.
+	Id   string
+	Name string
+	// Arguments as a JSON object.
+	Arguments string
+}
+
+// A provider that supports tool calling.
+type ToolCallingLLMProvider interface {
+	LLMProvider
+	// Works like GetCompletion, except that the model may call any of the given
+	// tools before giving its final reply. The provider runs the requested tools
+	// and sends their results back to the model, in a loop, until the model
+	// replies without calling any tools.
+	//
+	// Text generated by the model is streamed through handleTokens, including
+	// any text preceding tool calls. Each assistant message containing tool calls,
+	// as well as each tool result (with the `tool` role), is passed to
+	// handleMessage, so that the caller can record the exchange.
+	//
+	// Each request made while the model calls tools reports its usage in the
+	// assistant message passed to handleMessage; the returned completion
+	// describes the final request only.
+	GetCompletionWithTools(
+		ctx context.Context,
+		fullSystemMessage string,
+		messages []Message,
+		tools []Tool,
+		handleTokens func(tokens string) error,
+		handleMessage func(message Message) error,
+	) (Completion, error)
+}
+
+// Runs the tool requested by the model and returns the result message. Failures
+// (including calls to unknown tools) are reported to the model rather than
+// interrupting the conversation, so that it can correct itself.
+func runToolCall(tools []Tool, call ToolCall) Message {
+	result := Message{Role: "tool", ToolCallId: call.Id}
+
+	for _, tool := range tools {
+		if tool.Name == call.Name {
+			output, err := tool.Run(call.Arguments)
+			if err != nil {
+				result.Content = err.Error()
+				result.IsError = true
+				return result
+			}
+
+			result.Content = output
+			return result
+		}
+	}
+
+	result.Content = fmt.Sprintf("Unknown tool: %s.", call.Name)
+	result.IsError = true
+	return result
+}
+
+// Returns the error reported when the model doesn’t stop calling tools.
+func errTooManyToolCallTurns() error {
+	return fmt.Errorf("The model did not reply after %d consecutive turns of tool calls.", maxToolCallTurns)
+}
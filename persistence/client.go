@@ -6,6 +6,7 @@ import (
 	"github.com/malinowskip/pal/constants"
 	"github.com/malinowskip/pal/util"
 	"path"
+	"slices"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -63,6 +64,36 @@ var allMigrations map[int]string = map[int]string{
 			foreign key(conversation_id) references conversations(id) on delete cascade
 		);
 	`,
+	2: `
+		create table conversation_documents(
+			conversation_id integer,
+			path string,
+			foreign key(conversation_id) references conversations(id) on delete cascade
+		);
+	`,
+	3: `
+		alter table messages add column tool_calls string;
+		alter table messages add column tool_call_id string;
+	`,
+	4: `
+		alter table messages add column interrupted boolean not null default false;
+	`,
+	5: `
+		alter table messages add column model string;
+		alter table messages add column input_tokens integer not null default 0;
+		alter table messages add column output_tokens integer not null default 0;
+		alter table messages add column cache_read_tokens integer not null default 0;
+		alter table messages add column cache_write_tokens integer not null default 0;
+	`,
+	6: `
+		alter table messages add column stop_reason string;
+	`,
+	7: `
+		alter table conversations add column document_selection string;
+	`,
+	8: `
+		alter table messages add column is_error boolean not null default false;
+	`,
 }
 
 func (c *DatabaseClient) runMigrations() error {
@@ -88,7 +119,16 @@ func (c *DatabaseClient) runMigrations() error {
 		lastAppliedMigration = new(int)
 	}
 
-	for id, sql := range allMigrations {
+	// Migrations must be applied in order, but iterating over a map yields its
+	// keys in random order.
+	var migrationIds []int
+	for id := range allMigrations {
+		migrationIds = append(migrationIds, id)
+	}
+	slices.Sort(migrationIds)
+
+	for _, id := range migrationIds {
+		sql := allMigrations[id]
 		if id > *lastAppliedMigration {
 			// Transaction for applying the migration and recording in the `migrations`
 			// table that it has been applied
@@ -1,18 +1,101 @@
 package persistence
 
 import (
+	"encoding/json"
 	"fmt"
+	"time"
 )
 
 type Conversation struct {
 	Id       int64
 	Messages []Message
+	// Paths of the documents that were selected as the context of this
+	// conversation. Nil if the conversation uses the whole project as context.
+	DocumentPaths []string
+	// Patterns that adjusted the documents loaded for this conversation (see
+	// RecordDocumentSelection). Nil if none were given.
+	DocumentSelection *DocumentSelection
+}
+
+// Patterns passed by the user to adjust which documents are loaded, on top of
+// the configuration.
+type DocumentSelection struct {
+	Include []string `json:"include,omitempty"`
+	Only    []string `json:"only,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// Condensed information on a stored conversation, used for listing the
+// conversation history without loading every message.
+type ConversationSummary struct {
+	Id        int64
+	CreatedAt time.Time
+	// Content of the first message sent by the user (empty if the conversation
+	// has no user messages yet).
+	FirstUserMessage string
+	MessageCount     int
 }
 
 type Message struct {
 	Id      int64
 	Role    string
 	Content string
+	// Tools called by the LLM in an assistant message.
+	ToolCalls []ToolCall
+	// In a `tool` message, which contains the result of a tool call, the id of
+	// that call.
+	ToolCallId string
+	// In a `tool` message, whether the tool call failed.
+	IsError bool
+	// Whether the LLM’s reply was interrupted (e.g. by the user or by an error)
+	// before it was complete.
+	Interrupted bool
+	// In an assistant message, the model that generated it. Empty if the usage
+	// hasn’t been recorded.
+	Model string
+	// In an assistant message, the tokens used by the request that generated
+	// it.
+	Usage Usage
+	// In an assistant message, why the LLM stopped generating, e.g. `end_turn`
+	// or `max_tokens`. Empty if unknown.
+	StopReason string
+}
+
+// Number of tokens used by a request to the LLM. Input tokens read from or
+// written to the prompt cache are not included in InputTokens.
+type Usage struct {
+	InputTokens      int
+	OutputTokens     int
+	CacheReadTokens  int
+	CacheWriteTokens int
+}
+
+// How recorded usage is grouped by SummarizeUsage.
+type UsageGrouping string
+
+const (
+	UsageByConversation UsageGrouping = "conversation"
+	UsageByModel        UsageGrouping = "model"
+	UsageByDay          UsageGrouping = "day"
+)
+
+// Total usage of a single model within a group of messages, e.g. within a
+// conversation.
+type UsageSummary struct {
+	// Conversation id, model name or day (in the `YYYY-MM-DD` format, local
+	// time), depending on the grouping.
+	Group string
+	Model string
+	// Number of messages with recorded usage.
+	MessageCount int
+	Usage        Usage
+}
+
+// A call to a tool made by the LLM, stored as JSON along with the message.
+type ToolCall struct {
+	Id        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
 }
 
 // Creates an empty conversation in the database.
@@ -59,20 +142,74 @@ func (c *DatabaseClient) FetchRecentConversation() (
 		return convo, fmt.Errorf("No conversations.")
 	}
 
-	convo.Id = *conversationId
+	return c.FetchConversation(*conversationId)
+}
+
+// Fetches the conversation with the given id, including all of its messages in
+// the order in which they were inserted. Returns an error if the conversation
+// does not exist.
+func (c *DatabaseClient) FetchConversation(conversationId int64) (
+	Conversation,
+	error,
+) {
+	var convo Conversation
+
+	row := c.Conn.QueryRow(
+		`select count(*) from conversations where id = ?`,
+		conversationId,
+	)
+
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return convo, err
+	}
+
+	if count == 0 {
+		return convo, fmt.Errorf("Conversation %d does not exist.", conversationId)
+	}
+
+	convo.Id = conversationId
+
+	var documentSelection *string
+	row = c.Conn.QueryRow(
+		`select document_selection from conversations where id = ?`,
+		conversationId,
+	)
+	if err := row.Scan(&documentSelection); err != nil {
+		return convo, err
+	}
+
+	if documentSelection != nil {
+		if err := json.Unmarshal([]byte(*documentSelection), &convo.DocumentSelection); err != nil {
+			return convo, err
+		}
+	}
 
 	messageRows, err := c.Conn.Query(`
 		select
 			id,
 			role,
-			content
+			content,
+			tool_calls,
+			tool_call_id,
+			is_error,
+			interrupted,
+			model,
+			input_tokens,
+			output_tokens,
+			cache_read_tokens,
+			cache_write_tokens,
+			stop_reason
 		from messages where conversation_id = ?
+		order by id
 	`, conversationId)
 
 	if err != nil {
 		return convo, err
 	}
 
+	defer messageRows.Close()
+
 	for {
 		if messageRows.Next() == false {
 			break
@@ -81,20 +218,225 @@ func (c *DatabaseClient) FetchRecentConversation() (
 		var messageId *int64
 		var role string
 		var content string
+		var toolCalls *string
+		var toolCallId *string
+		var isError bool
+		var interrupted bool
+		var model *string
+		var usage Usage
+		var stopReason *string
+
+		err = messageRows.Scan(
+			&messageId,
+			&role,
+			&content,
+			&toolCalls,
+			&toolCallId,
+			&isError,
+			&interrupted,
+			&model,
+			&usage.InputTokens,
+			&usage.OutputTokens,
+			&usage.CacheReadTokens,
+			&usage.CacheWriteTokens,
+			&stopReason,
+		)
+		if err != nil {
+			return convo, err
+		}
+
+		message := Message{
+			Id:          *messageId,
+			Role:        role,
+			Content:     content,
+			IsError:     isError,
+			Interrupted: interrupted,
+			Usage:       usage,
+		}
+
+		if model != nil {
+			message.Model = *model
+		}
+
+		if stopReason != nil {
+			message.StopReason = *stopReason
+		}
 
-		if err = messageRows.Scan(&messageId, &role, &content); err != nil {
+		if toolCalls != nil {
+			if err = json.Unmarshal([]byte(*toolCalls), &message.ToolCalls); err != nil {
+				return convo, err
+			}
+		}
+
+		if toolCallId != nil {
+			message.ToolCallId = *toolCallId
+		}
+
+		convo.Messages = append(convo.Messages, message)
+
+	}
+
+	if err = messageRows.Err(); err != nil {
+		return convo, err
+	}
+
+	documentRows, err := c.Conn.Query(`
+		select path from conversation_documents
+		where conversation_id = ?
+		order by rowid
+	`, conversationId)
+
+	if err != nil {
+		return convo, err
+	}
+
+	defer documentRows.Close()
+
+	for documentRows.Next() {
+		var path string
+		if err = documentRows.Scan(&path); err != nil {
 			return convo, err
 		}
+		convo.DocumentPaths = append(convo.DocumentPaths, path)
+	}
 
-		convo.Messages = append(convo.Messages, Message{
-			Id:      *messageId,
-			Role:    role,
-			Content: content,
-		})
+	return convo, documentRows.Err()
+}
 
+// Records the paths of the documents selected as the context of a
+// conversation, so that the same documents can be used when the conversation
+// is continued.
+func (c *DatabaseClient) RecordConversationDocuments(conversationId int64, paths []string) error {
+	tx, err := c.Conn.Begin()
+	if err != nil {
+		return err
 	}
 
-	return convo, nil
+	defer tx.Rollback()
+
+	for _, path := range paths {
+		_, err = tx.Exec(
+			"insert into conversation_documents(conversation_id, path) values(?, ?)",
+			conversationId,
+			path,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Records the patterns that adjusted the documents loaded for a conversation,
+// so that the same documents can be loaded when the conversation is continued.
+// Replaces any previously recorded selection.
+func (c *DatabaseClient) RecordDocumentSelection(conversationId int64, selection DocumentSelection) error {
+	encoded, err := json.Marshal(selection)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Conn.Exec(
+		"update conversations set document_selection = ? where id = ?",
+		string(encoded),
+		conversationId,
+	)
+
+	return err
+}
+
+// Lists stored conversations, most recent first. At most `limit` conversations
+// are returned, skipping the first `offset` ones, which lets the caller page
+// through the history.
+func (c *DatabaseClient) ListConversations(limit int, offset int) (
+	[]ConversationSummary,
+	error,
+) {
+	var summaries []ConversationSummary
+
+	rows, err := c.Conn.Query(`
+		select
+			c.id,
+			c.created_at,
+			(
+				select m.content from messages m
+				where m.conversation_id = c.id and m.role = 'user'
+				order by m.id
+				limit 1
+			) as first_user_message,
+			(
+				select count(*) from messages m
+				where m.conversation_id = c.id
+			) as message_count
+		from conversations c
+		order by c.id desc
+		limit ? offset ?
+	`, limit, offset)
+
+	if err != nil {
+		return summaries, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var summary ConversationSummary
+		var firstUserMessage *string
+
+		err = rows.Scan(
+			&summary.Id,
+			&summary.CreatedAt,
+			&firstUserMessage,
+			&summary.MessageCount,
+		)
+
+		if err != nil {
+			return summaries, err
+		}
+
+		if firstUserMessage != nil {
+			summary.FirstUserMessage = *firstUserMessage
+		}
+
+		summaries = append(summaries, summary)
+	}
+
+	return summaries, rows.Err()
+}
+
+// Deletes the conversations with the given ids, along with their messages.
+// Returns the number of conversations that were actually deleted, which may be
+// lower than the number of ids if some of them do not exist.
+func (c *DatabaseClient) DeleteConversations(conversationIds []int64) (int64, error) {
+	tx, err := c.Conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	var deleted int64
+
+	for _, id := range conversationIds {
+		result, err := tx.Exec("delete from conversations where id = ?", id)
+		if err != nil {
+			return 0, err
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+
+		deleted += affected
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
 }
 
 func (c *DatabaseClient) InsertMessageIntoConversation(
@@ -137,6 +479,158 @@ func (c *DatabaseClient) InsertMessageIntoConversation(
 	return message, nil
 }
 
+// Records the tools called by the LLM in the given (assistant) message.
+func (c *DatabaseClient) RecordToolCalls(messageId int64, toolCalls []ToolCall) error {
+	encoded, err := json.Marshal(toolCalls)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Conn.Exec(
+		"update messages set tool_calls = ? where id = ?",
+		string(encoded),
+		messageId,
+	)
+
+	return err
+}
+
+// Inserts a message containing the result of a tool call into the
+// conversation. If the call failed, the content is the error.
+func (c *DatabaseClient) InsertToolResult(
+	conversationId int64,
+	toolCallId string,
+	content string,
+	isError bool,
+) (Message, error) {
+	message, err := c.InsertMessageIntoConversation(conversationId, "tool", content)
+	if err != nil {
+		return message, err
+	}
+
+	_, err = c.Conn.Exec(
+		"update messages set tool_call_id = ?, is_error = ? where id = ?",
+		toolCallId,
+		isError,
+		message.Id,
+	)
+	if err != nil {
+		return message, err
+	}
+
+	message.ToolCallId = toolCallId
+	message.IsError = isError
+
+	return message, nil
+}
+
+// Marks a message as interrupted, i.e. incomplete.
+func (c *DatabaseClient) MarkMessageInterrupted(messageId int64) error {
+	_, err := c.Conn.Exec(
+		"update messages set interrupted = true where id = ?",
+		messageId,
+	)
+
+	return err
+}
+
+// Records the model that generated the given (assistant) message and the
+// tokens used by the request.
+func (c *DatabaseClient) RecordUsage(messageId int64, model string, usage Usage) error {
+	_, err := c.Conn.Exec(`
+		update messages set
+			model = ?,
+			input_tokens = ?,
+			output_tokens = ?,
+			cache_read_tokens = ?,
+			cache_write_tokens = ?
+		where id = ?
+	`,
+		model,
+		usage.InputTokens,
+		usage.OutputTokens,
+		usage.CacheReadTokens,
+		usage.CacheWriteTokens,
+		messageId,
+	)
+
+	return err
+}
+
+// Records why the LLM stopped generating the given (assistant) message.
+func (c *DatabaseClient) RecordStopReason(messageId int64, stopReason string) error {
+	_, err := c.Conn.Exec(
+		"update messages set stop_reason = ? where id = ?",
+		stopReason,
+		messageId,
+	)
+
+	return err
+}
+
+// Sums up the recorded usage by the given grouping and by model, since each
+// model is priced differently. Conversations and days are listed most recent
+// first; models are listed in alphabetical order.
+func (c *DatabaseClient) SummarizeUsage(grouping UsageGrouping) ([]UsageSummary, error) {
+	var summaries []UsageSummary
+
+	var groupExpression, order string
+
+	switch grouping {
+	case UsageByConversation:
+		groupExpression, order = "conversation_id", "conversation_id desc"
+	case UsageByModel:
+		groupExpression, order = "model", "model"
+	case UsageByDay:
+		groupExpression, order = "date(created_at, 'localtime')", "1 desc"
+	default:
+		return summaries, fmt.Errorf("Unsupported grouping of usage: %s.", grouping)
+	}
+
+	rows, err := c.Conn.Query(fmt.Sprintf(`
+		select
+			%s,
+			model,
+			count(*),
+			sum(input_tokens),
+			sum(output_tokens),
+			sum(cache_read_tokens),
+			sum(cache_write_tokens)
+		from messages
+		where model is not null
+		group by 1, 2
+		order by %s, 2
+	`, groupExpression, order))
+
+	if err != nil {
+		return summaries, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var summary UsageSummary
+
+		err = rows.Scan(
+			&summary.Group,
+			&summary.Model,
+			&summary.MessageCount,
+			&summary.Usage.InputTokens,
+			&summary.Usage.OutputTokens,
+			&summary.Usage.CacheReadTokens,
+			&summary.Usage.CacheWriteTokens,
+		)
+
+		if err != nil {
+			return summaries, err
+		}
+
+		summaries = append(summaries, summary)
+	}
+
+	return summaries, rows.Err()
+}
+
 // Extends the existing content of a message with the provided text (used for
 // recording streaming responses from an LLM chat).
 func (c *DatabaseClient) WriteToMessage(messageId int64, text string) error {
@@ -1,8 +1,10 @@
 package persistence
 
 import (
+	"fmt"
 	"github.com/malinowskip/pal/testutil"
 	"testing"
+	"time"
 )
 
 func TestInitializeConversation(t *testing.T) {
@@ -165,3 +167,348 @@ func TestPruneOldConversations(t *testing.T) {
 
 	testutil.AssertDeepEquals(t, orphanMessageCount, 0)
 }
+
+func TestFetchConversation(t *testing.T) {
+	projectPath := t.TempDir()
+	client, err := StartClient(projectPath)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	first, _ := client.InitializeConversation()
+	client.InsertMessageIntoConversation(first.Id, "user", "first")
+	second, _ := client.InitializeConversation()
+	client.InsertMessageIntoConversation(second.Id, "user", "second")
+
+	convo, err := client.FetchConversation(first.Id)
+	if err != nil {
+		t.Error(err)
+	}
+
+	expectedConvo := Conversation{Id: 1, Messages: []Message{
+		{Id: 1, Role: "user", Content: "first"},
+	}}
+
+	testutil.AssertDeepEquals(t, convo, expectedConvo)
+
+	t.Run("Returns an error if the conversation does not exist", func(t *testing.T) {
+		_, err := client.FetchConversation(42)
+		if err == nil {
+			t.Error("Fetching a non-existent conversation should return an error.")
+		}
+	})
+}
+
+func TestListConversations(t *testing.T) {
+	projectPath := t.TempDir()
+	client, err := StartClient(projectPath)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	for i := 1; i <= 5; i++ {
+		convo, _ := client.InitializeConversation()
+		client.InsertMessageIntoConversation(convo.Id, "user", fmt.Sprintf("Question %d", i))
+		client.InsertMessageIntoConversation(convo.Id, "assistant", "Answer")
+		client.InsertMessageIntoConversation(convo.Id, "user", "Follow-up")
+	}
+
+	client.InitializeConversation()
+
+	summaries, err := client.ListConversations(3, 0)
+	if err != nil {
+		t.Error(err)
+	}
+
+	testutil.AssertLength(t, summaries, 3)
+
+	// The most recent conversation is listed first, even though it’s empty.
+	testutil.AssertDeepEquals(t, summaries[0].Id, int64(6))
+	testutil.AssertDeepEquals(t, summaries[0].FirstUserMessage, "")
+	testutil.AssertDeepEquals(t, summaries[0].MessageCount, 0)
+
+	testutil.AssertDeepEquals(t, summaries[1].Id, int64(5))
+	testutil.AssertDeepEquals(t, summaries[1].FirstUserMessage, "Question 5")
+	testutil.AssertDeepEquals(t, summaries[1].MessageCount, 3)
+
+	if summaries[1].CreatedAt.IsZero() {
+		t.Error("The creation time of the conversation should be set.")
+	}
+
+	t.Run("Pages through the results", func(t *testing.T) {
+		summaries, err := client.ListConversations(3, 3)
+		if err != nil {
+			t.Error(err)
+		}
+
+		testutil.AssertLength(t, summaries, 3)
+		testutil.AssertDeepEquals(t, summaries[2].Id, int64(1))
+	})
+}
+
+func TestDeleteConversations(t *testing.T) {
+	projectPath := t.TempDir()
+	client, err := StartClient(projectPath)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		convo, _ := client.InitializeConversation()
+		client.InsertMessageIntoConversation(convo.Id, "user", "Hello, world!")
+	}
+
+	deleted, err := client.DeleteConversations([]int64{1, 3, 42})
+	if err != nil {
+		t.Error(err)
+	}
+
+	testutil.AssertDeepEquals(t, deleted, int64(2))
+
+	summaries, _ := client.ListConversations(10, 0)
+	testutil.AssertLength(t, summaries, 1)
+	testutil.AssertDeepEquals(t, summaries[0].Id, int64(2))
+
+	var messageCount int
+	client.Conn.QueryRow("select count(*) from messages").Scan(&messageCount)
+	testutil.AssertDeepEquals(t, messageCount, 1)
+}
+
+func TestRecordConversationDocuments(t *testing.T) {
+	projectPath := t.TempDir()
+	client, err := StartClient(projectPath)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	first, _ := client.InitializeConversation()
+	second, _ := client.InitializeConversation()
+
+	if err = client.RecordConversationDocuments(first.Id, []string{"b.md", "a.md"}); err != nil {
+		t.Error(err)
+	}
+
+	convo, err := client.FetchConversation(first.Id)
+	if err != nil {
+		t.Error(err)
+	}
+
+	testutil.AssertDeepEquals(t, convo.DocumentPaths, []string{"b.md", "a.md"})
+
+	convo, err = client.FetchConversation(second.Id)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if convo.DocumentPaths != nil {
+		t.Error("Conversations without recorded documents should have nil document paths.")
+	}
+
+	if _, err = client.DeleteConversations([]int64{first.Id}); err != nil {
+		t.Error(err)
+	}
+
+	var count int
+	client.Conn.QueryRow("select count(*) from conversation_documents").Scan(&count)
+	testutil.AssertDeepEquals(t, count, 0)
+}
+
+func TestRecordDocumentSelection(t *testing.T) {
+	client, err := StartClient(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	convo, _ := client.InitializeConversation()
+
+	if convo.DocumentSelection != nil {
+		t.Error("Conversations without a recorded selection should have a nil selection.")
+	}
+
+	selection := DocumentSelection{Only: []string{"app/**"}, Exclude: []string{"*_test.go"}}
+	if err = client.RecordDocumentSelection(convo.Id, selection); err != nil {
+		t.Fatal(err)
+	}
+
+	convo, err = client.FetchConversation(convo.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.AssertDeepEquals(t, convo.DocumentSelection, &selection)
+}
+
+func TestRecordToolCalls(t *testing.T) {
+	projectPath := t.TempDir()
+	client, err := StartClient(projectPath)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	convo, _ := client.InitializeConversation()
+
+	client.InsertMessageIntoConversation(convo.Id, "user", "What’s in main.go?")
+	assistantMessage, _ := client.InsertMessageIntoConversation(convo.Id, "assistant", "")
+
+	toolCalls := []ToolCall{{Id: "call_1", Name: "read_file", Arguments: `{"path":"main.go"}`}}
+
+	if err = client.RecordToolCalls(assistantMessage.Id, toolCalls); err != nil {
+		t.Error(err)
+	}
+
+	toolResult, err := client.InsertToolResult(convo.Id, "call_1", "package main", false)
+	if err != nil {
+		t.Error(err)
+	}
+
+	testutil.AssertDeepEquals(t, toolResult.ToolCallId, "call_1")
+
+	// A failed call.
+	if _, err = client.InsertToolResult(convo.Id, "call_2", "Unknown tool: write_file.", true); err != nil {
+		t.Error(err)
+	}
+
+	convo, err = client.FetchConversation(convo.Id)
+	if err != nil {
+		t.Error(err)
+	}
+
+	testutil.AssertDeepEquals(t, convo.Messages, []Message{
+		{Id: 1, Role: "user", Content: "What’s in main.go?"},
+		{Id: 2, Role: "assistant", Content: "", ToolCalls: toolCalls},
+		{Id: 3, Role: "tool", Content: "package main", ToolCallId: "call_1"},
+		{Id: 4, Role: "tool", Content: "Unknown tool: write_file.", ToolCallId: "call_2", IsError: true},
+	})
+}
+
+func TestMarkMessageInterrupted(t *testing.T) {
+	projectPath := t.TempDir()
+	client, err := StartClient(projectPath)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	convo, _ := client.InitializeConversation()
+
+	complete, _ := client.InsertMessageIntoConversation(convo.Id, "assistant", "Hello, world!")
+	interrupted, _ := client.InsertMessageIntoConversation(convo.Id, "assistant", "Hel")
+
+	if err = client.MarkMessageInterrupted(interrupted.Id); err != nil {
+		t.Error(err)
+	}
+
+	convo, err = client.FetchConversation(convo.Id)
+	if err != nil {
+		t.Error(err)
+	}
+
+	testutil.AssertDeepEquals(t, convo.Messages, []Message{
+		{Id: complete.Id, Role: "assistant", Content: "Hello, world!"},
+		{Id: interrupted.Id, Role: "assistant", Content: "Hel", Interrupted: true},
+	})
+}
+
+func TestRecordUsage(t *testing.T) {
+	projectPath := t.TempDir()
+	client, err := StartClient(projectPath)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	first, _ := client.InitializeConversation()
+	second, _ := client.InitializeConversation()
+
+	client.InsertMessageIntoConversation(first.Id, "user", "Hello")
+
+	usage := Usage{InputTokens: 100, OutputTokens: 20, CacheReadTokens: 1000, CacheWriteTokens: 10}
+
+	for _, convo := range []Conversation{first, first, second} {
+		reply, _ := client.InsertMessageIntoConversation(convo.Id, "assistant", "Hi")
+		if err = client.RecordUsage(reply.Id, "gpt-4o-mini", usage); err != nil {
+			t.Error(err)
+		}
+	}
+
+	reply, _ := client.InsertMessageIntoConversation(second.Id, "assistant", "Hi")
+	client.RecordUsage(reply.Id, "gpt-4o", usage)
+
+	t.Run("Usage is fetched with the conversation", func(t *testing.T) {
+		convo, err := client.FetchConversation(second.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testutil.AssertDeepEquals(t, convo.Messages[0].Model, "gpt-4o-mini")
+		testutil.AssertDeepEquals(t, convo.Messages[0].Usage, usage)
+	})
+
+	t.Run("Usage is summarized by conversation", func(t *testing.T) {
+		summaries, err := client.SummarizeUsage(UsageByConversation)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testutil.AssertDeepEquals(t, summaries, []UsageSummary{
+			{Group: fmt.Sprint(second.Id), Model: "gpt-4o", MessageCount: 1, Usage: usage},
+			{Group: fmt.Sprint(second.Id), Model: "gpt-4o-mini", MessageCount: 1, Usage: usage},
+			{
+				Group:        fmt.Sprint(first.Id),
+				Model:        "gpt-4o-mini",
+				MessageCount: 2,
+				Usage:        Usage{InputTokens: 200, OutputTokens: 40, CacheReadTokens: 2000, CacheWriteTokens: 20},
+			},
+		})
+	})
+
+	t.Run("Usage is summarized by model", func(t *testing.T) {
+		summaries, err := client.SummarizeUsage(UsageByModel)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testutil.AssertLength(t, summaries, 2)
+		testutil.AssertDeepEquals(t, summaries[0].Group, "gpt-4o")
+		testutil.AssertDeepEquals(t, summaries[1].Group, "gpt-4o-mini")
+		testutil.AssertDeepEquals(t, summaries[1].MessageCount, 3)
+	})
+
+	t.Run("Usage is summarized by day", func(t *testing.T) {
+		summaries, err := client.SummarizeUsage(UsageByDay)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testutil.AssertLength(t, summaries, 2)
+		testutil.AssertDeepEquals(t, summaries[0].Group, time.Now().Format("2006-01-02"))
+	})
+}
+
+func TestRecordStopReason(t *testing.T) {
+	projectPath := t.TempDir()
+	client, err := StartClient(projectPath)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	convo, _ := client.InitializeConversation()
+	reply, _ := client.InsertMessageIntoConversation(convo.Id, "assistant", "Hel")
+
+	if err = client.RecordStopReason(reply.Id, "max_tokens"); err != nil {
+		t.Error(err)
+	}
+
+	convo, err = client.FetchConversation(convo.Id)
+	if err != nil {
+		t.Error(err)
+	}
+
+	testutil.AssertDeepEquals(t, convo.Messages[0].StopReason, "max_tokens")
+}
@@ -1,6 +1,8 @@
 package testutil
 
 import (
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -38,3 +40,18 @@ func AssertNotContains[T any](t *testing.T, items []T, fn func(item T) bool) {
 	}
 
 }
+
+// Creates the files (paths relative to the root, mapped to their contents),
+// along with any missing directories.
+func WriteTestFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		abs := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(abs), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(abs, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
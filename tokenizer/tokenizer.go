@@ -0,0 +1,118 @@
+// Offline token counting. Token counts are used to check the size of the
+// context against the configured limits and to help the user estimate the
+// expected token usage.
+
+package tokenizer
+
+import (
+	"math"
+	"github.com/malinowskip/pal/config"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tiktoken-go/tokenizer"
+)
+
+// A Tokenizer counts the tokens in a piece of text, as seen by a particular
+// model (or family of models).
+type Tokenizer interface {
+	// Returns the number of tokens in the text.
+	CountTokens(text string) int
+	// Name of the encoding used for counting, e.g. `o200k_base`.
+	Name() string
+}
+
+// Resolves the tokenizer matching the provider and model selected in the
+// config.
+//
+// OpenAI models are handled by the exact BPE encodings used by OpenAI, which
+// are embedded in the binary. Anthropic doesn’t publish its tokenizer, so
+// Claude models are handled by an approximation. For other providers, where
+// the model can be anything, the count is estimated based on the number of
+// characters.
+func ForConfig(conf *config.Config) Tokenizer {
+	switch conf.Provider {
+	case "openai":
+		return forOpenaiModel(conf.Openai.Model)
+	case "anthropic":
+		return &anthropicApproximation{base: newBpeTokenizer(tokenizer.Cl100kBase)}
+	default:
+		return &characterApproximation{}
+	}
+}
+
+// Selects the BPE encoding for an OpenAI model. Only the GPT-4 (excluding
+// GPT-4o) and GPT-3.5 families use `cl100k_base`; newer models, as well as
+// models unknown at the time of writing, use `o200k_base`.
+func forOpenaiModel(model string) Tokenizer {
+	cl100kPrefixes := []string{"gpt-4-", "gpt-3.5", "gpt-35"}
+
+	if model == "gpt-4" {
+		return newBpeTokenizer(tokenizer.Cl100kBase)
+	}
+
+	for _, prefix := range cl100kPrefixes {
+		if strings.HasPrefix(model, prefix) {
+			return newBpeTokenizer(tokenizer.Cl100kBase)
+		}
+	}
+
+	return newBpeTokenizer(tokenizer.O200kBase)
+}
+
+// Counts tokens exactly, using one of OpenAI’s BPE encodings.
+type bpeTokenizer struct {
+	codec tokenizer.Codec
+}
+
+func newBpeTokenizer(encoding tokenizer.Encoding) *bpeTokenizer {
+	// Only supported encodings are passed here, so the error can be ignored.
+	codec, _ := tokenizer.Get(encoding)
+
+	return &bpeTokenizer{codec: codec}
+}
+
+func (t *bpeTokenizer) CountTokens(text string) int {
+	ids, _, err := t.codec.Encode(text)
+
+	// Encoding fails only on invalid input, in which case an estimate is better
+	// than nothing.
+	if err != nil {
+		return (&characterApproximation{}).CountTokens(text)
+	}
+
+	return len(ids)
+}
+
+func (t *bpeTokenizer) Name() string {
+	return t.codec.GetName()
+}
+
+// Claude’s tokenizer is not public. On source code and English prose, Claude
+// models produce somewhat more tokens than OpenAI’s `cl100k_base` encoding, so
+// the count is based on that encoding, scaled up by a fixed factor.
+type anthropicApproximation struct {
+	base Tokenizer
+}
+
+const anthropicScalingFactor = 1.1
+
+func (t *anthropicApproximation) CountTokens(text string) int {
+	return int(math.Ceil(float64(t.base.CountTokens(text)) * anthropicScalingFactor))
+}
+
+func (t *anthropicApproximation) Name() string {
+	return "approximation for Claude models"
+}
+
+// Estimates the number of tokens, assuming that a token corresponds to four
+// characters on average.
+type characterApproximation struct{}
+
+func (t *characterApproximation) CountTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+func (t *characterApproximation) Name() string {
+	return "approximation based on character count"
+}
@@ -0,0 +1,51 @@
+package tokenizer
+
+import (
+	"github.com/malinowskip/pal/config"
+	"github.com/malinowskip/pal/testutil"
+	"strings"
+	"testing"
+)
+
+func TestForConfig(t *testing.T) {
+	expectedNames := map[string]string{
+		"openai:gpt-4o-mini":   "o200k_base",
+		"openai:4o":            "o200k_base",
+		"openai:o1-preview":    "o200k_base",
+		"openai:gpt-4":         "cl100k_base",
+		"openai:gpt-4-turbo":   "cl100k_base",
+		"openai:gpt-3.5-turbo": "cl100k_base",
+		"openai:llama3":        "o200k_base",
+		"anthropic:haiku":      "approximation for Claude models",
+		"ollama:llama3.2":      "approximation based on character count",
+		"testing:":             "approximation based on character count",
+	}
+
+	for input, expectedName := range expectedNames {
+		conf := config.DefaultConfig()
+		conf.Provider, conf.Openai.Model, _ = strings.Cut(input, ":")
+		conf.Anthropic.Model = conf.Openai.Model
+		conf.Ollama.Model = conf.Openai.Model
+
+		if name := ForConfig(&conf).Name(); name != expectedName {
+			t.Errorf("%s should be handled by %s (actual: %s)", input, expectedName, name)
+		}
+	}
+}
+
+func TestCountTokens(t *testing.T) {
+	text := "Hello, world!"
+
+	testutil.AssertDeepEquals(t, newBpeTokenizer("o200k_base").CountTokens(text), 4)
+	testutil.AssertDeepEquals(t, newBpeTokenizer("cl100k_base").CountTokens(text), 4)
+	testutil.AssertDeepEquals(t, newBpeTokenizer("o200k_base").CountTokens(""), 0)
+
+	anthropic := &anthropicApproximation{base: newBpeTokenizer("cl100k_base")}
+	testutil.AssertDeepEquals(t, anthropic.CountTokens(text), 5)
+
+	characters := &characterApproximation{}
+	testutil.AssertDeepEquals(t, characters.CountTokens(""), 0)
+	testutil.AssertDeepEquals(t, characters.CountTokens("abcd"), 1)
+	testutil.AssertDeepEquals(t, characters.CountTokens("abcde"), 2)
+	testutil.AssertDeepEquals(t, characters.CountTokens("żółć"), 1)
+}